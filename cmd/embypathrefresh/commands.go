@@ -0,0 +1,577 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/config"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/processor"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: embypathrefresh [-config path] [command]
+
+commands:
+  (none)                      run the daemon
+  trash list                  list files in the trash
+  trash restore [-emby] <id>  restore the trashed source of record <id>
+  blocked list                list records whose source deletion was blocked
+  blocked retry <id>|-all     queue blocked records for deletion again
+  breaker status              show the deletion circuit breaker state
+  breaker trip <reason>       halt all deletion until reset
+  breaker reset               re-arm deletion after a trip
+  undo <id>                   move record <id> back to its source and revert emby
+  undo -path <path>           undo the latest migration of a source or target path
+  undo -since <time> [-until <time>]
+                              undo migrations processed in a time range
+  locate <path>               show the current location and move history of a file
+  report [-since <time>] [-until <time>] [-html]
+                              print a summary of migration activity (default: last 24h)
+  migrations                  show applied and pending database schema migrations
+  events [-id <id>] [-path <path>] [-since <time>] [-until <time>] [-limit <n>]
+                              show the audit trail of record state changes
+  export records|events [-format jsonl|csv] [-status <s,...>] [-hop <n>] [-prefix <path>]
+         [-since <time>] [-until <time>] [-o <file>]
+                              write migration records or audit events to stdout or a file
+  import records|events [-format jsonl|csv] [-conflict skip|replace|fail] <file>|-
+                              load exported records or events, keeping their ids`
+
+func runCommand(cfg *config.Config, logger *zap.Logger, args []string) error {
+	switch args[0] {
+	case "trash":
+		return runTrash(cfg, logger, args[1:])
+	case "blocked":
+		return runBlocked(cfg, logger, args[1:])
+	case "breaker":
+		return runBreaker(cfg, logger, args[1:])
+	case "undo":
+		return runUndo(cfg, logger, args[1:])
+	case "locate":
+		return runLocate(cfg, logger, args[1:])
+	case "report":
+		return runReport(cfg, logger, args[1:])
+	case "migrations":
+		return runMigrations(cfg, logger, args[1:])
+	case "events":
+		return runEvents(cfg, logger, args[1:])
+	case "export":
+		return runExport(cfg, logger, args[1:])
+	case "import":
+		return runImport(cfg, logger, args[1:])
+	case "help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// cliProcessor 创建命令使用的处理器，审计事件的操作方记为 cli，存储后端在用到时才连接
+func cliProcessor(cfg *config.Config, logger *zap.Logger) (*processor.Processor, error) {
+	return newProcessor(cfg, logger, true, processor.WithActor(processor.ActorCLI))
+}
+
+func runTrash(cfg *config.Config, logger *zap.Logger, args []string) error {
+	if !cfg.Trash.Enabled {
+		return fmt.Errorf("trash is not enabled in config")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("missing trash subcommand\n%s", usage)
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	switch args[0] {
+	case "list":
+		entries, err := proc.ListTrash()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "RECORD\tSIZE\tTRASHED\tEXPIRES\tSOURCE")
+		for _, e := range entries {
+			expires := "-"
+			if !e.ExpiresAt.IsZero() {
+				expires = e.ExpiresAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n",
+				e.RecordID, e.Size, e.TrashedAt.Format(time.DateTime), expires, e.SourcePath)
+		}
+		return tw.Flush()
+
+	case "restore":
+		fs := flag.NewFlagSet("trash restore", flag.ContinueOnError)
+		pointEmby := fs.Bool("emby", false, "point emby back at the restored source even if the target still exists")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: trash restore [-emby] <record-id>")
+		}
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid record id %q", fs.Arg(0))
+		}
+		if err := proc.RestoreTrash(id, *pointEmby); err != nil {
+			return err
+		}
+		fmt.Printf("restored record %d\n", id)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown trash subcommand %q", args[0])
+	}
+}
+
+func runBlocked(cfg *config.Config, logger *zap.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing blocked subcommand\n%s", usage)
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	switch args[0] {
+	case "list":
+		records, err := proc.BlockedRecords()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "RECORD\tBLOCKED\tSOURCE\tREASON")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n",
+				r.ID, r.UpdatedAt.Format(time.DateTime), r.SourcePath, r.BlockReason)
+		}
+		return tw.Flush()
+
+	case "retry":
+		fs := flag.NewFlagSet("blocked retry", flag.ContinueOnError)
+		all := fs.Bool("all", false, "retry all blocked records")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var id int64
+		switch {
+		case *all && fs.NArg() == 0:
+		case !*all && fs.NArg() == 1:
+			if id, err = strconv.ParseInt(fs.Arg(0), 10, 64); err != nil || id <= 0 {
+				return fmt.Errorf("invalid record id %q", fs.Arg(0))
+			}
+		default:
+			return fmt.Errorf("usage: blocked retry <record-id>|-all")
+		}
+		n, err := proc.RetryBlocked(id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("requeued %d record(s)\n", n)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown blocked subcommand %q", args[0])
+	}
+}
+
+func runBreaker(cfg *config.Config, logger *zap.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing breaker subcommand\n%s", usage)
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	switch args[0] {
+	case "status":
+		state, err := proc.BreakerStatus()
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			fmt.Println("armed: deletion is allowed")
+			return nil
+		}
+		fmt.Printf("tripped at %s: %s\n", state.TrippedAt.Format(time.DateTime), state.Reason)
+		return nil
+
+	case "trip":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: breaker trip <reason>")
+		}
+		if err := proc.TripBreaker(strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+		fmt.Println("breaker tripped, deletion halted")
+		return nil
+
+	case "reset":
+		if err := proc.ResetBreaker(); err != nil {
+			return err
+		}
+		fmt.Println("breaker reset, deletion re-armed")
+		return nil
+
+	default:
+		return fmt.Errorf("unknown breaker subcommand %q", args[0])
+	}
+}
+
+func runUndo(cfg *config.Config, logger *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	path := fs.String("path", "", "undo the latest migration of this source or target path")
+	since := fs.String("since", "", "undo migrations processed at or after this time")
+	until := fs.String("until", "", "with -since, undo migrations processed before this time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var sel processor.UndoSelector
+	var err error
+	switch {
+	case *path != "" && *since == "" && fs.NArg() == 0:
+		sel.Path = *path
+	case *since != "" && *path == "" && fs.NArg() == 0:
+		if sel.From, err = parseTime(*since); err != nil {
+			return err
+		}
+		if *until != "" {
+			if sel.To, err = parseTime(*until); err != nil {
+				return err
+			}
+		}
+	case *path == "" && *since == "" && fs.NArg() == 1:
+		if sel.ID, err = strconv.ParseInt(fs.Arg(0), 10, 64); err != nil || sel.ID <= 0 {
+			return fmt.Errorf("invalid record id %q", fs.Arg(0))
+		}
+	default:
+		return fmt.Errorf("usage: undo <record-id> | -path <path> | -since <time> [-until <time>]")
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	summary, err := proc.Undo(sel)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("undo %d: reverted %d record(s), %d failed\n", summary.ActionID, summary.Reverted, summary.Failed)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d record(s) could not be undone, see log", summary.Failed)
+	}
+	return nil
+}
+
+func runLocate(cfg *config.Config, logger *zap.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: locate <path>")
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	loc, err := proc.Locate(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current: %s\n\n", loc.Current)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOP\tRECORD\tSTATUS\tPROCESSED\tFROM\tTO")
+	for _, r := range loc.History {
+		processed := "-"
+		if !r.ProcessedTime.IsZero() {
+			processed = r.ProcessedTime.Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n",
+			r.Hop, r.ID, r.Status, processed, r.SourcePath, r.TargetPath)
+	}
+	return tw.Flush()
+}
+
+func runReport(cfg *config.Config, logger *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	since := fs.String("since", "", "start of the reported period (default: 24h before -until)")
+	until := fs.String("until", "", "end of the reported period (default: now)")
+	html := fs.Bool("html", false, "print HTML instead of Markdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: report [-since <time>] [-until <time>] [-html]")
+	}
+
+	to := time.Now()
+	var err error
+	if *until != "" {
+		if to, err = parseTime(*until); err != nil {
+			return err
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if *since != "" {
+		if from, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	report, err := proc.Report(from, to)
+	if err != nil {
+		return err
+	}
+	if !*html {
+		fmt.Print(report.Markdown())
+		return nil
+	}
+	text, err := report.HTML()
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+func runEvents(cfg *config.Config, logger *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	id := fs.Int64("id", 0, "only events of this record")
+	path := fs.String("path", "", "only events of records with this source or target path")
+	since := fs.String("since", "", "only events at or after this time")
+	until := fs.String("until", "", "only events before this time")
+	limit := fs.Int("limit", 0, "show at most this many events")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: events [-id <id>] [-path <path>] [-since <time>] [-until <time>] [-limit <n>]")
+	}
+
+	filter := processor.EventFilter{RecordID: *id, Path: *path, Limit: *limit}
+	var err error
+	if *since != "" {
+		if filter.From, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.To, err = parseTime(*until); err != nil {
+			return err
+		}
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	events, err := proc.Events(filter)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tRECORD\tEVENT\tACTOR\tPATH\tDETAILS")
+	for _, e := range events {
+		record := "-"
+		if e.RecordID != 0 {
+			record = strconv.FormatInt(e.RecordID, 10)
+		}
+		details := e.Details
+		if e.Error != "" {
+			details = strings.TrimPrefix(details+": "+e.Error, ": ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.CreatedAt.Format(time.DateTime), record, e.Event, e.Actor, e.Path, details)
+	}
+	return tw.Flush()
+}
+
+func runExport(cfg *config.Config, logger *zap.Logger, args []string) error {
+	const exportUsage = "usage: export records|events [-format jsonl|csv] [-status <s,...>] [-hop <n>] [-prefix <path>] [-since <time>] [-until <time>] [-o <file>]"
+	if len(args) == 0 || (args[0] != "records" && args[0] != "events") {
+		return fmt.Errorf(exportUsage)
+	}
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", processor.FormatJSONL, "output format: jsonl or csv")
+	status := fs.String("status", "", "only records with one of these comma-separated statuses")
+	hop := fs.Int("hop", -1, "only records of this directory mapping (0 = source to target, n = tier n to n+1)")
+	prefix := fs.String("prefix", "", "only records whose source or target path starts with this prefix")
+	since := fs.String("since", "", "only rows created at or after this time")
+	until := fs.String("until", "", "only rows created before this time")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf(exportUsage)
+	}
+
+	filter := processor.ExportFilter{Prefix: *prefix}
+	if *status != "" {
+		filter.Statuses = strings.Split(*status, ",")
+	}
+	if *hop >= 0 {
+		filter.Hop = hop
+	}
+	var err error
+	if *since != "" {
+		if filter.From, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.To, err = parseTime(*until); err != nil {
+			return err
+		}
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+	}
+	export := proc.ExportRecords
+	if args[0] == "events" {
+		export = proc.ExportEvents
+	}
+	n, err := export(w, *format, filter)
+	if *output != "" {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("exported %d %s to %s\n", n, args[0], *output)
+	}
+	return nil
+}
+
+func runImport(cfg *config.Config, logger *zap.Logger, args []string) error {
+	const importUsage = "usage: import records|events [-format jsonl|csv] [-conflict skip|replace|fail] <file>|-"
+	if len(args) == 0 || (args[0] != "records" && args[0] != "events") {
+		return fmt.Errorf(importUsage)
+	}
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", processor.FormatJSONL, "input format: jsonl or csv")
+	conflict := fs.String("conflict", processor.ConflictSkip,
+		"rows whose id already exists: skip, replace, or fail without importing anything")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf(importUsage)
+	}
+
+	r := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("open import file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	proc, err := cliProcessor(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("create processor: %w", err)
+	}
+	defer proc.Close()
+
+	load := proc.ImportRecords
+	if args[0] == "events" {
+		load = proc.ImportEvents
+	}
+	summary, err := load(r, *format, *conflict)
+	if summary != nil {
+		fmt.Printf("imported %d %s, replaced %d, skipped %d\n", summary.Imported, args[0], summary.Replaced, summary.Skipped)
+	}
+	return err
+}
+
+// runMigrations 显示数据库结构变更的状态，不执行变更
+func runMigrations(cfg *config.Config, logger *zap.Logger, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: migrations")
+	}
+
+	db, err := database.Open(cfg.Database.Driver, databaseDSN(cfg), logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	status, err := db.Status()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	pending := 0
+	for _, s := range status {
+		applied := "pending"
+		switch {
+		case s.Unknown:
+			applied = s.AppliedAt.Format(time.DateTime) + " (unknown, written by a newer version)"
+		case !s.AppliedAt.IsZero():
+			applied = s.AppliedAt.Format(time.DateTime)
+		default:
+			pending++
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if pending > 0 {
+		fmt.Printf("\n%d pending migration(s) will be applied at next start\n", pending)
+	}
+	return nil
+}
+
+// parseTime 解析命令行中的时间，支持 RFC3339 及本地时间 "2006-01-02 15:04:05" / "2006-01-02"
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/sleepstars/embypathrefresh/internal/config"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/emby"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
 	"github.com/sleepstars/embypathrefresh/internal/processor"
 	"github.com/sleepstars/embypathrefresh/internal/watcher"
 	"go.uber.org/zap"
@@ -10,10 +16,14 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 )
 
+// notifyCloseTimeout 退出时等待剩余通知发送的时间，通知渠道不可达时不阻塞退出
+const notifyCloseTimeout = 10 * time.Second
+
 func main() {
 	configPath := flag.String("config", "config.yaml", "path to config file")
 	flag.Parse()
@@ -43,20 +53,38 @@ func main() {
 		logger.Fatal("create log directory failed", zap.Error(err))
 	}
 
+	// 子命令
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg, logger, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 初始化通知，处理器关闭后再发送剩余的通知，最多等待 notifyCloseTimeout
+	notifier, err := newNotifier(cfg, logger)
+	if err != nil {
+		logger.Fatal("create notifier failed", zap.Error(err))
+	}
+	defer notifier.Close(notifyCloseTimeout)
+
 	// 初始化处理器
-	proc, err := processor.New(
-		cfg.Paths.EmbyDB,
-		cfg.Database.Path,
-		cfg.Paths.SourceDir,
-		cfg.Paths.TargetDir,
-		cfg.Timings.DeleteAfter,
-		logger,
-	)
+	proc, err := newProcessor(cfg, logger, false, processor.WithNotifier(notifier))
 	if err != nil {
 		logger.Fatal("create processor failed", zap.Error(err))
 	}
 	defer proc.Close()
 
+	// 切换上次退出前未完成的Emby路径
+	if err := proc.FlushEmby(); err != nil {
+		logger.Error("flush emby path switches failed", zap.Error(err))
+	}
+
+	// ctx 在排空超时后取消，中止仍在进行的复制和删除
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// 初始化文件监控
 	w, err := watcher.New(
 		cfg.Paths.SourceDir,
@@ -67,27 +95,553 @@ func main() {
 	if err != nil {
 		logger.Fatal("create watcher failed", zap.Error(err))
 	}
-	defer w.Close()
 
-	if err := w.Start(); err != nil {
+	if err := w.Start(ctx); err != nil {
 		logger.Fatal("start watcher failed", zap.Error(err))
 	}
 
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// 继续上次中断的大文件复制
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := proc.ResumeCopies(ctx); err != nil {
+			logger.Error("resume interrupted copies failed", zap.Error(err))
+		}
+	}()
+
 	// 定期清理文件
-	ticker := time.NewTicker(1 * time.Hour)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := proc.CleanupFiles(ctx); err != nil {
+					logger.Error("cleanup files failed", zap.Error(err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// 定期重试等待空间的文件
+	recheckInterval := cfg.Space.RecheckInterval
+	if recheckInterval <= 0 {
+		recheckInterval = 10 * time.Minute
+	}
+	wg.Add(1)
 	go func() {
-		for range ticker.C {
-			if err := proc.CleanupFiles(); err != nil {
-				logger.Error("cleanup files failed", zap.Error(err))
+		defer wg.Done()
+		ticker := time.NewTicker(recheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := proc.ResumeWaiting(ctx); err != nil {
+					logger.Error("resume waiting files failed", zap.Error(err))
+				}
+			case <-stop:
+				return
 			}
 		}
 	}()
 
+	// 定期按分层策略迁移文件
+	if cfg.Tiering.Interval > 0 && hasTieringPolicies(cfg) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(cfg.Tiering.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := proc.EvaluatePolicies(ctx); err != nil {
+						logger.Error("evaluate tiering policies failed", zap.Error(err))
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	// 定期将停留到期的文件迁移到下一级存储
+	if len(cfg.Tiers) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(1 * time.Hour)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := proc.AdvanceTiers(ctx); err != nil {
+						logger.Error("advance tiers failed", zap.Error(err))
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	// 定期将播放频繁的文件移回源目录
+	if cfg.Promotion.Interval > 0 && cfg.Promotion.MinPlays > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(cfg.Promotion.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := proc.PromoteActive(ctx); err != nil {
+						logger.Error("promote active files failed", zap.Error(err))
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	// 定期生成迁移汇总报告，按本地时间对齐到整天或整周
+	if cfg.Report.Interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			next := nextReport(time.Now(), cfg.Report.Interval)
+			timer := time.NewTimer(time.Until(next))
+			defer timer.Stop()
+			for {
+				select {
+				case <-timer.C:
+					from := reportStep(next, cfg.Report.Interval, -1)
+					if err := deliverReport(cfg, proc, logger, from, next); err != nil {
+						logger.Error("deliver report failed", zap.Error(err))
+					}
+					next = nextReport(time.Now(), cfg.Report.Interval)
+					timer.Reset(time.Until(next))
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
 	// 等待信号
-	sigChan := make(chan os.Signal, 1)
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 
-	logger.Info("shutting down...")
-	ticker.Stop()
+	drainTimeout := cfg.Shutdown.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 60 * time.Second
+	}
+	logger.Info("shutting down, waiting for in-flight work", zap.Duration("timeout", drainTimeout))
+
+	// 停止接收新任务，等待正在处理的文件完成
+	close(stop)
+	drained := make(chan struct{})
+	go func() {
+		if err := w.Close(); err != nil {
+			logger.Error("close watcher failed", zap.Error(err))
+		}
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		// 超时后取消 ctx，进行中的复制中止并回滚
+		logger.Warn("drain timeout exceeded, aborting in-flight work")
+		cancel()
+		select {
+		case <-drained:
+		case <-sigChan:
+			forceExit(logger)
+		}
+	case <-sigChan:
+		forceExit(logger)
+	}
+
+	if err := proc.FlushEmby(); err != nil {
+		logger.Error("flush emby path switches failed", zap.Error(err))
+	}
+	logger.Info("shutdown complete")
+}
+
+// forceExit 第二次收到信号时立即退出，不再等待进行中的任务
+func forceExit(logger *zap.Logger) {
+	logger.Warn("second signal received, exiting immediately")
+	logger.Sync()
+	os.Exit(1)
+}
+
+// newProcessor 按配置创建处理器。lazyBackends 为 true 时存储后端在第一次使用时才连接，
+// 只查询状态的命令不连接远程存储；守护进程在启动时连接，配置错误立即报出
+func newProcessor(cfg *config.Config, logger *zap.Logger, lazyBackends bool, extra ...processor.Option) (*processor.Processor, error) {
+	opts := []processor.Option{
+		processor.WithVerification(cfg.Cleanup.VerifyChecksum, cfg.Cleanup.MountMarker),
+		processor.WithDeletionLimits(processor.DeletionLimits{
+			MaxFilesPerRun: cfg.Cleanup.MaxFilesPerRun,
+			MaxBytesPerRun: gigabytes(cfg.Cleanup.MaxSizePerRunGB),
+			MaxFilesPerDay: cfg.Cleanup.MaxFilesPerDay,
+			MaxBytesPerDay: gigabytes(cfg.Cleanup.MaxSizePerDayGB),
+		}),
+		processor.WithCircuitBreaker(cfg.Cleanup.BreakerMissingRatio, cfg.Cleanup.BreakerMinFiles),
+		processor.WithSpaceReserve(gigabytes(cfg.Space.ReserveGB), cfg.Space.ReservePercent),
+	}
+	if cfg.Copy.ChunkSizeMB > 0 {
+		opts = append(opts, processor.WithResumableCopy(
+			gigabytes(cfg.Copy.ResumeMinSizeGB),
+			cfg.Copy.ChunkSizeMB<<20,
+			cfg.Copy.Retries,
+		))
+	}
+	if cfg.Emby.BatchSize > 1 {
+		batchWait := cfg.Emby.BatchWait
+		if batchWait <= 0 {
+			batchWait = 30 * time.Second
+		}
+		opts = append(opts, processor.WithEmbyBatch(cfg.Emby.BatchSize, batchWait))
+	}
+	if cfg.Emby.URL != "" && cfg.Emby.APIKey != "" {
+		client := emby.NewClient(cfg.Emby.URL, cfg.Emby.APIKey)
+		opts = append(opts, processor.WithEmbyRefresh(client, cfg.Emby.RefreshParents, cfg.Emby.RefreshConcurrency))
+	}
+	if perm := cfg.Permissions; perm.UID != nil || perm.GID != nil || perm.FileMode != "" || perm.DirMode != "" {
+		o := ownership(perm)
+		opts = append(opts, processor.WithOwnership(o.UID, o.GID, o.FileMode, o.DirMode))
+	}
+	if len(cfg.Tiering.Policies) > 0 {
+		opts = append(opts, processor.WithTieringPolicies(tieringPolicies(cfg.Tiering.Policies)))
+	}
+	if len(cfg.Tiers) > 0 {
+		var tiers []processor.Tier
+		for _, tier := range cfg.Tiers {
+			t := processor.Tier{
+				Dir:         tier.Dir,
+				UpdateAfter: tier.UpdateAfter,
+				DeleteAfter: tier.DeleteAfter,
+				Policies:    tieringPolicies(tier.Policies),
+			}
+			if tier.Permissions != nil {
+				o := ownership(*tier.Permissions)
+				t.Ownership = &o
+			}
+			var create func() (processor.Backend, error)
+			if s3 := tier.S3; s3 != nil {
+				create = func() (processor.Backend, error) {
+					return processor.NewS3Backend(context.Background(), processor.S3Options{
+						Endpoint:  s3.Endpoint,
+						UseSSL:    s3.UseSSL,
+						Region:    s3.Region,
+						AccessKey: s3.AccessKey,
+						SecretKey: s3.SecretKey,
+						Bucket:    s3.Bucket,
+						Prefix:    s3.Prefix,
+						PartSize:  s3.PartSizeMB << 20,
+					})
+				}
+			}
+			if sftp := tier.SFTP; sftp != nil {
+				create = func() (processor.Backend, error) {
+					return processor.NewSFTPBackend(processor.SFTPOptions{
+						Address:               sftp.Address,
+						User:                  sftp.User,
+						Password:              sftp.Password,
+						PrivateKeyFile:        sftp.PrivateKeyFile,
+						KnownHostsFile:        sftp.KnownHostsFile,
+						InsecureIgnoreHostKey: sftp.InsecureIgnoreHostKey,
+						RemoteDir:             sftp.RemoteDir,
+						Retries:               sftp.Retries,
+					}, logger)
+				}
+			}
+			if create != nil && lazyBackends {
+				t.Backend = processor.LazyBackend(create)
+			} else if create != nil {
+				backend, err := create()
+				if err != nil {
+					return nil, err
+				}
+				t.Backend = backend
+			}
+			if strm := tier.Strm; strm != nil {
+				tmpl, err := processor.ParseStrmURL(strm.URL)
+				if err != nil {
+					return nil, err
+				}
+				t.Strm = &processor.Strm{Dir: strm.Dir, URL: tmpl}
+			}
+			tiers = append(tiers, t)
+		}
+		opts = append(opts, processor.WithTiers(tiers))
+	}
+	if cfg.Promotion.MinPlays > 0 {
+		opts = append(opts, processor.WithPromotion(processor.PromotionPolicy{
+			Window:         time.Duration(cfg.Promotion.WindowDays) * 24 * time.Hour,
+			MinPlays:       cfg.Promotion.MinPlays,
+			SeriesDepth:    cfg.Promotion.SeriesDepth,
+			Cooldown:       time.Duration(cfg.Promotion.CooldownDays) * 24 * time.Hour,
+			MaxBytesPerRun: gigabytes(cfg.Promotion.MaxSizePerRunGB),
+		}))
+	}
+	if cfg.Cleanup.PruneEmptyDirs {
+		opts = append(opts, processor.WithDirPruning(cfg.Cleanup.JunkFiles))
+	}
+	if cfg.Trash.Enabled {
+		opts = append(opts, processor.WithTrash(
+			cfg.Trash.Dir,
+			cfg.Trash.Retention,
+			gigabytes(cfg.Trash.MaxSizeGB),
+		))
+	}
+	if len(cfg.Hooks) > 0 {
+		hooks := make([]processor.Hook, 0, len(cfg.Hooks))
+		for _, hook := range cfg.Hooks {
+			hooks = append(hooks, processor.Hook{
+				Event:   hook.Event,
+				Command: hook.Command,
+				Timeout: hook.Timeout,
+			})
+		}
+		opts = append(opts, processor.WithHooks(hooks))
+	}
+	if cfg.Cluster.Enabled {
+		instanceID := cfg.Cluster.InstanceID
+		if instanceID == "" {
+			host, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("get hostname: %w", err)
+			}
+			instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		opts = append(opts, processor.WithLeases(instanceID, cfg.Cluster.LeaseTTL))
+	}
+	opts = append(opts, extra...)
+
+	// 升级应用数据库的结构，连接交给处理器，由处理器关闭
+	db, err := database.New(cfg.Database.Driver, databaseDSN(cfg), logger)
+	if err != nil {
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+
+	proc, err := processor.New(
+		cfg.Paths.EmbyDB,
+		db.Store(),
+		cfg.Paths.SourceDir,
+		cfg.Paths.TargetDir,
+		cfg.Timings.DeleteAfter,
+		logger,
+		opts...,
+	)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return proc, nil
+}
+
+// databaseDSN 返回所选驱动的连接参数，SQLite 为文件路径
+func databaseDSN(cfg *config.Config) string {
+	if cfg.Database.Driver == database.DriverPostgres {
+		return cfg.Database.DSN
+	}
+	return cfg.Database.Path
+}
+
+// newNotifier 按配置创建通知渠道，没有配置时返回 nil
+func newNotifier(cfg *config.Config, logger *zap.Logger) (*notify.Notifier, error) {
+	if len(cfg.Notify.Sinks) == 0 {
+		return nil, nil
+	}
+	var routes []notify.Route
+	for _, s := range cfg.Notify.Sinks {
+		tmpl, err := notify.ParseTemplate(s.Template)
+		if err != nil {
+			return nil, fmt.Errorf("%s notification sink: %w", s.Type, err)
+		}
+		route := notify.Route{
+			Events:    s.Events,
+			Template:  tmpl,
+			RateLimit: s.RateLimit,
+			Retries:   s.Retries,
+		}
+		switch s.Type {
+		case "webhook":
+			route.Sink = &notify.Webhook{URL: s.URL, Headers: s.Headers}
+		case "telegram":
+			route.Sink = &notify.Telegram{Token: s.BotToken, ChatID: s.ChatID}
+		case "discord":
+			route.Sink = &notify.Discord{URL: s.URL}
+		case "smtp":
+			route.Sink = &notify.SMTP{
+				Address:  s.Address,
+				Username: s.Username,
+				Password: s.Password,
+				From:     s.From,
+				To:       s.To,
+			}
+		}
+		routes = append(routes, route)
+	}
+	return notify.New(logger, routes...), nil
+}
+
+// nextReport 返回 now 之后的下一个报告时间，按本地时间对齐：间隔为整周时从周一零点起算，
+// 为整天或不足一天时从当天零点起算
+func nextReport(now time.Time, interval time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	if interval%(7*24*time.Hour) == 0 {
+		next = next.AddDate(0, 0, -int(now.Weekday()+6)%7)
+	}
+	for !next.After(now) {
+		next = reportStep(next, interval, 1)
+	}
+	return next
+}
+
+// reportStep 返回 t 之后第 n 个报告间隔的时间。整天的间隔按日历天数计算，不受夏令时切换影响
+func reportStep(t time.Time, interval time.Duration, n int) time.Time {
+	if interval%(24*time.Hour) == 0 {
+		return t.AddDate(0, 0, n*int(interval/(24*time.Hour)))
+	}
+	return t.Add(time.Duration(n) * interval)
+}
+
+// ownership 将配置的属主和权限转换为处理器使用的形式，未设置的项保持不变
+func ownership(perm config.Permissions) processor.Ownership {
+	o := processor.Ownership{UID: -1, GID: -1}
+	if perm.UID != nil {
+		o.UID = *perm.UID
+	}
+	if perm.GID != nil {
+		o.GID = *perm.GID
+	}
+	// 权限格式已在加载配置时校验
+	o.FileMode, _ = config.ParseFileMode(perm.FileMode)
+	o.DirMode, _ = config.ParseFileMode(perm.DirMode)
+	return o
+}
+
+// hasTieringPolicies 报告是否为任一跳设置了分层策略
+func hasTieringPolicies(cfg *config.Config) bool {
+	if len(cfg.Tiering.Policies) > 0 {
+		return true
+	}
+	for _, tier := range cfg.Tiers {
+		if len(tier.Policies) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tieringPolicies 将配置中的分层策略转换为处理器的策略
+func tieringPolicies(policies []config.TieringPolicy) []processor.TieringPolicy {
+	var result []processor.TieringPolicy
+	for _, policy := range policies {
+		maxPlayCount := -1
+		if policy.MaxPlayCount != nil {
+			maxPlayCount = *policy.MaxPlayCount
+		}
+		result = append(result, processor.TieringPolicy{
+			Name:         policy.Name,
+			NotPlayedFor: time.Duration(policy.NotPlayedDays) * 24 * time.Hour,
+			AddedBefore:  time.Duration(policy.AddedDays) * 24 * time.Hour,
+			MinSize:      gigabytes(policy.MinSizeGB),
+			MaxPlayCount: maxPlayCount,
+		})
+	}
+	return result
+}
+
+// deliverReport 生成 [from, to) 的汇总报告，按配置写入日志、文件和邮件
+func deliverReport(cfg *config.Config, proc *processor.Processor, logger *zap.Logger, from, to time.Time) error {
+	report, err := proc.Report(from, to)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Report.Log {
+		files, bytes := report.Migrated()
+		failures := 0
+		for _, f := range report.Failures {
+			failures += f.Count
+		}
+		fields := []zap.Field{
+			zap.Time("from", from),
+			zap.Time("to", to),
+			zap.Int("migrated_files", files),
+			zap.Int64("migrated_bytes", bytes),
+			zap.Int("deleted_files", report.Deleted),
+			zap.Int64("deleted_bytes", report.DeletedBytes),
+			zap.Int("pending_deletes", report.PendingDeletes),
+			zap.Int("overdue_deletes", report.OverdueDeletes),
+			zap.Int("failures", failures),
+		}
+		if o := report.OldestPending; o != nil {
+			fields = append(fields, zap.String("oldest_pending", o.SourcePath), zap.Time("oldest_pending_since", o.CreatedAt))
+		}
+		logger.Info("migration report", fields...)
+	}
+	if cfg.Report.Dir == "" && cfg.Report.Email.Address == "" {
+		return nil
+	}
+
+	text, ext := report.Markdown(), ".md"
+	html := cfg.Report.Format == "html"
+	if html {
+		if text, err = report.HTML(); err != nil {
+			return err
+		}
+		ext = ".html"
+	}
+
+	var errs []error
+	if cfg.Report.Dir != "" {
+		path := filepath.Join(cfg.Report.Dir, "report-"+to.Format(time.DateOnly)+ext)
+		if err := os.MkdirAll(cfg.Report.Dir, 0755); err != nil {
+			errs = append(errs, fmt.Errorf("create report dir: %w", err))
+		} else if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			errs = append(errs, fmt.Errorf("write report: %w", err))
+		}
+	}
+	if email := cfg.Report.Email; email.Address != "" {
+		sink := &notify.SMTP{
+			Address:  email.Address,
+			Username: email.Username,
+			Password: email.Password,
+			From:     email.From,
+			To:       email.To,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := sink.Send(ctx, notify.Message{
+			Event:   notify.Event{Time: to},
+			Text:    text,
+			Subject: "embypathrefresh report " + to.Format(time.DateOnly),
+			HTML:    html,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("email report: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// gigabytes 将配置中的GB换算为字节
+func gigabytes(gb float64) int64 {
+	return int64(gb * (1 << 30))
 }
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"github.com/spf13/viper"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -19,8 +22,124 @@ type Config struct {
 		UpdateAfter time.Duration `mapstructure:"update_after"`
 		DeleteAfter time.Duration `mapstructure:"delete_after"`
 	}
+	// 目标目录之后的各级存储，按顺序逐级迁移
+	Tiers []Tier
+	Emby struct {
+		// 合并到一个事务中切换Emby路径的文件数，不大于1时每个文件立即切换
+		BatchSize int `mapstructure:"batch_size"`
+		// 批次未满时第一个文件最长等待时间（秒）
+		BatchWait time.Duration `mapstructure:"batch_wait"`
+		// Emby服务地址和API密钥，都设置时切换路径后通过API刷新被修改的条目
+		URL    string
+		APIKey string `mapstructure:"api_key"`
+		// 刷新条目所在的目录而不是条目本身
+		RefreshParents bool `mapstructure:"refresh_parents"`
+		// 同时进行的刷新请求数，0表示默认值4
+		RefreshConcurrency int `mapstructure:"refresh_concurrency"`
+	}
+	Cleanup struct {
+		// 删除源文件前是否校验目标文件的sha256（迁移时会计算并记录）
+		VerifyChecksum bool `mapstructure:"verify_checksum"`
+		// 目标挂载点上必须存在的标记文件（相对目标目录），为空则只检查目标目录
+		MountMarker string `mapstructure:"mount_marker"`
+		// 每轮和每日（最近24小时）删除的文件数和大小（GB）上限，0表示不限制
+		MaxFilesPerRun  int64   `mapstructure:"max_files_per_run"`
+		MaxSizePerRunGB float64 `mapstructure:"max_size_per_run_gb"`
+		MaxFilesPerDay  int64   `mapstructure:"max_files_per_day"`
+		MaxSizePerDayGB float64 `mapstructure:"max_size_per_day_gb"`
+		// 到期记录中目标文件缺失比例达到该值时触发熔断，0表示只在挂载点异常时熔断
+		BreakerMissingRatio float64 `mapstructure:"breaker_missing_ratio"`
+		// 计算缺失比例所需的最少记录数
+		BreakerMinFiles int `mapstructure:"breaker_min_files"`
+		// 源文件移走或删除后是否清理变空的源目录（不会删除 source_dir 本身）
+		PruneEmptyDirs bool `mapstructure:"prune_empty_dirs"`
+		// 判断目录是否为空时忽略的文件名模式
+		JunkFiles []string `mapstructure:"junk_files"`
+	}
+	Space struct {
+		// 目标目录预留空间（GB），复制后剩余空间不能低于该值
+		ReserveGB float64 `mapstructure:"reserve_gb"`
+		// 目标目录预留空间占总容量的百分比，与 reserve_gb 取较大值
+		ReservePercent float64 `mapstructure:"reserve_percent"`
+		// 等待空间的文件重新检查间隔（分钟）
+		RecheckInterval time.Duration `mapstructure:"recheck_interval"`
+	}
+	Copy struct {
+		// 不小于该大小（GB）的文件分块复制并记录进度，中断后可续传，0表示所有文件
+		ResumeMinSizeGB float64 `mapstructure:"resume_min_size_gb"`
+		// 续传检查点间隔（MB），0表示禁用续传
+		ChunkSizeMB int64 `mapstructure:"chunk_size_mb"`
+		// 复制遇到IO错误时从最近检查点重试的次数
+		Retries int
+	}
+	Permissions Permissions
+	Tiering struct {
+		// 策略评估间隔（小时），0表示不按策略迁移
+		Interval time.Duration
+		// source_dir → target_dir 的策略，后续各级的策略设置在 tiers 的 policies 中
+		Policies []TieringPolicy
+	}
+	Promotion struct {
+		// 热度评估间隔（小时），0表示不提升
+		Interval time.Duration
+		// 统计播放的时间窗口（天）
+		WindowDays int `mapstructure:"window_days"`
+		// 窗口内播放的用户数达到该值时移回源目录
+		MinPlays int `mapstructure:"min_plays"`
+		// 按 target_dir 下前几级目录合并统计（如 剧名/季 为2），0表示按单个文件
+		SeriesDepth int `mapstructure:"series_depth"`
+		// 迁移后多少天内不提升、提升后多少天内不再迁移
+		CooldownDays int `mapstructure:"cooldown_days"`
+		// 每次最多提升的大小（GB），0表示不限
+		MaxSizePerRunGB float64 `mapstructure:"max_size_per_run_gb"`
+	}
+	Trash struct {
+		// 是否启用回收站，启用后清理时源文件移入回收站而不是直接删除
+		Enabled bool
+		// 回收站目录，需与源目录位于同一文件系统
+		Dir string
+		// 回收站文件保留时间（小时），0表示不按时间清理
+		Retention time.Duration
+		// 回收站容量上限（GB），0表示不限制
+		MaxSizeGB float64 `mapstructure:"max_size_gb"`
+	}
+	// 迁移各个时机执行的脚本
+	Hooks []Hook
+	Notify struct {
+		Sinks []NotifySink
+	}
+	Report struct {
+		// 汇总报告间隔（小时），24为每日零点、168为每周一零点（本地时间），0表示不生成
+		Interval time.Duration
+		// 是否将报告写入日志
+		Log bool
+		// 报告文件目录，文件名为 report-<日期>.md 或 .html，为空不写文件
+		Dir string
+		// 文件和邮件的格式：markdown（默认）或 html
+		Format string
+		// 通过邮件发送报告，address 为空不发送
+		Email ReportEmail
+	}
+	Shutdown struct {
+		// 收到退出信号后等待进行中的迁移和清理完成的时间（秒），超时后中止并回滚
+		DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	}
+	// 多个实例共享状态库时的协调
+	Cluster struct {
+		// 启用后通过租约保证同一文件只由一个实例迁移、清理同时只在一个实例运行
+		Enabled bool
+		// 实例标识，为空时使用主机名和进程号
+		InstanceID string `mapstructure:"instance_id"`
+		// 租约有效期（秒），实例退出后超过该时间由其他实例接管
+		LeaseTTL time.Duration `mapstructure:"lease_ttl"`
+	}
 	Database struct {
+		// sqlite（默认）或 postgres
+		Driver string
+		// SQLite 数据库文件
 		Path string
+		// PostgreSQL 连接串，多台主机共享状态时使用
+		DSN string
 	}
 	Logging struct {
 		Level string
@@ -28,6 +147,179 @@ type Config struct {
 	}
 }
 
+// Tier 目标目录之后的一级存储
+type Tier struct {
+	Dir string
+	// 文件在上一级停留多久后迁移到本级（小时）
+	UpdateAfter time.Duration `mapstructure:"update_after"`
+	// 迁移到本级后多久删除上一级的文件（小时），0表示不删除
+	DeleteAfter time.Duration `mapstructure:"delete_after"`
+	// 设置后文件上传到S3兼容的对象存储，dir 为Emby访问这些文件的路径（挂载点），只能用于最后一级
+	S3 *S3Config
+	// 设置后文件通过SFTP上传到远程主机，dir 为Emby访问 remote_dir 的路径，只能用于最后一级
+	SFTP *SFTPConfig
+	// 设置后为文件生成 .strm 并让Emby条目指向它，用于通过HTTP提供的存储，只能用于最后一级
+	Strm *StrmConfig
+	// 本级文件和新建目录的属主及权限，设置后代替全局的 permissions
+	Permissions *Permissions
+	// 上一级的文件命中任一策略时不等 update_after 即迁移到本级
+	Policies []TieringPolicy
+}
+
+// Permissions 目标文件和新建目录的属主及权限
+type Permissions struct {
+	// 目标文件和新建目录的属主，不设置则保留源文件的属主
+	UID *int `mapstructure:"uid"`
+	GID *int `mapstructure:"gid"`
+	// 目标文件和新建目录的权限（八进制，如 "0644"），为空则保留源文件的权限
+	FileMode string `mapstructure:"file_mode"`
+	DirMode  string `mapstructure:"dir_mode"`
+}
+
+// StrmConfig .strm 文件的生成配置
+type StrmConfig struct {
+	// .strm 文件的存放目录（需在Emby媒体库中）
+	Dir string
+	// URL模板，可用 {{.Path}}（URL转义的相对路径）、{{.RawPath}}、{{.Name}}
+	URL string
+}
+
+// SFTPConfig 通过SSH/SFTP访问远程主机的配置
+type SFTPConfig struct {
+	// 远程主机地址 host:port
+	Address        string
+	User           string
+	Password       string
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	// 校验主机密钥的 known_hosts 文件
+	KnownHostsFile string `mapstructure:"known_hosts_file"`
+	// 不校验主机密钥，仅用于可信网络
+	InsecureIgnoreHostKey bool `mapstructure:"insecure_ignore_host_key"`
+	// 远程主机上与 dir 对应的目录
+	RemoteDir string `mapstructure:"remote_dir"`
+	// 传输中断时重连续传的次数
+	Retries int
+}
+
+// S3Config S3兼容对象存储的连接配置
+type S3Config struct {
+	// 服务地址 host:port
+	Endpoint  string
+	UseSSL    bool   `mapstructure:"use_ssl"`
+	Region    string
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	Bucket    string
+	// 对象key前缀
+	Prefix string
+	// 分片上传的分片大小（MB），0使用默认值
+	PartSizeMB int64 `mapstructure:"part_size_mb"`
+}
+
+// Hook 在迁移的某个时机执行的shell命令
+type Hook struct {
+	// before_move、after_move、after_emby_update、before_delete 或 on_failure
+	Event string
+	// 通过 /bin/sh -c 执行，记录通过 EPR_* 环境变量和标准输入的JSON传入
+	Command string
+	// 超时时间（秒），0使用默认值
+	Timeout time.Duration
+}
+
+// hookEvents 支持的钩子时机
+var hookEvents = map[string]bool{
+	"before_move":       true,
+	"after_move":        true,
+	"after_emby_update": true,
+	"before_delete":     true,
+	"on_failure":        true,
+}
+
+// NotifySink 通知渠道
+type NotifySink struct {
+	// webhook、telegram、discord 或 smtp
+	Type string
+	// 只发送这些事件，为空表示全部
+	Events []string
+	// 消息模板（Go text/template），为空使用默认格式
+	Template string
+	// 每分钟最多发送的消息数，0表示不限制
+	RateLimit int `mapstructure:"rate_limit"`
+	// 发送失败后的重试次数
+	Retries int
+	// webhook 和 discord 的地址
+	URL string
+	// webhook 附加的请求头
+	Headers map[string]string
+	// telegram 机器人
+	BotToken string `mapstructure:"bot_token"`
+	ChatID   string `mapstructure:"chat_id"`
+	// smtp 服务地址 host:port 及收发件人
+	Address  string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// ReportEmail 发送汇总报告的邮箱
+type ReportEmail struct {
+	Address  string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// notifyEvents 支持的通知事件
+var notifyEvents = map[string]bool{
+	"migration_done":   true,
+	"migration_failed": true,
+	"delete_blocked":   true,
+	"delete_failed":    true,
+	"breaker_tripped":  true,
+	"disk_low":         true,
+}
+
+// validate 检查通知渠道的类型、必填项和事件名
+func (s NotifySink) validate() error {
+	switch s.Type {
+	case "webhook", "discord":
+		if s.URL == "" {
+			return fmt.Errorf("%s notification sink requires url", s.Type)
+		}
+	case "telegram":
+		if s.BotToken == "" || s.ChatID == "" {
+			return fmt.Errorf("telegram notification sink requires bot_token and chat_id")
+		}
+	case "smtp":
+		if s.Address == "" || s.From == "" || len(s.To) == 0 {
+			return fmt.Errorf("smtp notification sink requires address, from and to")
+		}
+	default:
+		return fmt.Errorf("unknown notification sink type %q", s.Type)
+	}
+	for _, event := range s.Events {
+		if !notifyEvents[event] {
+			return fmt.Errorf("unknown notification event %q", event)
+		}
+	}
+	return nil
+}
+
+// TieringPolicy 分层迁移策略，设置的条件需全部满足，多个策略命中任一即迁移
+type TieringPolicy struct {
+	Name string
+	// 超过该天数未播放（取文件访问时间和Emby最近播放时间中较晚的一个）
+	NotPlayedDays int `mapstructure:"not_played_days"`
+	// 加入媒体库超过该天数
+	AddedDays int `mapstructure:"added_days"`
+	// 文件不小于该大小（GB）
+	MinSizeGB float64 `mapstructure:"min_size_gb"`
+	// 播放次数不超过该值
+	MaxPlayCount *int `mapstructure:"max_play_count"`
+}
+
 func Load(configPath string) (*Config, error) {
 	viper.SetConfigFile(configPath)
 	
@@ -43,6 +335,121 @@ func Load(configPath string) (*Config, error) {
 	// 将小时转换为持续时间
 	config.Timings.UpdateAfter *= time.Hour
 	config.Timings.DeleteAfter *= time.Hour
+	config.Trash.Retention *= time.Hour
+	config.Space.RecheckInterval *= time.Minute
+	config.Emby.BatchWait *= time.Second
+	config.Shutdown.DrainTimeout *= time.Second
+	config.Cluster.LeaseTTL *= time.Second
+	for i := range config.Hooks {
+		config.Hooks[i].Timeout *= time.Second
+	}
+
+	config.Tiering.Interval *= time.Hour
+	config.Promotion.Interval *= time.Hour
+	config.Report.Interval *= time.Hour
+	for i := range config.Tiers {
+		config.Tiers[i].UpdateAfter *= time.Hour
+		config.Tiers[i].DeleteAfter *= time.Hour
+	}
+
+	for i, tier := range config.Tiers {
+		if tier.Dir == "" {
+			return nil, fmt.Errorf("tier %d has no dir", i+1)
+		}
+		if tier.S3 != nil && tier.SFTP != nil {
+			return nil, fmt.Errorf("tier %d: s3 and sftp cannot both be set", i+1)
+		}
+		if (tier.S3 != nil || tier.SFTP != nil) && i != len(config.Tiers)-1 {
+			return nil, fmt.Errorf("tier %d: remote storage can only be used by the last tier", i+1)
+		}
+		if tier.Strm != nil && i != len(config.Tiers)-1 {
+			return nil, fmt.Errorf("tier %d: strm can only be used by the last tier", i+1)
+		}
+		if tier.Strm != nil && (tier.Strm.Dir == "" || tier.Strm.URL == "") {
+			return nil, fmt.Errorf("tier %d: strm dir and url are required", i+1)
+		}
+		if tier.SFTP != nil && (tier.SFTP.Address == "" || tier.SFTP.RemoteDir == "") {
+			return nil, fmt.Errorf("tier %d: sftp address and remote_dir are required", i+1)
+		}
+		if tier.S3 != nil && (tier.S3.Endpoint == "" || tier.S3.Bucket == "") {
+			return nil, fmt.Errorf("tier %d: s3 endpoint and bucket are required", i+1)
+		}
+	}
+	for i, hook := range config.Hooks {
+		if !hookEvents[hook.Event] {
+			return nil, fmt.Errorf("hook %d: unknown event %q", i+1, hook.Event)
+		}
+		if hook.Command == "" {
+			return nil, fmt.Errorf("hook %d has no command", i+1)
+		}
+	}
+	for _, sink := range config.Notify.Sinks {
+		if err := sink.validate(); err != nil {
+			return nil, err
+		}
+	}
+	switch config.Database.Driver {
+	case "", "sqlite":
+	case "postgres":
+		if config.Database.DSN == "" {
+			return nil, fmt.Errorf("postgres database requires dsn")
+		}
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", config.Database.Driver)
+	}
+	switch config.Report.Format {
+	case "", "markdown", "html":
+	default:
+		return nil, fmt.Errorf("unknown report format %q", config.Report.Format)
+	}
+	if email := config.Report.Email; email.Address != "" && (email.From == "" || len(email.To) == 0) {
+		return nil, fmt.Errorf("report email requires from and to")
+	}
+	policies := config.Tiering.Policies
+	for _, tier := range config.Tiers {
+		policies = append(policies, tier.Policies...)
+	}
+	for _, policy := range policies {
+		if policy.NotPlayedDays <= 0 && policy.AddedDays <= 0 && policy.MinSizeGB <= 0 && policy.MaxPlayCount == nil {
+			return nil, fmt.Errorf("tiering policy %q has no conditions", policy.Name)
+		}
+	}
+	perms := []*Permissions{&config.Permissions}
+	for _, tier := range config.Tiers {
+		if tier.Permissions != nil {
+			perms = append(perms, tier.Permissions)
+		}
+	}
+	for _, perm := range perms {
+		for _, mode := range []string{perm.FileMode, perm.DirMode} {
+			if _, err := ParseFileMode(mode); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	return &config, nil
 }
+
+// ParseFileMode 解析八进制权限字符串，空字符串返回0。
+// setuid、setgid 和 sticky 位（04000、02000、01000）转换为 os.FileMode 中对应的标志位
+func ParseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid file mode %q", s)
+	}
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
@@ -23,8 +23,109 @@ paths:
 timings:
   update_after: 24
   delete_after: 168
+shutdown:
+  drain_timeout: 45
+cluster:
+  enabled: true
+  instance_id: nas-1
+  lease_ttl: 30
+hooks:
+  - event: before_move
+    command: /usr/local/bin/pause-torrent.sh
+    timeout: 30
+  - event: on_failure
+    command: curl -fsS https://monitor.lan/fail
+notify:
+  sinks:
+    - type: telegram
+      bot_token: "123:abc"
+      chat_id: "42"
+      events: [migration_failed, breaker_tripped]
+      rate_limit: 20
+      retries: 3
+    - type: smtp
+      address: smtp.lan:25
+      from: epr@lan
+      to: [ops@lan]
+report:
+  interval: 168
+  log: true
+  dir: /test/reports
+  format: html
+  email:
+    address: smtp.lan:25
+    from: epr@lan
+    to: [ops@lan]
+tiers:
+  - dir: /test/archive
+    update_after: 720
+    delete_after: 24
+    permissions:
+      gid: 100
+      file_mode: "0640"
+    policies:
+      - name: large
+        min_size_gb: 40
+  - dir: /test/s3
+    update_after: 2160
+    s3:
+      endpoint: minio.lan:9000
+      access_key: key
+      secret_key: secret
+      bucket: media
+      part_size_mb: 64
+emby:
+  batch_size: 50
+  batch_wait: 30
+  url: http://emby.lan:8096
+  api_key: secret
+  refresh_parents: true
+  refresh_concurrency: 2
+cleanup:
+  verify_checksum: true
+  mount_marker: .mounted
+  max_files_per_run: 100
+  max_size_per_day_gb: 500
+  breaker_missing_ratio: 0.25
+  breaker_min_files: 8
+  prune_empty_dirs: true
+  junk_files:
+    - .DS_Store
+    - Thumbs.db
+space:
+  reserve_gb: 50
+  reserve_percent: 5
+  recheck_interval: 10
+copy:
+  resume_min_size_gb: 10
+  chunk_size_mb: 256
+  retries: 3
+permissions:
+  uid: 1000
+  gid: 0
+  file_mode: "0644"
+tiering:
+  interval: 24
+  policies:
+    - name: cold
+      not_played_days: 90
+      max_play_count: 0
+promotion:
+  interval: 6
+  window_days: 7
+  min_plays: 3
+  series_depth: 2
+  cooldown_days: 14
+  max_size_per_run_gb: 200
+trash:
+  enabled: true
+  dir: /test/.trash
+  retention: 72
+  max_size_gb: 1.5
 database:
+  driver: postgres
   path: ./data/test.db
+  dsn: postgres://epr@db.lan/epr
 logging:
   level: debug
   file: ./logs/test.log
@@ -52,7 +153,82 @@ logging:
 		{"paths.emby_db", cfg.Paths.EmbyDB, "/test/library.db"},
 		{"timings.update_after", cfg.Timings.UpdateAfter, 24 * time.Hour},
 		{"timings.delete_after", cfg.Timings.DeleteAfter, 168 * time.Hour},
+		{"shutdown.drain_timeout", cfg.Shutdown.DrainTimeout, 45 * time.Second},
+		{"cluster.enabled", cfg.Cluster.Enabled, true},
+		{"cluster.instance_id", cfg.Cluster.InstanceID, "nas-1"},
+		{"cluster.lease_ttl", cfg.Cluster.LeaseTTL, 30 * time.Second},
+		{"hooks", len(cfg.Hooks), 2},
+		{"hooks[0].event", cfg.Hooks[0].Event, "before_move"},
+		{"hooks[0].command", cfg.Hooks[0].Command, "/usr/local/bin/pause-torrent.sh"},
+		{"hooks[0].timeout", cfg.Hooks[0].Timeout, 30 * time.Second},
+		{"hooks[1].timeout", cfg.Hooks[1].Timeout, time.Duration(0)},
+		{"notify.sinks", len(cfg.Notify.Sinks), 2},
+		{"notify.sinks[0].chat_id", cfg.Notify.Sinks[0].ChatID, "42"},
+		{"notify.sinks[0].events", len(cfg.Notify.Sinks[0].Events), 2},
+		{"notify.sinks[0].rate_limit", cfg.Notify.Sinks[0].RateLimit, 20},
+		{"notify.sinks[1].to", cfg.Notify.Sinks[1].To[0], "ops@lan"},
+		{"report.interval", cfg.Report.Interval, 168 * time.Hour},
+		{"report.log", cfg.Report.Log, true},
+		{"report.dir", cfg.Report.Dir, "/test/reports"},
+		{"report.format", cfg.Report.Format, "html"},
+		{"report.email.to", cfg.Report.Email.To[0], "ops@lan"},
+		{"emby.batch_size", cfg.Emby.BatchSize, 50},
+		{"emby.batch_wait", cfg.Emby.BatchWait, 30 * time.Second},
+		{"emby.url", cfg.Emby.URL, "http://emby.lan:8096"},
+		{"emby.api_key", cfg.Emby.APIKey, "secret"},
+		{"emby.refresh_parents", cfg.Emby.RefreshParents, true},
+		{"emby.refresh_concurrency", cfg.Emby.RefreshConcurrency, 2},
+		{"cleanup.verify_checksum", cfg.Cleanup.VerifyChecksum, true},
+		{"cleanup.mount_marker", cfg.Cleanup.MountMarker, ".mounted"},
+		{"cleanup.max_files_per_run", cfg.Cleanup.MaxFilesPerRun, int64(100)},
+		{"cleanup.max_size_per_day_gb", cfg.Cleanup.MaxSizePerDayGB, 500.0},
+		{"cleanup.breaker_missing_ratio", cfg.Cleanup.BreakerMissingRatio, 0.25},
+		{"cleanup.breaker_min_files", cfg.Cleanup.BreakerMinFiles, 8},
+		{"cleanup.prune_empty_dirs", cfg.Cleanup.PruneEmptyDirs, true},
+		{"cleanup.junk_files", len(cfg.Cleanup.JunkFiles), 2},
+		{"space.reserve_gb", cfg.Space.ReserveGB, 50.0},
+		{"space.reserve_percent", cfg.Space.ReservePercent, 5.0},
+		{"space.recheck_interval", cfg.Space.RecheckInterval, 10 * time.Minute},
+		{"copy.resume_min_size_gb", cfg.Copy.ResumeMinSizeGB, 10.0},
+		{"copy.chunk_size_mb", cfg.Copy.ChunkSizeMB, int64(256)},
+		{"copy.retries", cfg.Copy.Retries, 3},
+		{"permissions.uid", *cfg.Permissions.UID, 1000},
+		{"permissions.gid", *cfg.Permissions.GID, 0},
+		{"permissions.file_mode", cfg.Permissions.FileMode, "0644"},
+		{"permissions.dir_mode", cfg.Permissions.DirMode, ""},
+		{"tiers", len(cfg.Tiers), 2},
+		{"tiers.dir", cfg.Tiers[0].Dir, "/test/archive"},
+		{"tiers.update_after", cfg.Tiers[0].UpdateAfter, 720 * time.Hour},
+		{"tiers.delete_after", cfg.Tiers[0].DeleteAfter, 24 * time.Hour},
+		{"tiers.s3", cfg.Tiers[0].S3 == nil, true},
+		{"tiers.permissions.gid", *cfg.Tiers[0].Permissions.GID, 100},
+		{"tiers.permissions.file_mode", cfg.Tiers[0].Permissions.FileMode, "0640"},
+		{"tiers.permissions.default", cfg.Tiers[1].Permissions == nil, true},
+		{"tiers.policies", len(cfg.Tiers[0].Policies), 1},
+		{"tiers.policies.min_size_gb", cfg.Tiers[0].Policies[0].MinSizeGB, 40.0},
+		{"tiers.policies.default", len(cfg.Tiers[1].Policies), 0},
+		{"tiers.s3.endpoint", cfg.Tiers[1].S3.Endpoint, "minio.lan:9000"},
+		{"tiers.s3.access_key", cfg.Tiers[1].S3.AccessKey, "key"},
+		{"tiers.s3.bucket", cfg.Tiers[1].S3.Bucket, "media"},
+		{"tiers.s3.part_size_mb", cfg.Tiers[1].S3.PartSizeMB, int64(64)},
+		{"tiering.interval", cfg.Tiering.Interval, 24 * time.Hour},
+		{"tiering.policies", len(cfg.Tiering.Policies), 1},
+		{"tiering.policies.name", cfg.Tiering.Policies[0].Name, "cold"},
+		{"tiering.policies.not_played_days", cfg.Tiering.Policies[0].NotPlayedDays, 90},
+		{"tiering.policies.max_play_count", *cfg.Tiering.Policies[0].MaxPlayCount, 0},
+		{"promotion.interval", cfg.Promotion.Interval, 6 * time.Hour},
+		{"promotion.window_days", cfg.Promotion.WindowDays, 7},
+		{"promotion.min_plays", cfg.Promotion.MinPlays, 3},
+		{"promotion.series_depth", cfg.Promotion.SeriesDepth, 2},
+		{"promotion.cooldown_days", cfg.Promotion.CooldownDays, 14},
+		{"promotion.max_size_per_run_gb", cfg.Promotion.MaxSizePerRunGB, 200.0},
+		{"trash.enabled", cfg.Trash.Enabled, true},
+		{"trash.dir", cfg.Trash.Dir, "/test/.trash"},
+		{"trash.retention", cfg.Trash.Retention, 72 * time.Hour},
+		{"trash.max_size_gb", cfg.Trash.MaxSizeGB, 1.5},
+		{"database.driver", cfg.Database.Driver, "postgres"},
 		{"database.path", cfg.Database.Path, "./data/test.db"},
+		{"database.dsn", cfg.Database.DSN, "postgres://epr@db.lan/epr"},
 		{"logging.level", cfg.Logging.Level, "debug"},
 		{"logging.file", cfg.Logging.File, "./logs/test.log"},
 	}
@@ -66,6 +242,154 @@ logging:
 	}
 
 	// 测试错误情况
+	t.Run("invalid file mode", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "bad-mode.yaml")
+		if err := os.WriteFile(path, []byte("permissions:\n  file_mode: \"0999\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for invalid file mode")
+		}
+	})
+
+	t.Run("invalid tier file mode", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "bad-tier-mode.yaml")
+		if err := os.WriteFile(path, []byte("tiers:\n  - dir: /mnt/archive\n    permissions:\n      dir_mode: \"0999\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for invalid tier file mode")
+		}
+	})
+
+	t.Run("tiering policy without conditions", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "bad-policy.yaml")
+		if err := os.WriteFile(path, []byte("tiering:\n  policies:\n    - name: all\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for policy without conditions")
+		}
+	})
+
+	t.Run("sftp tier", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "sftp.yaml")
+		content := "tiers:\n  - dir: /mnt/archive\n    sftp:\n      address: archive.lan:22\n      user: media\n" +
+			"      known_hosts_file: /etc/known_hosts\n      remote_dir: /srv/media\n      retries: 3\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sftp := cfg.Tiers[0].SFTP
+		if sftp == nil || sftp.Address != "archive.lan:22" || sftp.KnownHostsFile != "/etc/known_hosts" ||
+			sftp.RemoteDir != "/srv/media" || sftp.Retries != 3 {
+			t.Errorf("unexpected sftp config: %+v", sftp)
+		}
+	})
+
+	t.Run("unknown hook event", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "bad-hook.yaml")
+		content := "hooks:\n  - event: before_upload\n    command: /bin/true\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for unknown hook event")
+		}
+	})
+
+	t.Run("invalid notification sink", func(t *testing.T) {
+		for name, sink := range map[string]string{
+			"unknown type":  "type: pager\n",
+			"missing url":   "type: discord\n",
+			"unknown event": "type: discord\n      url: http://x\n      events: [migration_started]\n",
+		} {
+			path := filepath.Join(tmpDir, "bad-notify.yaml")
+			content := "notify:\n  sinks:\n    - " + sink
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+
+	t.Run("invalid report", func(t *testing.T) {
+		for name, report := range map[string]string{
+			"unknown format":     "format: pdf\n",
+			"email without from": "email:\n    address: smtp.lan:25\n    to: [ops@lan]\n",
+		} {
+			path := filepath.Join(tmpDir, "bad-report.yaml")
+			content := "report:\n  " + report
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+
+	t.Run("invalid database", func(t *testing.T) {
+		for name, database := range map[string]string{
+			"unknown driver":       "driver: mysql\n",
+			"postgres without dsn": "driver: postgres\n",
+		} {
+			path := filepath.Join(tmpDir, "bad-database.yaml")
+			content := "database:\n  " + database
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+
+	t.Run("strm tier", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "strm.yaml")
+		content := "tiers:\n  - dir: /mnt/http\n    strm:\n      dir: /media/strm\n" +
+			"      url: \"https://cold.lan/{{.Path}}\"\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		strm := cfg.Tiers[0].Strm
+		if strm == nil || strm.Dir != "/media/strm" || strm.URL != "https://cold.lan/{{.Path}}" {
+			t.Errorf("unexpected strm config: %+v", strm)
+		}
+	})
+
+	t.Run("strm without url", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "strm-no-url.yaml")
+		content := "tiers:\n  - dir: /mnt/http\n    strm:\n      dir: /media/strm\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for strm without url")
+		}
+	})
+
+	t.Run("remote storage before last tier", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "bad-tiers.yaml")
+		content := "tiers:\n  - dir: /mnt/archive\n    sftp:\n      address: archive.lan:22\n      remote_dir: /srv/media\n" +
+			"  - dir: /mnt/cold\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for remote storage before the last tier")
+		}
+	})
+
 	t.Run("non-existent file", func(t *testing.T) {
 		_, err := Load("non-existent.yaml")
 		if err == nil {
@@ -73,3 +397,25 @@ logging:
 		}
 	})
 }
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want os.FileMode
+	}{
+		{"", 0},
+		{"0644", 0644},
+		{"4755", os.ModeSetuid | 0755},
+		{"2775", os.ModeSetgid | 0775},
+		{"1777", os.ModeSticky | 0777},
+	}
+	for _, tt := range tests {
+		got, err := ParseFileMode(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFileMode(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseFileMode("17777"); err == nil {
+		t.Error("expected error for mode out of range")
+	}
+}
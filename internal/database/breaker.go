@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState struct {
+	Reason    string
+	TrippedAt time.Time
+}
+
+// BreakerState 返回熔断器的状态，未触发时返回 nil
+func (x *queries) BreakerState(name string) (*BreakerState, error) {
+	var state BreakerState
+	err := x.queryRow("SELECT reason, tripped_at FROM breaker_state WHERE name = ?", name).
+		Scan(&state.Reason, &state.TrippedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query breaker state: %w", err)
+	}
+	return &state, nil
+}
+
+// TripBreaker 触发熔断器，已触发时保留最初的原因并返回 false
+func (x *queries) TripBreaker(name, reason string, at time.Time) (bool, error) {
+	res, err := x.exec("INSERT INTO breaker_state (name, reason, tripped_at) VALUES (?, ?, ?) ON CONFLICT (name) DO NOTHING",
+		name, reason, at)
+	if err != nil {
+		return false, fmt.Errorf("trip breaker: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("trip breaker: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (x *queries) ResetBreaker(name string) error {
+	if _, err := x.exec("DELETE FROM breaker_state WHERE name = ?", name); err != nil {
+		return fmt.Errorf("reset breaker: %w", err)
+	}
+	return nil
+}
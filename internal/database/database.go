@@ -3,45 +3,96 @@ package database
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//go:embed schema.sql
-var schemaFS embed.FS
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFS embed.FS
 
 type Database struct {
 	db     *sql.DB
+	driver string
+	dsn    string
 	logger *zap.Logger
 }
 
-func New(dbPath string, logger *zap.Logger) (*Database, error) {
-	// 确保目录存在
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
-		return nil, fmt.Errorf("create database directory: %w", err)
-	}
+// Migration 一个结构变更，文件名为 <版本>_<名称>.sql，按版本号顺序执行。
+// 每个驱动有一套变更，同一版本号的变更内容相同
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus 变更的执行状态，AppliedAt 为零值表示尚未执行
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+	Unknown   bool // 数据库中已执行但本程序不认识的变更，由更新的版本写入
+}
+
+// ErrNewerSchema 数据库结构比本程序支持的更新
+var ErrNewerSchema = errors.New("database schema is newer than this version supports")
 
-	// 打开数据库连接
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+// New 打开数据库并执行尚未执行的结构变更。driver 为 sqlite 时 dsn 为文件路径
+func New(driver, dsn string, logger *zap.Logger) (*Database, error) {
+	d, err := Open(driver, dsn, logger)
 	if err != nil {
-		return nil, fmt.Errorf("open database: %w", err)
+		return nil, err
+	}
+	if err := d.Migrate(); err != nil {
+		d.Close()
+		return nil, err
 	}
+	return d, nil
+}
 
-	// 读取并执行schema
-	schema, err := schemaFS.ReadFile("schema.sql")
-	if err != nil {
-		return nil, fmt.Errorf("read schema: %w", err)
+// Open 打开数据库，不执行结构变更
+func Open(driver, dsn string, logger *zap.Logger) (*Database, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("database path is empty")
 	}
 
-	if _, err := db.Exec(string(schema)); err != nil {
-		return nil, fmt.Errorf("execute schema: %w", err)
+	var db *sql.DB
+	var err error
+	switch driver {
+	case DriverSQLite, "":
+		driver = DriverSQLite
+		// 确保目录存在
+		if err := os.MkdirAll(filepath.Dir(dsn), 0755); err != nil {
+			return nil, fmt.Errorf("create database directory: %w", err)
+		}
+		// 事务开始时即获取写锁，避免两个事务都从读升级为写时其中一个直接失败
+		db, err = sql.Open("sqlite3", dsn+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	case DriverPostgres:
+		db, err = sql.Open("postgres", dsn)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open database: %w", err)
 	}
 
 	return &Database{
 		db:     db,
+		driver: driver,
+		dsn:    dsn,
 		logger: logger,
 	}, nil
 }
@@ -49,3 +100,210 @@ func New(dbPath string, logger *zap.Logger) (*Database, error) {
 func (d *Database) Close() error {
 	return d.db.Close()
 }
+
+// Store 返回使用同一连接的状态库接口，关闭 Store 即关闭数据库
+func (d *Database) Store() Store {
+	if d.driver == DriverPostgres {
+		return newStore(d.db, postgresDialect{})
+	}
+	return newStore(d.db, sqliteDialect{})
+}
+
+// Migrations 返回程序内置的某个驱动的结构变更
+func Migrations(driver string) ([]Migration, error) {
+	if driver == "" {
+		driver = DriverSQLite
+	}
+	files, err := fs.Glob(migrationFS, "migrations/"+driver+"/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+	var migrations []Migration
+	for _, file := range files {
+		base := strings.TrimSuffix(filepath.Base(file), ".sql")
+		version, name, ok := strings.Cut(base, "_")
+		v, err := strconv.Atoi(version)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+		data, err := migrationFS.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read migration: %w", err)
+		}
+		migrations = append(migrations, Migration{Version: v, Name: name, SQL: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// applied 返回已执行的变更版本及执行时间，没有 schema_migrations 表时返回空
+func (d *Database) applied() (map[int]time.Time, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')"
+	if d.driver == DriverPostgres {
+		query = "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	}
+	var exists bool
+	if err := d.db.QueryRow(query).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("check schema_migrations: %w", err)
+	}
+	applied := make(map[int]time.Time)
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := d.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Status 返回每个变更的执行状态，按版本排序
+func (d *Database) Status() ([]MigrationStatus, error) {
+	migrations, err := Migrations(d.driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := d.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	known := make(map[int]bool)
+	for _, m := range migrations {
+		known[m.Version] = true
+		status = append(status, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+	}
+	for version, appliedAt := range applied {
+		if !known[version] {
+			status = append(status, MigrationStatus{Version: version, AppliedAt: appliedAt, Unknown: true})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Migrate 按版本顺序执行尚未执行的变更，每个变更在一个事务中完成。
+// 已有数据的数据库在变更前先备份；数据库中有本程序不认识的更新版本时拒绝执行
+func (d *Database) Migrate() error {
+	migrations, err := Migrations(d.driver)
+	if err != nil {
+		return err
+	}
+	applied, err := d.applied()
+	if err != nil {
+		return err
+	}
+
+	latest := migrations[len(migrations)-1].Version
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	if current > latest {
+		return fmt.Errorf("%w: schema version %d, supported %d", ErrNewerSchema, current, latest)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := d.backup(current); err != nil {
+		return err
+	}
+	appliedType := "DATETIME"
+	if d.driver == DriverPostgres {
+		appliedType = "TIMESTAMPTZ"
+	}
+	if _, err := d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at ` + appliedType + ` NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for _, m := range pending {
+		if err := d.apply(m); err != nil {
+			return fmt.Errorf("apply migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		d.logger.Info("applied database migration", zap.Int("version", m.Version), zap.String("name", m.Name))
+	}
+	return nil
+}
+
+// apply 在事务中执行一个变更并记录版本。
+// 旧版本直接用 schema.sql 建表，表中可能已有变更要添加的列，这类错误忽略
+func (d *Database) apply(m Migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range strings.Split(m.SQL, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			if strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
+			return err
+		}
+	}
+	insert := "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+	if d.driver == DriverPostgres {
+		insert = rebind(insert)
+	}
+	if _, err := tx.Exec(insert,
+		m.Version, m.Name, time.Now()); err != nil {
+		return fmt.Errorf("record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// backup 在变更前将已有数据的SQLite数据库复制到 <路径>.v<版本>-<时间>.bak。
+// PostgreSQL 由数据库自身的备份负责
+func (d *Database) backup(version int) error {
+	if d.driver == DriverPostgres {
+		d.logger.Info("applying migrations to postgres without backup", zap.Int("version", version))
+		return nil
+	}
+	var tables int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return fmt.Errorf("check existing tables: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+	path := fmt.Sprintf("%s.v%d-%s.bak", d.dsn, version, time.Now().Format("20060102150405"))
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("back up database: %w", err)
+	}
+	d.logger.Info("backed up database before migration", zap.String("path", path))
+	return nil
+}
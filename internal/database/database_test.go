@@ -1,9 +1,11 @@
 package database
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -15,7 +17,7 @@ func TestNew(t *testing.T) {
 	logger := zap.NewNop()
 
 	// 测试创建数据库
-	db, err := New(dbPath, logger)
+	db, err := New(DriverSQLite, dbPath, logger)
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -61,7 +63,7 @@ func TestNew(t *testing.T) {
 	expectedIndexes := []string{
 		"idx_file_records_status",
 		"idx_file_records_source_path",
-		"idx_file_records_source_path_unique",
+		"idx_file_records_active_source",
 	}
 
 	for _, idx := range expectedIndexes {
@@ -72,9 +74,111 @@ func TestNew(t *testing.T) {
 
 	// 测试错误情况
 	t.Run("invalid path", func(t *testing.T) {
-		_, err := New("", logger)
+		_, err := New(DriverSQLite, "", logger)
 		if err == nil {
 			t.Error("expected error for invalid path")
 		}
 	})
 }
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "app.db")
+
+	// 旧版本用 schema.sql 建的表，已有部分后来添加的列，没有 schema_migrations
+	legacy, err := Open(DriverSQLite, dbPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.db.Exec(`
+		CREATE TABLE file_records (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_path TEXT NOT NULL,
+			target_path TEXT NOT NULL,
+			modified_time DATETIME NOT NULL,
+			processed_time DATETIME,
+			delete_scheduled DATETIME,
+			status TEXT NOT NULL,
+			file_size INTEGER,
+			checksum TEXT,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		INSERT INTO file_records (source_path, target_path, modified_time, status, file_size, created_at, updated_at)
+		VALUES ('/src/a.mkv', '/dst/a.mkv', '2024-01-01', 'processed', 42, '2024-01-01', '2024-01-01');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := New(DriverSQLite, dbPath, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	var size, hop int
+	if err := db.db.QueryRow("SELECT file_size, hop FROM file_records WHERE source_path = '/src/a.mkv'").
+		Scan(&size, &hop); err != nil {
+		t.Fatalf("query migrated record: %v", err)
+	}
+	if size != 42 || hop != 0 {
+		t.Errorf("file_size = %d, hop = %d", size, hop)
+	}
+
+	status, err := db.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.AppliedAt.IsZero() {
+			t.Errorf("migration %d was not applied", s.Version)
+		}
+	}
+
+	backups, _ := filepath.Glob(dbPath + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Errorf("backups = %v, want one", backups)
+	}
+
+	// 已是最新版本时不再备份
+	db.Close()
+	db, err = New(DriverSQLite, dbPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if backups, _ := filepath.Glob(dbPath + ".*.bak"); len(backups) != 1 {
+		t.Errorf("backups = %v, want one", backups)
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	db, err := New(DriverSQLite, dbPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', ?)",
+		time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := New(DriverSQLite, dbPath, zap.NewNop()); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("New() error = %v, want ErrNewerSchema", err)
+	}
+
+	db, err = Open(DriverSQLite, dbPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	status, err := db.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := status[len(status)-1]; last.Version != 9999 || !last.Unknown {
+		t.Errorf("last migration status = %+v", last)
+	}
+}
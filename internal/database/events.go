@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"strings"
+	"time"
+)
+
+// EventFilter 查询审计事件的条件，零值字段不限制
+type EventFilter struct {
+	RecordID int64
+	// 源路径或目标路径，同时包含以该路径为源或目标的记录的事件
+	Path  string
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// InsertEvent 追加一条审计事件，RecordID 为0时只记录路径
+func (x *queries) InsertEvent(e *model.RecordEvent) error {
+	err := x.queryRow(`
+		INSERT INTO record_events (record_id, path, event, actor, details, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		nullID(e.RecordID), e.Path, e.Event, e.Actor, nullString(e.Details), nullString(e.Error), e.CreatedAt).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("insert record event: %w", err)
+	}
+	return nil
+}
+
+// LinkEvents 将记录保存前以路径记录的事件关联到 recordID
+func (x *queries) LinkEvents(recordID int64, path string) error {
+	if _, err := x.exec("UPDATE record_events SET record_id = ? WHERE record_id IS NULL AND path = ?",
+		recordID, path); err != nil {
+		return fmt.Errorf("link record events: %w", err)
+	}
+	return nil
+}
+
+// Events 按时间顺序返回符合条件的审计事件
+func (x *queries) Events(filter EventFilter) ([]model.RecordEvent, error) {
+	var where []string
+	var args []interface{}
+	if filter.RecordID != 0 {
+		where = append(where, "record_id = ?")
+		args = append(args, filter.RecordID)
+	}
+	if filter.Path != "" {
+		where = append(where, `(path = ? OR record_id IN (
+			SELECT id FROM file_records WHERE source_path = ? OR target_path = ?))`)
+		args = append(args, filter.Path, filter.Path, filter.Path)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.To)
+	}
+
+	query := "SELECT id, record_id, path, event, actor, details, error, created_at FROM record_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := x.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query record events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []model.RecordEvent
+	for rows.Next() {
+		var e model.RecordEvent
+		var recordID sql.NullInt64
+		var details, errText sql.NullString
+		if err := rows.Scan(&e.ID, &recordID, &e.Path, &e.Event, &e.Actor, &details, &errText, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan record event: %w", err)
+		}
+		e.RecordID = recordID.Int64
+		e.Details = details.String
+		e.Error = errText.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
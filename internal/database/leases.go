@@ -0,0 +1,42 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// AcquireLease 为 owner 获取或续期租约，有效期 ttl。租约由其他 owner 持有且尚未过期时返回 false
+func (x *queries) AcquireLease(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := x.exec(`
+		INSERT INTO leases (name, owner, expires_at, heartbeat_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			owner = excluded.owner, expires_at = excluded.expires_at, heartbeat_at = excluded.heartbeat_at
+		WHERE leases.owner = excluded.owner OR leases.expires_at < ?`,
+		name, owner, now.Add(ttl), now, now)
+	if err != nil {
+		return false, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// ReleaseLease 释放 owner 持有的租约
+func (x *queries) ReleaseLease(name, owner string) error {
+	if _, err := x.exec("DELETE FROM leases WHERE name = ? AND owner = ?", name, owner); err != nil {
+		return fmt.Errorf("release lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// LeaseHeld 返回租约是否由某个实例持有且尚未过期
+func (x *queries) LeaseHeld(name string) (bool, error) {
+	var n int
+	if err := x.queryRow("SELECT COUNT(*) FROM leases WHERE name = ? AND expires_at >= ?", name, time.Now()).Scan(&n); err != nil {
+		return false, fmt.Errorf("query lease %s: %w", name, err)
+	}
+	return n > 0, nil
+}
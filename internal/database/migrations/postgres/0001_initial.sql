@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS file_records (
+    id BIGSERIAL PRIMARY KEY,
+    source_path TEXT NOT NULL,
+    target_path TEXT NOT NULL,
+    modified_time TIMESTAMPTZ NOT NULL,
+    processed_time TIMESTAMPTZ,
+    delete_scheduled TIMESTAMPTZ,
+    status TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_records_status ON file_records(status);
+CREATE INDEX IF NOT EXISTS idx_file_records_source_path ON file_records(source_path);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_file_records_source_path_unique ON file_records(source_path) WHERE status != 'deleted';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS trash_entries (
+    id BIGSERIAL PRIMARY KEY,
+    record_id BIGINT NOT NULL,
+    source_path TEXT NOT NULL,
+    trash_path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    status TEXT NOT NULL,
+    trashed_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trash_entries_status ON trash_entries(status);
+CREATE INDEX IF NOT EXISTS idx_trash_entries_record_id ON trash_entries(record_id);
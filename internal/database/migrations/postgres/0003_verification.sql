@@ -0,0 +1,4 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS file_size BIGINT;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS checksum TEXT;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS emby_items BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS block_reason TEXT;
@@ -0,0 +1,10 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS deleted_bytes BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_file_records_deleted_at ON file_records(deleted_at);
+
+CREATE TABLE IF NOT EXISTS breaker_state (
+    name TEXT PRIMARY KEY,
+    reason TEXT NOT NULL,
+    tripped_at TIMESTAMPTZ NOT NULL
+);
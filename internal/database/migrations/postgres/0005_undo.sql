@@ -0,0 +1,21 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS undo_id BIGINT;
+
+CREATE TABLE IF NOT EXISTS emby_changes (
+    id BIGSERIAL PRIMARY KEY,
+    record_id BIGINT NOT NULL,
+    item_id BIGINT NOT NULL,
+    old_path TEXT NOT NULL,
+    new_path TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL,
+    reverted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_emby_changes_record_id ON emby_changes(record_id);
+
+CREATE TABLE IF NOT EXISTS undo_actions (
+    id BIGSERIAL PRIMARY KEY,
+    selector TEXT NOT NULL,
+    reverted BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS copy_progress (
+    temp_path TEXT PRIMARY KEY,
+    source_path TEXT NOT NULL,
+    source_size BIGINT NOT NULL,
+    source_mtime BIGINT NOT NULL,
+    copied BIGINT NOT NULL,
+    hash_state BYTEA NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS hop INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES file_records(id);
+
+CREATE INDEX IF NOT EXISTS idx_file_records_target_path ON file_records(target_path);
+CREATE INDEX IF NOT EXISTS idx_file_records_parent_id ON file_records(parent_id);
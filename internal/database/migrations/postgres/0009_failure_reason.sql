@@ -0,0 +1 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS failure_reason TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS refresh_status TEXT;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS refresh_error TEXT;
+ALTER TABLE file_records ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMPTZ;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS trash_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    record_id INTEGER NOT NULL,
+    source_path TEXT NOT NULL,
+    trash_path TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    trashed_at DATETIME NOT NULL,
+    expires_at DATETIME,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trash_entries_status ON trash_entries(status);
+CREATE INDEX IF NOT EXISTS idx_trash_entries_record_id ON trash_entries(record_id);
@@ -0,0 +1,4 @@
+ALTER TABLE file_records ADD COLUMN file_size INTEGER;
+ALTER TABLE file_records ADD COLUMN checksum TEXT;
+ALTER TABLE file_records ADD COLUMN emby_items INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE file_records ADD COLUMN block_reason TEXT;
@@ -0,0 +1,10 @@
+ALTER TABLE file_records ADD COLUMN deleted_at DATETIME;
+ALTER TABLE file_records ADD COLUMN deleted_bytes INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_file_records_deleted_at ON file_records(deleted_at);
+
+CREATE TABLE IF NOT EXISTS breaker_state (
+    name TEXT PRIMARY KEY,
+    reason TEXT NOT NULL,
+    tripped_at DATETIME NOT NULL
+);
@@ -0,0 +1,21 @@
+ALTER TABLE file_records ADD COLUMN undo_id INTEGER;
+
+CREATE TABLE IF NOT EXISTS emby_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    record_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    old_path TEXT NOT NULL,
+    new_path TEXT NOT NULL,
+    changed_at DATETIME NOT NULL,
+    reverted_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_emby_changes_record_id ON emby_changes(record_id);
+
+CREATE TABLE IF NOT EXISTS undo_actions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    selector TEXT NOT NULL,
+    reverted INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS copy_progress (
+    temp_path TEXT PRIMARY KEY,
+    source_path TEXT NOT NULL,
+    source_size INTEGER NOT NULL,
+    source_mtime INTEGER NOT NULL,
+    copied INTEGER NOT NULL,
+    hash_state BLOB NOT NULL,
+    updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE file_records ADD COLUMN promoted_at DATETIME;
+
+DROP INDEX IF EXISTS idx_file_records_source_path_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_file_records_active_source ON file_records(source_path) WHERE status NOT IN ('deleted', 'promoted');
@@ -0,0 +1 @@
+ALTER TABLE file_records ADD COLUMN failure_reason TEXT;
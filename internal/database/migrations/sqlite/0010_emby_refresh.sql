@@ -0,0 +1,3 @@
+ALTER TABLE file_records ADD COLUMN refresh_status TEXT;
+ALTER TABLE file_records ADD COLUMN refresh_error TEXT;
+ALTER TABLE file_records ADD COLUMN refreshed_at DATETIME;
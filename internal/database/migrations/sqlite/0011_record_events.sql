@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS record_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    record_id INTEGER,
+    path TEXT NOT NULL,
+    event TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    details TEXT,
+    error TEXT,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_record_events_record_id ON record_events(record_id);
+CREATE INDEX IF NOT EXISTS idx_record_events_path ON record_events(path);
+CREATE INDEX IF NOT EXISTS idx_record_events_created_at ON record_events(created_at);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS leases (
+    name TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    heartbeat_at DATETIME NOT NULL
+);
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+)
+
+// CopyProgress 返回临时文件的复制进度，没有时返回 nil
+func (x *queries) CopyProgress(tempPath string) (*model.CopyProgress, error) {
+	c := model.CopyProgress{TempPath: tempPath}
+	err := x.queryRow(`
+		SELECT source_path, source_size, source_mtime, copied, hash_state, updated_at
+		FROM copy_progress WHERE temp_path = ?`, tempPath).
+		Scan(&c.SourcePath, &c.SourceSize, &c.SourceMtime, &c.Copied, &c.HashState, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query copy progress: %w", err)
+	}
+	return &c, nil
+}
+
+// CopyProgresses 返回所有中断的复制，最早更新的在前，不含哈希状态
+func (x *queries) CopyProgresses() ([]model.CopyProgress, error) {
+	rows, err := x.query(`
+		SELECT temp_path, source_path, source_size, source_mtime, copied, updated_at
+		FROM copy_progress ORDER BY updated_at`)
+	if err != nil {
+		return nil, fmt.Errorf("query copy progress: %w", err)
+	}
+	defer rows.Close()
+
+	var progress []model.CopyProgress
+	for rows.Next() {
+		var c model.CopyProgress
+		if err := rows.Scan(&c.TempPath, &c.SourcePath, &c.SourceSize, &c.SourceMtime, &c.Copied, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan copy progress: %w", err)
+		}
+		progress = append(progress, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate copy progress: %w", err)
+	}
+	return progress, nil
+}
+
+// SaveCopyProgress 保存或更新临时文件的复制进度
+func (x *queries) SaveCopyProgress(c *model.CopyProgress) error {
+	_, err := x.exec(`
+		INSERT INTO copy_progress (
+			temp_path, source_path, source_size, source_mtime, copied, hash_state, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (temp_path) DO UPDATE SET
+			source_path = excluded.source_path,
+			source_size = excluded.source_size,
+			source_mtime = excluded.source_mtime,
+			copied = excluded.copied,
+			hash_state = excluded.hash_state,
+			updated_at = excluded.updated_at`,
+		c.TempPath, c.SourcePath, c.SourceSize, c.SourceMtime, c.Copied, c.HashState, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("save copy progress: %w", err)
+	}
+	return nil
+}
+
+func (x *queries) DeleteCopyProgress(tempPath string) error {
+	if _, err := x.exec("DELETE FROM copy_progress WHERE temp_path = ?", tempPath); err != nil {
+		return fmt.Errorf("delete copy progress: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"time"
+)
+
+// recordColumns file_records 的全部列，顺序与 scanRecord 一致
+const recordColumns = `id, source_path, target_path, modified_time, processed_time, delete_scheduled,
+	status, file_size, checksum, emby_items, block_reason, failure_reason, deleted_at, deleted_bytes,
+	undo_id, promoted_at, hop, parent_id, refresh_status, refresh_error, refreshed_at, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecord 读取按 recordColumns 查询的一行，NULL 为零值
+func scanRecord(s scanner) (*model.FileRecord, error) {
+	var r model.FileRecord
+	var processed, deleteScheduled, deletedAt, promotedAt, refreshedAt sql.NullTime
+	var size, deletedBytes, undoID, parentID sql.NullInt64
+	var checksum, blockReason, failureReason, refreshStatus, refreshError sql.NullString
+	if err := s.Scan(&r.ID, &r.SourcePath, &r.TargetPath, &r.ModifiedTime, &processed, &deleteScheduled,
+		&r.Status, &size, &checksum, &r.EmbyItems, &blockReason, &failureReason, &deletedAt, &deletedBytes,
+		&undoID, &promotedAt, &r.Hop, &parentID, &refreshStatus, &refreshError, &refreshedAt,
+		&r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.ProcessedTime = processed.Time
+	r.DeleteScheduled = deleteScheduled.Time
+	r.FileSize = size.Int64
+	r.Checksum = checksum.String
+	r.BlockReason = blockReason.String
+	r.FailureReason = failureReason.String
+	r.DeletedAt = deletedAt.Time
+	r.DeletedBytes = deletedBytes.Int64
+	r.UndoID = undoID.Int64
+	r.PromotedAt = promotedAt.Time
+	r.ParentID = parentID.Int64
+	r.RefreshStatus = refreshStatus.String
+	r.RefreshError = refreshError.String
+	r.RefreshedAt = refreshedAt.Time
+	return &r, nil
+}
+
+// loadRecord 按条件查询一条记录，没有时返回 nil
+func (x *queries) loadRecord(where string, args ...interface{}) (*model.FileRecord, error) {
+	r, err := scanRecord(x.queryRow("SELECT "+recordColumns+" FROM file_records r "+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query record: %w", err)
+	}
+	return r, nil
+}
+
+// listRecords 按条件查询记录，条件中可用 r 指代 file_records
+func (x *queries) listRecords(where string, args ...interface{}) ([]model.FileRecord, error) {
+	rows, err := x.query("SELECT "+recordColumns+" FROM file_records r "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []model.FileRecord
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan record: %w", err)
+		}
+		records = append(records, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate records: %w", err)
+	}
+	return records, nil
+}
+
+// Record 按ID查询记录
+func (x *queries) Record(id int64) (*model.FileRecord, error) {
+	return x.loadRecord("WHERE id = ?", id)
+}
+
+// LatestRecord 返回以 path 为源路径或目标路径的最近一条记录
+func (x *queries) LatestRecord(path string) (*model.FileRecord, error) {
+	return x.loadRecord("WHERE source_path = ? OR target_path = ? ORDER BY id DESC LIMIT 1", path, path)
+}
+
+// LatestChild 返回 parentID 的最近一条下一跳记录
+func (x *queries) LatestChild(parentID int64) (*model.FileRecord, error) {
+	return x.loadRecord("WHERE parent_id = ? ORDER BY id DESC LIMIT 1", parentID)
+}
+
+// OldestPending 返回最早的未完成迁移
+func (x *queries) OldestPending() (*model.FileRecord, error) {
+	return x.loadRecord(`
+		WHERE status IN ('pending', 'waiting_for_space', 'copied', 'failed')
+		ORDER BY created_at, id LIMIT 1`)
+}
+
+// ActiveRecordExists 判断源路径是否已有进行中或已完成的迁移。
+// 迁移失败的可以重试，源文件已删除的说明源路径上是新的文件，不算作存在；
+// 提升回热存储的记录在 promotedAfter 之后提升的仍算作存在；
+// 撤销（reverted）和从回收站恢复（restored）的记录算作存在，文件按用户的意图留在源路径，不再自动迁移
+func (x *queries) ActiveRecordExists(sourcePath string, promotedAfter time.Time) (bool, error) {
+	var exists bool
+	err := x.queryRow(`
+		SELECT EXISTS(SELECT 1 FROM file_records WHERE source_path = ?
+			AND (status NOT IN ('deleted', 'promoted', 'failed') OR (status = 'promoted' AND promoted_at > ?)))`,
+		sourcePath, promotedAfter).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check file existence: %w", err)
+	}
+	return exists, nil
+}
+
+// FailedRecordID 返回源路径上迁移失败的记录ID，没有时返回0
+func (x *queries) FailedRecordID(sourcePath string) (int64, error) {
+	var id int64
+	err := x.queryRow("SELECT id FROM file_records WHERE source_path = ? AND status = 'failed'", sourcePath).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query failed record: %w", err)
+	}
+	return id, nil
+}
+
+// ParentRecordID 返回把文件迁移到 targetPath 的上一跳记录ID，没有时返回0
+func (x *queries) ParentRecordID(targetPath string) (int64, error) {
+	var id int64
+	err := x.queryRow(`
+		SELECT id FROM file_records
+		WHERE target_path = ? AND status IN ('processed', 'delete_blocked', 'deleted')
+		ORDER BY id DESC LIMIT 1`, targetPath).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query parent record: %w", err)
+	}
+	return id, nil
+}
+
+// ActiveChildID 返回从 id 的目标文件继续迁移、仍然有效的下一跳记录ID，没有时返回0
+func (x *queries) ActiveChildID(id int64) (int64, error) {
+	var child int64
+	err := x.queryRow(`
+		SELECT id FROM file_records
+		WHERE parent_id = ? AND status NOT IN ('reverted', 'promoted')
+		ORDER BY id DESC LIMIT 1`, id).Scan(&child)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query next hop: %w", err)
+	}
+	return child, nil
+}
+
+// InsertRecord 插入新记录并回填ID
+func (x *queries) InsertRecord(r *model.FileRecord) error {
+	err := x.queryRow(`
+		INSERT INTO file_records (
+			source_path, target_path, modified_time, processed_time,
+			delete_scheduled, status, file_size, checksum, emby_items, failure_reason,
+			hop, parent_id, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		r.SourcePath, r.TargetPath, r.ModifiedTime,
+		nullTime(r.ProcessedTime), nullTime(r.DeleteScheduled), r.Status,
+		r.FileSize, r.Checksum, r.EmbyItems, nullString(r.FailureReason),
+		r.Hop, nullID(r.ParentID), r.CreatedAt, r.UpdatedAt).Scan(&r.ID)
+	if err != nil {
+		return fmt.Errorf("insert record: %w", err)
+	}
+	return nil
+}
+
+// UpdateRecord 保存迁移过程中变化的字段
+func (x *queries) UpdateRecord(r *model.FileRecord) error {
+	_, err := x.exec(`
+		UPDATE file_records SET
+			target_path = ?, processed_time = ?, delete_scheduled = ?, status = ?,
+			file_size = ?, checksum = ?, emby_items = ?, failure_reason = ?, updated_at = ?
+		WHERE id = ?`,
+		r.TargetPath, nullTime(r.ProcessedTime), nullTime(r.DeleteScheduled),
+		r.Status, r.FileSize, r.Checksum, r.EmbyItems, nullString(r.FailureReason), r.UpdatedAt,
+		r.ID)
+	if err != nil {
+		return fmt.Errorf("update record: %w", err)
+	}
+	return nil
+}
+
+func (x *queries) SetRecordStatus(id int64, status string, at time.Time) error {
+	if _, err := x.exec("UPDATE file_records SET status = ?, updated_at = ? WHERE id = ?", status, at, id); err != nil {
+		return fmt.Errorf("update record status: %w", err)
+	}
+	return nil
+}
+
+// CopiedRecords 返回已移动文件、等待切换Emby路径的记录
+func (x *queries) CopiedRecords() ([]model.FileRecord, error) {
+	return x.listRecords("WHERE status = 'copied' ORDER BY id")
+}
+
+// WaitingRecords 返回等待空间的记录，按进入等待的先后顺序
+func (x *queries) WaitingRecords() ([]model.FileRecord, error) {
+	return x.listRecords("WHERE status = 'waiting_for_space' ORDER BY created_at, id")
+}
+
+// BlockedRecords 返回删除被阻止的记录
+func (x *queries) BlockedRecords() ([]model.FileRecord, error) {
+	return x.listRecords("WHERE status = 'delete_blocked' ORDER BY updated_at, id")
+}
+
+// DueRecords 返回到 now 为止到期、待删除源文件的记录
+func (x *queries) DueRecords(now time.Time) ([]model.FileRecord, error) {
+	return x.listRecords(`
+		WHERE status = 'processed'
+		AND delete_scheduled IS NOT NULL
+		AND delete_scheduled <= ?
+		ORDER BY delete_scheduled, id`, now)
+}
+
+// TierCandidates 返回第 hop 跳在 processedBefore 之前完成、可以迁移到下一级的记录。
+// 只选上一级文件已删除（或不删除）且没有有效下一跳的记录
+func (x *queries) TierCandidates(hop int, processedBefore time.Time) ([]model.FileRecord, error) {
+	return x.listRecords(`
+		WHERE hop = ?
+		AND (status = 'deleted' OR (status = 'processed' AND delete_scheduled IS NULL))
+		AND processed_time <= ?
+		AND NOT EXISTS (
+			SELECT 1 FROM file_records c
+			WHERE c.parent_id = r.id AND c.status != 'promoted')
+		ORDER BY processed_time, id`, hop, processedBefore)
+}
+
+// PromotionCandidates 返回已切换Emby路径且在 processedBefore 之前迁移的记录
+func (x *queries) PromotionCandidates(processedBefore time.Time) ([]model.FileRecord, error) {
+	return x.listRecords(`
+		WHERE status IN ('processed', 'delete_blocked', 'deleted') AND processed_time <= ?
+		ORDER BY id`, processedBefore)
+}
+
+// UndoSelector 选择要撤销的迁移：按记录ID、路径或迁移时间范围，三者选其一
+type UndoSelector struct {
+	ID   int64
+	Path string
+	From time.Time
+	To   time.Time
+}
+
+// UndoCandidates 返回 sel 选中的可撤销记录，按时间范围选择时从最近的迁移开始
+func (x *queries) UndoCandidates(sel UndoSelector) ([]model.FileRecord, error) {
+	where := "WHERE status IN ('waiting_for_space', 'processed', 'delete_blocked', 'deleted', 'restored')"
+	var args []interface{}
+	switch {
+	case sel.ID != 0:
+		where += " AND id = ?"
+		args = append(args, sel.ID)
+	case sel.Path != "":
+		where += " AND (source_path = ? OR target_path = ?) ORDER BY id DESC LIMIT 1"
+		args = append(args, sel.Path, sel.Path)
+	case !sel.From.IsZero():
+		where += " AND processed_time >= ?"
+		args = append(args, sel.From)
+		if !sel.To.IsZero() {
+			where += " AND processed_time < ?"
+			args = append(args, sel.To)
+		}
+		where += " ORDER BY id DESC"
+	default:
+		return nil, fmt.Errorf("empty undo selector")
+	}
+	return x.listRecords(where, args...)
+}
+
+// MarkDeleted 将记录标记为源文件已删除，deletedAt 为零值表示源文件早已不存在
+func (x *queries) MarkDeleted(id int64, deletedAt time.Time, deletedBytes int64, at time.Time) error {
+	_, err := x.exec("UPDATE file_records SET status = 'deleted', deleted_at = ?, deleted_bytes = ?, updated_at = ? WHERE id = ?",
+		nullTime(deletedAt), deletedBytes, at, id)
+	if err != nil {
+		return fmt.Errorf("update record status: %w", err)
+	}
+	return nil
+}
+
+func (x *queries) BlockRecord(id int64, reason string, at time.Time) error {
+	_, err := x.exec("UPDATE file_records SET status = 'delete_blocked', block_reason = ?, updated_at = ? WHERE id = ?",
+		reason, at, id)
+	if err != nil {
+		return fmt.Errorf("update record status: %w", err)
+	}
+	return nil
+}
+
+// RetryBlocked 将删除被阻止的记录放回待删除队列，id 为0时处理全部，返回受影响的记录（只含ID和源路径）
+func (x *queries) RetryBlocked(id int64, at time.Time) ([]model.FileRecord, error) {
+	query := "UPDATE file_records SET status = 'processed', block_reason = NULL, updated_at = ? WHERE status = 'delete_blocked'"
+	args := []interface{}{at}
+	if id != 0 {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+
+	rows, err := x.query(query+" RETURNING id, source_path", args...)
+	if err != nil {
+		return nil, fmt.Errorf("retry blocked records: %w", err)
+	}
+	defer rows.Close()
+
+	var retried []model.FileRecord
+	for rows.Next() {
+		r := model.FileRecord{Status: "processed"}
+		if err := rows.Scan(&r.ID, &r.SourcePath); err != nil {
+			return nil, fmt.Errorf("scan retried record: %w", err)
+		}
+		retried = append(retried, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("retry blocked records: %w", err)
+	}
+	return retried, nil
+}
+
+func (x *queries) MarkPromoted(id int64, at time.Time) error {
+	if _, err := x.exec(`
+		UPDATE file_records SET status = 'promoted', promoted_at = ?, updated_at = ?
+		WHERE id = ?`, at, at, id); err != nil {
+		return fmt.Errorf("update record status: %w", err)
+	}
+	return nil
+}
+
+func (x *queries) MarkReverted(id, undoID int64, at time.Time) error {
+	if _, err := x.exec("UPDATE file_records SET status = 'reverted', undo_id = ?, updated_at = ? WHERE id = ?",
+		undoID, at, id); err != nil {
+		return fmt.Errorf("update record status: %w", err)
+	}
+	return nil
+}
+
+// SaveRefreshResult 保存通过API刷新Emby条目的结果
+func (x *queries) SaveRefreshResult(id int64, status, reason string, at time.Time) error {
+	if _, err := x.exec(`
+		UPDATE file_records SET refresh_status = ?, refresh_error = ?, refreshed_at = ?
+		WHERE id = ?`, status, nullString(reason), at, id); err != nil {
+		return fmt.Errorf("save emby refresh result: %w", err)
+	}
+	return nil
+}
+
+// DeletionsSince 返回 since 之后删除的源文件数和字节数
+func (x *queries) DeletionsSince(since time.Time) (files, bytes int64, err error) {
+	err = x.queryRow(`
+		SELECT COUNT(*), COALESCE(SUM(deleted_bytes), 0) FROM file_records
+		WHERE deleted_at IS NOT NULL AND deleted_at > ?`, since).Scan(&files, &bytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query deletions since %s: %w", since.Format(time.DateTime), err)
+	}
+	return files, bytes, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// HopTotal 一跳迁移的文件数和字节数
+type HopTotal struct {
+	Hop   int
+	Files int
+	Bytes int64
+}
+
+// FailureCount 一种失败原因的事件数
+type FailureCount struct {
+	Event  string // failed 或 delete_blocked
+	Reason string
+	Count  int
+}
+
+// HopTotals 返回 [from, to) 期间每一跳迁移的文件数和字节数，不含已撤销的迁移
+func (x *queries) HopTotals(from, to time.Time) ([]HopTotal, error) {
+	rows, err := x.query(`
+		SELECT hop, COUNT(*), COALESCE(SUM(file_size), 0) FROM file_records
+		WHERE processed_time >= ? AND processed_time < ? AND status != 'reverted'
+		GROUP BY hop ORDER BY hop`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var hops []HopTotal
+	for rows.Next() {
+		var h HopTotal
+		if err := rows.Scan(&h.Hop, &h.Files, &h.Bytes); err != nil {
+			return nil, fmt.Errorf("scan migrations: %w", err)
+		}
+		hops = append(hops, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate migrations: %w", err)
+	}
+	return hops, nil
+}
+
+// Deletions 返回 [from, to) 期间删除（或移入回收站）的源文件数和字节数
+func (x *queries) Deletions(from, to time.Time) (files int, bytes int64, err error) {
+	if err := x.queryRow(`
+		SELECT COUNT(*), COALESCE(SUM(deleted_bytes), 0) FROM file_records
+		WHERE deleted_at >= ? AND deleted_at < ?`, from, to).Scan(&files, &bytes); err != nil {
+		return 0, 0, fmt.Errorf("query deletions: %w", err)
+	}
+	return files, bytes, nil
+}
+
+// PendingDeletions 返回等待删除源文件的记录数和字节数，overdue 为到 dueBy 已到期的数量
+func (x *queries) PendingDeletions(dueBy time.Time) (files int, bytes int64, overdue int, err error) {
+	if err := x.queryRow(`
+		SELECT COUNT(*), COALESCE(SUM(file_size), 0),
+			COALESCE(SUM(CASE WHEN delete_scheduled <= ? THEN 1 ELSE 0 END), 0) FROM file_records
+		WHERE status = 'processed' AND delete_scheduled IS NOT NULL`, dueBy).
+		Scan(&files, &bytes, &overdue); err != nil {
+		return 0, 0, 0, fmt.Errorf("query pending deletions: %w", err)
+	}
+	return files, bytes, overdue, nil
+}
+
+// Failures 返回 [from, to) 期间的迁移失败、删除失败和删除阻止事件，按事件和原因汇总，数量多的在前
+func (x *queries) Failures(from, to time.Time) ([]FailureCount, error) {
+	rows, err := x.query(`
+		SELECT event, COALESCE(details, ''), COALESCE(error, ''), COUNT(*) FROM record_events
+		WHERE event IN ('failed', 'delete_blocked') AND created_at >= ? AND created_at < ?
+		GROUP BY 1, 2, 3 ORDER BY 4 DESC, 1, 2, 3`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query failures: %w", err)
+	}
+	defer rows.Close()
+
+	var failures []FailureCount
+	for rows.Next() {
+		var f FailureCount
+		var details, errText string
+		if err := rows.Scan(&f.Event, &details, &errText, &f.Count); err != nil {
+			return nil, fmt.Errorf("scan failures: %w", err)
+		}
+		// 失败事件的 details 为失败的步骤，原因在 error 中；删除阻止的原因在 details 中
+		f.Reason = details
+		if errText != "" {
+			f.Reason = details + ": " + errText
+		}
+		failures = append(failures, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate failures: %w", err)
+	}
+	return failures, nil
+}
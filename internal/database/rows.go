@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ColumnKind 可导出列的类型
+type ColumnKind int
+
+const (
+	TextColumn ColumnKind = iota
+	IntColumn
+	CountColumn // 不允许 NULL 的整数列，导入的数据缺少该字段时为0
+	TimeColumn
+)
+
+// Column 可导出的列
+type Column struct {
+	Name string
+	Kind ColumnKind
+}
+
+// Table 可导出和导入的表，第一列为 id
+type Table struct {
+	Name    string
+	Columns []Column
+}
+
+// RecordsTable 迁移记录，status 为第7列、source_path 为第2列
+var RecordsTable = Table{Name: "file_records", Columns: []Column{
+	{"id", IntColumn},
+	{"source_path", TextColumn},
+	{"target_path", TextColumn},
+	{"modified_time", TimeColumn},
+	{"processed_time", TimeColumn},
+	{"delete_scheduled", TimeColumn},
+	{"status", TextColumn},
+	{"file_size", IntColumn},
+	{"checksum", TextColumn},
+	{"emby_items", CountColumn},
+	{"block_reason", TextColumn},
+	{"failure_reason", TextColumn},
+	{"deleted_at", TimeColumn},
+	{"deleted_bytes", IntColumn},
+	{"undo_id", IntColumn},
+	{"promoted_at", TimeColumn},
+	{"hop", CountColumn},
+	{"parent_id", IntColumn},
+	{"refresh_status", TextColumn},
+	{"refresh_error", TextColumn},
+	{"refreshed_at", TimeColumn},
+	{"created_at", TimeColumn},
+	{"updated_at", TimeColumn},
+}}
+
+// EventsTable 审计事件
+var EventsTable = Table{Name: "record_events", Columns: []Column{
+	{"id", IntColumn},
+	{"record_id", IntColumn},
+	{"path", TextColumn},
+	{"event", TextColumn},
+	{"actor", TextColumn},
+	{"details", TextColumn},
+	{"error", TextColumn},
+	{"created_at", TimeColumn},
+}}
+
+// Names 返回各列的列名
+func (t Table) Names() []string {
+	names := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// ExportFilter 导出的条件，零值字段不限制。
+// 事件按所属记录的状态、目录映射和路径筛选，时间范围按各自的创建时间
+type ExportFilter struct {
+	Statuses []string
+	Hop      *int
+	Prefix   string
+	From     time.Time
+	To       time.Time
+}
+
+// recordConditions 返回针对记录本身的条件，不含时间范围
+func (f ExportFilter) recordConditions() ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if len(f.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, s := range f.Statuses {
+			args = append(args, s)
+		}
+	}
+	if f.Hop != nil {
+		where = append(where, "hop = ?")
+		args = append(args, *f.Hop)
+	}
+	if f.Prefix != "" {
+		n := utf8.RuneCountInString(f.Prefix)
+		where = append(where, "(substr(source_path, 1, ?) = ? OR substr(target_path, 1, ?) = ?)")
+		args = append(args, n, f.Prefix, n, f.Prefix)
+	}
+	return where, args
+}
+
+// conditions 返回导出表 t 的全部条件
+func (f ExportFilter) conditions(t Table) ([]string, []interface{}) {
+	where, args := f.recordConditions()
+	if t.Name != RecordsTable.Name && len(where) > 0 {
+		where = []string{"record_id IN (SELECT id FROM file_records WHERE " + strings.Join(where, " AND ") + ")"}
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To)
+	}
+	return where, args
+}
+
+// ExportRows 按ID顺序读取表 t 中符合条件的行，逐行交给 fn，返回读取的行数。
+// 各列按类型读取为 int64、time.Time 或 string，NULL 为 nil
+func (x *queries) ExportRows(t Table, filter ExportFilter, fn func(values []interface{}) error) (int, error) {
+	where, args := filter.conditions(t)
+	query := "SELECT " + strings.Join(t.Names(), ", ") + " FROM " + t.Name
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	rows, err := x.query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("query %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		values, err := scanValues(rows, t.Columns)
+		if err != nil {
+			return n, fmt.Errorf("scan %s: %w", t.Name, err)
+		}
+		if err := fn(values); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("iterate %s: %w", t.Name, err)
+	}
+	return n, nil
+}
+
+// scanValues 按列类型读取一行，NULL 为 nil
+func scanValues(rows *sql.Rows, columns []Column) ([]interface{}, error) {
+	dest := make([]interface{}, len(columns))
+	for i, c := range columns {
+		switch c.Kind {
+		case IntColumn, CountColumn:
+			dest[i] = &sql.NullInt64{}
+		case TimeColumn:
+			dest[i] = &sql.NullTime{}
+		default:
+			dest[i] = &sql.NullString{}
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	for i, d := range dest {
+		switch v := d.(type) {
+		case *sql.NullInt64:
+			if v.Valid {
+				values[i] = v.Int64
+			}
+		case *sql.NullTime:
+			if v.Valid {
+				values[i] = v.Time
+			}
+		case *sql.NullString:
+			if v.Valid {
+				values[i] = v.String
+			}
+		}
+	}
+	return values, nil
+}
+
+// RowExists 判断表 t 中是否已有该ID的行
+func (x *queries) RowExists(t Table, id int64) (bool, error) {
+	var exists bool
+	if err := x.queryRow("SELECT EXISTS(SELECT 1 FROM "+t.Name+" WHERE id = ?)", id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("check %s row %d: %w", t.Name, id, err)
+	}
+	return exists, nil
+}
+
+// SourceInUse 判断源路径是否已有ID不为 id 的进行中记录，与 idx_file_records_active_source 对应
+func (x *queries) SourceInUse(id int64, sourcePath string) (bool, error) {
+	var taken bool
+	if err := x.queryRow(`
+		SELECT EXISTS(SELECT 1 FROM file_records WHERE source_path = ? AND id != ?
+			AND status NOT IN ('deleted', 'promoted'))`, sourcePath, id).Scan(&taken); err != nil {
+		return false, fmt.Errorf("check record %d source path: %w", id, err)
+	}
+	return taken, nil
+}
+
+// InsertRow 按列的顺序插入一行，保留原ID
+func (x *queries) InsertRow(t Table, row []interface{}) error {
+	names := t.Names()
+	_, err := x.exec("INSERT INTO "+t.Name+" ("+strings.Join(names, ", ")+") VALUES (?"+
+		strings.Repeat(", ?", len(names)-1)+")", row...)
+	return err
+}
+
+// ReplaceRow 用 row 覆盖ID相同的行
+func (x *queries) ReplaceRow(t Table, row []interface{}) error {
+	names := t.Names()[1:]
+	for i := range names {
+		names[i] += " = ?"
+	}
+	args := append(append([]interface{}{}, row[1:]...), row[0])
+	_, err := x.exec("UPDATE "+t.Name+" SET "+strings.Join(names, ", ")+" WHERE id = ?", args...)
+	return err
+}
+
+// SyncSequence 导入带ID的行后调整表的自增序列
+func (x *queries) SyncSequence(t Table) error {
+	if err := x.d.syncSequence(x.q, t.Name); err != nil {
+		return fmt.Errorf("sync %s id sequence: %w", t.Name, err)
+	}
+	return nil
+}
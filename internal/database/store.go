@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的数据库驱动
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Store 应用状态库。各表的读写都通过 Queries 中的方法完成，
+// SQLite 和 PostgreSQL 的差异（占位符、自增序列）由各自的 dialect 处理
+type Store interface {
+	Queries
+	// InTx 在一个事务中执行 fn，fn 返回错误时回滚。fn 中只能使用传入的 q，
+	// 使用 Store 本身会等待事务释放写锁
+	InTx(fn func(q Queries) error) error
+	Close() error
+}
+
+// Queries 状态库的读写操作，在事务内外都可以使用。查询单条记录没有结果时返回 nil
+type Queries interface {
+	// file_records
+	Record(id int64) (*model.FileRecord, error)
+	LatestRecord(path string) (*model.FileRecord, error)
+	LatestChild(parentID int64) (*model.FileRecord, error)
+	OldestPending() (*model.FileRecord, error)
+	ActiveRecordExists(sourcePath string, promotedAfter time.Time) (bool, error)
+	FailedRecordID(sourcePath string) (int64, error)
+	ParentRecordID(targetPath string) (int64, error)
+	ActiveChildID(id int64) (int64, error)
+	InsertRecord(r *model.FileRecord) error
+	UpdateRecord(r *model.FileRecord) error
+	SetRecordStatus(id int64, status string, at time.Time) error
+	CopiedRecords() ([]model.FileRecord, error)
+	WaitingRecords() ([]model.FileRecord, error)
+	BlockedRecords() ([]model.FileRecord, error)
+	DueRecords(now time.Time) ([]model.FileRecord, error)
+	TierCandidates(hop int, processedBefore time.Time) ([]model.FileRecord, error)
+	PromotionCandidates(processedBefore time.Time) ([]model.FileRecord, error)
+	UndoCandidates(sel UndoSelector) ([]model.FileRecord, error)
+	MarkDeleted(id int64, deletedAt time.Time, deletedBytes int64, at time.Time) error
+	BlockRecord(id int64, reason string, at time.Time) error
+	RetryBlocked(id int64, at time.Time) ([]model.FileRecord, error)
+	MarkPromoted(id int64, at time.Time) error
+	MarkReverted(id, undoID int64, at time.Time) error
+	SaveRefreshResult(id int64, status, reason string, at time.Time) error
+	DeletionsSince(since time.Time) (files, bytes int64, err error)
+
+	// record_events
+	InsertEvent(e *model.RecordEvent) error
+	LinkEvents(recordID int64, path string) error
+	Events(filter EventFilter) ([]model.RecordEvent, error)
+
+	// leases
+	AcquireLease(name, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, owner string) error
+	LeaseHeld(name string) (bool, error)
+
+	// trash_entries
+	InsertTrashEntry(e *model.TrashEntry) error
+	DeleteTrashEntry(id int64) error
+	TrashedEntries() ([]model.TrashEntry, error)
+	ExpiredTrash(now time.Time) ([]model.TrashEntry, error)
+	LatestTrashEntry(recordID int64) (*model.TrashEntry, error)
+	TrashSize() (int64, error)
+	SetTrashStatus(id int64, status string, at time.Time) error
+
+	// undo_actions、emby_changes
+	InsertUndoAction(selector string, at time.Time) (int64, error)
+	FinishUndoAction(id int64, reverted, failed int) error
+	InsertEmbyChanges(recordID int64, itemIDs []int64, oldPath, newPath string, at time.Time) error
+	EmbyChanges(recordID int64) ([]model.EmbyChange, error)
+	RevertEmbyChanges(recordID int64, at time.Time) error
+
+	// copy_progress
+	CopyProgress(tempPath string) (*model.CopyProgress, error)
+	CopyProgresses() ([]model.CopyProgress, error)
+	SaveCopyProgress(c *model.CopyProgress) error
+	DeleteCopyProgress(tempPath string) error
+
+	// breaker_state
+	BreakerState(name string) (*BreakerState, error)
+	TripBreaker(name, reason string, at time.Time) (bool, error)
+	ResetBreaker(name string) error
+
+	// 报告
+	HopTotals(from, to time.Time) ([]HopTotal, error)
+	Deletions(from, to time.Time) (files int, bytes int64, err error)
+	PendingDeletions(dueBy time.Time) (files int, bytes int64, overdue int, err error)
+	Failures(from, to time.Time) ([]FailureCount, error)
+
+	// 导出和导入
+	ExportRows(t Table, filter ExportFilter, fn func(values []interface{}) error) (int, error)
+	RowExists(t Table, id int64) (bool, error)
+	SourceInUse(id int64, sourcePath string) (bool, error)
+	InsertRow(t Table, row []interface{}) error
+	ReplaceRow(t Table, row []interface{}) error
+	SyncSequence(t Table) error
+}
+
+// querier *sql.DB 和 *sql.Tx 共有的方法
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// dialect 各驱动的 SQL 差异
+type dialect interface {
+	// rebind 将 ? 占位符改为驱动使用的形式
+	rebind(query string) string
+	// syncSequence 导入带ID的行后调整表的自增序列，避免之后插入的行与导入的ID冲突
+	syncSequence(q querier, table string) error
+}
+
+// sqliteDialect SQLite 直接使用 ? 占位符，AUTOINCREMENT 会跟随写入的最大ID
+type sqliteDialect struct{}
+
+func (sqliteDialect) rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) syncSequence(querier, string) error {
+	return nil
+}
+
+// postgresDialect PostgreSQL 使用 $n 占位符，SERIAL 列的序列需要手动同步
+type postgresDialect struct{}
+
+func (postgresDialect) rebind(query string) string {
+	return rebind(query)
+}
+
+func (postgresDialect) syncSequence(q querier, table string) error {
+	_, err := q.Exec("SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM "+table, table)
+	return err
+}
+
+// queries Queries 的实现，q 为连接池或事务
+type queries struct {
+	q querier
+	d dialect
+}
+
+func (x *queries) exec(query string, args ...interface{}) (sql.Result, error) {
+	return x.q.Exec(x.d.rebind(query), args...)
+}
+
+func (x *queries) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return x.q.Query(x.d.rebind(query), args...)
+}
+
+func (x *queries) queryRow(query string, args ...interface{}) *sql.Row {
+	return x.q.QueryRow(x.d.rebind(query), args...)
+}
+
+// sqlStore 基于 database/sql 的 Store，SQLite 和 PostgreSQL 通过 dialect 区分
+type sqlStore struct {
+	queries
+	db *sql.DB
+}
+
+func newStore(db *sql.DB, d dialect) *sqlStore {
+	return &sqlStore{queries: queries{q: db, d: d}, db: db}
+}
+
+func (s *sqlStore) InTx(fn func(q Queries) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&queries{q: tx, d: s.d}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind 将 ? 占位符按顺序改为 $1、$2……，忽略字符串和标识符中的问号
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// nullTime 将零值时间转换为NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
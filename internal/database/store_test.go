@@ -0,0 +1,187 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"UPDATE t SET a = ? WHERE id = ?", "UPDATE t SET a = $1 WHERE id = $2"},
+		{"SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{`SELECT "a?" FROM t WHERE b = 'it''s?' AND c = ?`, `SELECT "a?" FROM t WHERE b = 'it''s?' AND c = $1`},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db, err := New(DriverSQLite, filepath.Join(t.TempDir(), "app.db"), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testStore(t, db.Store())
+}
+
+// testStore 检查各驱动的 Store 实现行为一致
+func testStore(t *testing.T, store Store) {
+	now := time.Now().Truncate(time.Microsecond)
+	record := &model.FileRecord{
+		SourcePath:   "/src/a.mkv",
+		TargetPath:   "/dst/a.mkv",
+		ModifiedTime: now,
+		Status:       "pending",
+		FileSize:     42,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := store.InsertEvent(&model.RecordEvent{Path: record.SourcePath, Event: "detected", Actor: "test", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LinkEvents(record.ID, record.SourcePath); err != nil {
+		t.Fatal(err)
+	}
+	record.Status = "processed"
+	record.ProcessedTime = now
+	if err := store.UpdateRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.LatestRecord(record.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != record.ID || got.Status != "processed" || got.FileSize != 42 || !got.ProcessedTime.Equal(now) {
+		t.Errorf("LatestRecord() = %+v", got)
+	}
+	events, err := store.Events(EventFilter{RecordID: record.ID})
+	if err != nil || len(events) != 1 {
+		t.Errorf("Events() = %v, %v", events, err)
+	}
+	if exists, err := store.ActiveRecordExists(record.SourcePath, now); err != nil || !exists {
+		t.Errorf("ActiveRecordExists() = %v, %v", exists, err)
+	}
+
+	// 事务中的错误回滚全部修改
+	errAbort := errors.New("abort")
+	err = store.InTx(func(q Queries) error {
+		if err := q.SetRecordStatus(record.ID, "failed", now); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("InTx() error = %v", err)
+	}
+	if got, _ := store.Record(record.ID); got == nil || got.Status != "processed" {
+		t.Errorf("record after rollback = %+v", got)
+	}
+	if err := store.InTx(func(q Queries) error { return q.SetRecordStatus(record.ID, "deleted", now) }); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Record(record.ID); got == nil || got.Status != "deleted" {
+		t.Errorf("record after commit = %+v", got)
+	}
+
+	// 租约由其他实例持有时无法获取
+	if ok, err := store.AcquireLease("cleanup", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("AcquireLease(a) = %v, %v", ok, err)
+	}
+	if ok, err := store.AcquireLease("cleanup", "b", time.Minute); err != nil || ok {
+		t.Errorf("AcquireLease(b) = %v, %v", ok, err)
+	}
+	if err := store.ReleaseLease("cleanup", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.AcquireLease("cleanup", "b", time.Minute); err != nil || !ok {
+		t.Errorf("AcquireLease(b) after release = %v, %v", ok, err)
+	}
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	sqlite, err := Migrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := Migrations(DriverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres has %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d: sqlite %04d_%s, postgres %04d_%s", i,
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+// TestPostgresStore 需要 EPR_TEST_POSTGRES_DSN 指向可用的 PostgreSQL，
+// 测试在临时 schema 中进行，结束后删除
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("EPR_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("EPR_TEST_POSTGRES_DSN not set")
+	}
+
+	admin, err := Open(DriverPostgres, dsn, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("epr_test_%d", time.Now().UnixNano())
+	if _, err := admin.db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.db.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := New(DriverPostgres, dsn+sep+"search_path="+schema, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	status, err := db.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.AppliedAt.IsZero() {
+			t.Errorf("migration %d was not applied", s.Version)
+		}
+	}
+
+	testStore(t, db.Store())
+
+	// 再次执行不重复变更
+	if err := db.Migrate(); err != nil {
+		t.Errorf("Migrate() error = %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"time"
+)
+
+// InsertTrashEntry 记录移入回收站的文件并回填ID
+func (x *queries) InsertTrashEntry(e *model.TrashEntry) error {
+	err := x.queryRow(`
+		INSERT INTO trash_entries (
+			record_id, source_path, trash_path, size, status,
+			trashed_at, expires_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		e.RecordID, e.SourcePath, e.TrashPath, e.Size, e.Status,
+		e.TrashedAt, nullTime(e.ExpiresAt), e.UpdatedAt).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("insert trash entry: %w", err)
+	}
+	return nil
+}
+
+// DeleteTrashEntry 删除未能移入回收站的文件的记录
+func (x *queries) DeleteTrashEntry(id int64) error {
+	if _, err := x.exec("DELETE FROM trash_entries WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete trash entry: %w", err)
+	}
+	return nil
+}
+
+// TrashedEntries 返回回收站中尚未清理的文件，最早移入的在前
+func (x *queries) TrashedEntries() ([]model.TrashEntry, error) {
+	return x.listTrash("WHERE status = 'trashed' ORDER BY trashed_at, id")
+}
+
+// ExpiredTrash 返回到 now 为止超过保留期的回收站文件
+func (x *queries) ExpiredTrash(now time.Time) ([]model.TrashEntry, error) {
+	return x.listTrash(`
+		WHERE status = 'trashed'
+		AND expires_at IS NOT NULL
+		AND expires_at <= ?
+		ORDER BY trashed_at, id`, now)
+}
+
+// LatestTrashEntry 返回记录最近一次移入回收站、尚未清理的文件，没有时返回 nil
+func (x *queries) LatestTrashEntry(recordID int64) (*model.TrashEntry, error) {
+	entries, err := x.listTrash("WHERE status = 'trashed' AND record_id = ? ORDER BY id DESC LIMIT 1", recordID)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// TrashSize 返回回收站中文件的总字节数
+func (x *queries) TrashSize() (int64, error) {
+	var total int64
+	if err := x.queryRow("SELECT COALESCE(SUM(size), 0) FROM trash_entries WHERE status = 'trashed'").Scan(&total); err != nil {
+		return 0, fmt.Errorf("query trash size: %w", err)
+	}
+	return total, nil
+}
+
+func (x *queries) SetTrashStatus(id int64, status string, at time.Time) error {
+	if _, err := x.exec("UPDATE trash_entries SET status = ?, updated_at = ? WHERE id = ?", status, at, id); err != nil {
+		return fmt.Errorf("update trash entry: %w", err)
+	}
+	return nil
+}
+
+func (x *queries) listTrash(where string, args ...interface{}) ([]model.TrashEntry, error) {
+	rows, err := x.query(`
+		SELECT id, record_id, source_path, trash_path, size, status,
+			trashed_at, expires_at, updated_at
+		FROM trash_entries `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query trash entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []model.TrashEntry
+	for rows.Next() {
+		var entry model.TrashEntry
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&entry.ID, &entry.RecordID, &entry.SourcePath, &entry.TrashPath,
+			&entry.Size, &entry.Status, &entry.TrashedAt, &expiresAt, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan trash entry: %w", err)
+		}
+		entry.ExpiresAt = expiresAt.Time
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate trash entries: %w", err)
+	}
+	return entries, nil
+}
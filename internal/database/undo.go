@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"time"
+)
+
+// InsertUndoAction 记录一次撤销操作，返回操作ID
+func (x *queries) InsertUndoAction(selector string, at time.Time) (int64, error) {
+	var id int64
+	if err := x.queryRow("INSERT INTO undo_actions (selector, created_at) VALUES (?, ?) RETURNING id",
+		selector, at).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert undo action: %w", err)
+	}
+	return id, nil
+}
+
+// FinishUndoAction 保存撤销操作的结果
+func (x *queries) FinishUndoAction(id int64, reverted, failed int) error {
+	if _, err := x.exec("UPDATE undo_actions SET reverted = ?, failed = ? WHERE id = ?",
+		reverted, failed, id); err != nil {
+		return fmt.Errorf("update undo action: %w", err)
+	}
+	return nil
+}
+
+// InsertEmbyChanges 保存记录修改过的Emby条目及原路径
+func (x *queries) InsertEmbyChanges(recordID int64, itemIDs []int64, oldPath, newPath string, at time.Time) error {
+	for _, id := range itemIDs {
+		if _, err := x.exec(`
+			INSERT INTO emby_changes (record_id, item_id, old_path, new_path, changed_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			recordID, id, oldPath, newPath, at); err != nil {
+			return fmt.Errorf("insert emby change: %w", err)
+		}
+	}
+	return nil
+}
+
+// EmbyChanges 返回记录修改过、尚未恢复的Emby条目
+func (x *queries) EmbyChanges(recordID int64) ([]model.EmbyChange, error) {
+	rows, err := x.query(`
+		SELECT id, record_id, item_id, old_path, new_path, changed_at, reverted_at FROM emby_changes
+		WHERE record_id = ? AND reverted_at IS NULL ORDER BY id`, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("query emby changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []model.EmbyChange
+	for rows.Next() {
+		var c model.EmbyChange
+		var revertedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.RecordID, &c.ItemID, &c.OldPath, &c.NewPath, &c.ChangedAt, &revertedAt); err != nil {
+			return nil, fmt.Errorf("scan emby change: %w", err)
+		}
+		c.RevertedAt = revertedAt.Time
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate emby changes: %w", err)
+	}
+	return changes, nil
+}
+
+// RevertEmbyChanges 将记录修改过的Emby条目标记为已恢复
+func (x *queries) RevertEmbyChanges(recordID int64, at time.Time) error {
+	if _, err := x.exec("UPDATE emby_changes SET reverted_at = ? WHERE record_id = ? AND reverted_at IS NULL",
+		at, recordID); err != nil {
+		return fmt.Errorf("update emby changes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package emby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client Emby服务端API客户端，只实现刷新条目所需的接口
+type Client struct {
+	URL    string // 如 http://emby.lan:8096
+	APIKey string
+	HTTP   *http.Client
+}
+
+// NewClient 创建客户端，请求超时30秒
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		URL:    strings.TrimRight(baseURL, "/"),
+		APIKey: apiKey,
+		HTTP:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// RefreshItem 让Emby重新扫描条目，目录条目连同其下的文件一起刷新
+func (c *Client) RefreshItem(ctx context.Context, id int64) error {
+	query := url.Values{
+		"Recursive":           {"true"},
+		"MetadataRefreshMode": {"Default"},
+		"ImageRefreshMode":    {"Default"},
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/Items/"+strconv.FormatInt(id, 10)+"/Refresh", query)
+	if err != nil {
+		return fmt.Errorf("refresh item %d: %w", id, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ParentIDs 返回条目所在目录的条目ID，没有上级的条目不在结果中
+func (c *Client) ParentIDs(ctx context.Context, ids []int64) (map[int64]int64, error) {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	query := url.Values{
+		"Ids":    {strings.Join(parts, ",")},
+		"Fields": {"ParentId"},
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/Items", query)
+	if err != nil {
+		return nil, fmt.Errorf("query items: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Items []struct {
+			ID       string `json:"Id"`
+			ParentID string `json:"ParentId"`
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode items: %w", err)
+	}
+	parents := make(map[int64]int64)
+	for _, item := range body.Items {
+		id, err := strconv.ParseInt(item.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+		if parent, err := strconv.ParseInt(item.ParentID, 10, 64); err == nil {
+			parents[id] = parent
+		}
+	}
+	return parents, nil
+}
+
+// do 发送带API密钥的请求，非2xx响应视为失败
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("X-Emby-Token", c.APIKey)
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
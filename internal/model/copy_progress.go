@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// CopyProgress 分块复制的检查点，重启后从 Copied 字节处继续
+type CopyProgress struct {
+	TempPath    string    `db:"temp_path"`
+	SourcePath  string    `db:"source_path"`
+	SourceSize  int64     `db:"source_size"`
+	SourceMtime int64     `db:"source_mtime"` // 源文件修改时间的纳秒时间戳
+	Copied      int64     `db:"copied"`
+	HashState   []byte    `db:"hash_state"` // sha256 的中间状态
+	UpdatedAt   time.Time `db:"updated_at"`
+}
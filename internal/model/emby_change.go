@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// EmbyChange 记录迁移时修改过的一个Emby条目及其原路径，撤销迁移时按它恢复
+type EmbyChange struct {
+	ID         int64     `db:"id"`
+	RecordID   int64     `db:"record_id"`
+	ItemID     int64     `db:"item_id"`
+	OldPath    string    `db:"old_path"`
+	NewPath    string    `db:"new_path"`
+	ChangedAt  time.Time `db:"changed_at"`
+	RevertedAt time.Time `db:"reverted_at"`
+}
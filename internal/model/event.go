@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// RecordEvent 记录状态变化的审计事件，只追加，除补充 record_id 外不修改
+type RecordEvent struct {
+	ID        int64     `db:"id"`
+	RecordID  int64     `db:"record_id"` // 记录保存前发生的事件在保存后关联
+	Path      string    `db:"path"`      // 事件发生时记录的源路径
+	Event     string    `db:"event"`
+	Actor     string    `db:"actor"` // daemon 或 cli
+	Details   string    `db:"details"`
+	Error     string    `db:"error"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -10,7 +10,21 @@ type FileRecord struct {
 	ModifiedTime    time.Time `db:"modified_time"`
 	ProcessedTime   time.Time `db:"processed_time"`
 	DeleteScheduled time.Time `db:"delete_scheduled"`
-	Status          string    `db:"status"` // pending, processed, deleted
+	Status          string    `db:"status"` // pending, waiting_for_space, copied, processed, delete_blocked, deleted, restored, reverted, promoted, failed
+	FileSize        int64     `db:"file_size"`
+	Checksum        string    `db:"checksum"`     // 目标文件的sha256，未启用校验时为空
+	EmbyItems       int64     `db:"emby_items"`   // 迁移时更新的Emby条目数
+	RefreshStatus   string    `db:"refresh_status"` // 通过API刷新Emby条目的结果：refreshed 或 refresh_failed
+	RefreshError    string    `db:"refresh_error"`
+	RefreshedAt     time.Time `db:"refreshed_at"`
+	BlockReason     string    `db:"block_reason"` // 删除被阻止的原因
+	FailureReason   string    `db:"failure_reason"` // 迁移失败的原因
+	DeletedAt       time.Time `db:"deleted_at"`
+	DeletedBytes    int64     `db:"deleted_bytes"`
+	UndoID          int64     `db:"undo_id"` // 撤销该迁移的操作ID
+	PromotedAt      time.Time `db:"promoted_at"` // 提升回热存储的时间
+	Hop             int       `db:"hop"`       // 迁移链上的第几跳，0表示从源目录迁移到目标目录
+	ParentID        int64     `db:"parent_id"` // 上一跳的记录ID，第一跳为0
 	CreatedAt       time.Time `db:"created_at"`
 	UpdatedAt       time.Time `db:"updated_at"`
 }
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// TrashEntry 记录移入回收站的源文件
+type TrashEntry struct {
+	ID         int64     `db:"id"`
+	RecordID   int64     `db:"record_id"`
+	SourcePath string    `db:"source_path"`
+	TrashPath  string    `db:"trash_path"`
+	Size       int64     `db:"size"`
+	Status     string    `db:"status"` // trashed, purged, restored
+	TrashedAt  time.Time `db:"trashed_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
@@ -0,0 +1,302 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// 事件类型
+const (
+	EventMigrationDone   = "migration_done"
+	EventMigrationFailed = "migration_failed"
+	EventDeleteBlocked   = "delete_blocked"
+	EventDeleteFailed    = "delete_failed"
+	EventBreakerTripped  = "breaker_tripped"
+	EventDiskLow         = "disk_low"
+)
+
+// EventTypes 所有事件类型
+var EventTypes = []string{
+	EventMigrationDone,
+	EventMigrationFailed,
+	EventDeleteBlocked,
+	EventDeleteFailed,
+	EventBreakerTripped,
+	EventDiskLow,
+}
+
+// Event 需要通知的事件
+type Event struct {
+	Type     string
+	Time     time.Time
+	RecordID int64
+	Path     string // 源路径，磁盘空间不足时为目录
+	Target   string
+	Size     int64
+	Reason   string // 失败、阻止删除或熔断的原因
+}
+
+// DefaultTemplate 未配置模板时的消息格式
+const DefaultTemplate = `[{{.Type}}]{{if .Path}} {{.Path}}{{end}}{{if .Target}} -> {{.Target}}{{end}}{{if .Reason}}: {{.Reason}}{{end}}`
+
+// ParseTemplate 解析消息模板，可用 Event 的所有字段及 Suppressed（因限流未发送的消息数）
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse message template: %w", err)
+	}
+	if _, err := render(tmpl, Event{Type: EventMigrationDone}, 0); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// messageData 渲染模板的数据
+type messageData struct {
+	Event
+	Suppressed int
+}
+
+func render(tmpl *template.Template, e Event, suppressed int) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, messageData{Event: e, Suppressed: suppressed}); err != nil {
+		return "", fmt.Errorf("render message: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Message 发送给通知渠道的消息
+type Message struct {
+	Event Event
+	Text  string // 按模板渲染的文本
+	// 邮件主题，为空按事件生成；HTML 表示 Text 为HTML。只有 SMTP 使用
+	Subject string
+	HTML    bool
+}
+
+// Sink 通知渠道
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Route 通知渠道及其过滤、模板、限流和重试配置
+type Route struct {
+	Sink Sink
+	// 只发送这些类型的事件，为空表示全部
+	Events []string
+	// 消息模板，为空使用 DefaultTemplate
+	Template *template.Template
+	// 每分钟最多发送的消息数，超出的消息丢弃并计数，0表示不限制
+	RateLimit int
+	// 发送失败后的重试次数，重试间隔从 RetryDelay 开始逐次翻倍
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// queueSize 每个渠道等待发送的消息数上限，队列满时丢弃新消息
+const queueSize = 256
+
+// Notifier 将事件异步发送到各个通知渠道，每个渠道一个发送协程，互不阻塞
+type Notifier struct {
+	routes []*route
+	logger *zap.Logger
+	wg     sync.WaitGroup
+	// Close 超时后取消，中止进行中的发送和重试等待
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex // 保护 closed，关闭后不再向队列发送
+	closed bool
+}
+
+type route struct {
+	Route
+	events map[string]bool
+	queue  chan queued
+
+	mu         sync.Mutex // 保护限流状态
+	tokens     float64
+	refilled   time.Time
+	suppressed int
+}
+
+// New 创建通知器并启动各渠道的发送协程，用完后调用 Close
+func New(logger *zap.Logger, routes ...Route) *Notifier {
+	n := &Notifier{logger: logger}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	for _, r := range routes {
+		if r.Template == nil {
+			r.Template = template.Must(ParseTemplate(""))
+		}
+		if r.RetryDelay <= 0 {
+			r.RetryDelay = time.Second
+		}
+		rt := &route{
+			Route:    r,
+			queue:    make(chan queued, queueSize),
+			tokens:   float64(r.RateLimit),
+			refilled: time.Now(),
+		}
+		if len(r.Events) > 0 {
+			rt.events = make(map[string]bool)
+			for _, e := range r.Events {
+				rt.events[e] = true
+			}
+		}
+		n.routes = append(n.routes, rt)
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.run(rt)
+		}()
+	}
+	return n
+}
+
+// Notify 将事件加入匹配渠道的发送队列，不等待发送完成。n 为 nil 时不做任何事
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	for _, r := range n.routes {
+		if r.events != nil && !r.events[e.Type] {
+			continue
+		}
+		ok, suppressed := r.allow()
+		if !ok {
+			continue
+		}
+		select {
+		case r.queue <- queued{event: e, suppressed: suppressed}:
+		default:
+			n.logger.Warn("notification queue full, dropping event",
+				zap.String("sink", r.Sink.Name()),
+				zap.String("event", e.Type))
+		}
+	}
+}
+
+// queued 等待发送的事件及在它之前因限流丢弃的消息数
+type queued struct {
+	event      Event
+	suppressed int
+}
+
+// allow 按令牌桶限流，超出时计数，在下一条发送的消息中通过 Suppressed 告知
+func (r *route) allow() (bool, int) {
+	if r.RateLimit <= 0 {
+		return true, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.tokens += now.Sub(r.refilled).Minutes() * float64(r.RateLimit)
+	if r.tokens > float64(r.RateLimit) {
+		r.tokens = float64(r.RateLimit)
+	}
+	r.refilled = now
+	if r.tokens < 1 {
+		r.suppressed++
+		return false, 0
+	}
+	r.tokens--
+	suppressed := r.suppressed
+	r.suppressed = 0
+	return true, suppressed
+}
+
+func (n *Notifier) run(r *route) {
+	dropped := 0
+	for q := range r.queue {
+		if n.ctx.Err() != nil {
+			dropped++
+			continue
+		}
+		e := q.event
+		text, err := render(r.Template, e, q.suppressed)
+		if err != nil {
+			n.logger.Error("render notification", zap.Error(err), zap.String("sink", r.Sink.Name()))
+			continue
+		}
+		msg := Message{Event: e, Text: text}
+
+		for attempt := 0; ; attempt++ {
+			ctx, cancel := context.WithTimeout(n.ctx, 30*time.Second)
+			err = r.Sink.Send(ctx, msg)
+			cancel()
+			if err == nil {
+				break
+			}
+			if n.ctx.Err() != nil {
+				dropped++
+				break
+			}
+			if attempt >= r.Retries {
+				n.logger.Error("send notification",
+					zap.Error(err),
+					zap.String("sink", r.Sink.Name()),
+					zap.String("event", e.Type))
+				break
+			}
+			select {
+			case <-time.After(r.RetryDelay << attempt):
+			case <-n.ctx.Done():
+			}
+		}
+	}
+	if dropped > 0 {
+		n.logger.Warn("notifier closed, dropping unsent notifications",
+			zap.String("sink", r.Sink.Name()),
+			zap.Int("count", dropped))
+	}
+}
+
+// Close 停止接收新事件，最多等待 timeout 让队列中的消息发送完成，
+// 超时后中止进行中的发送和重试，丢弃仍未发送的消息
+func (n *Notifier) Close(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		for _, r := range n.routes {
+			close(r.queue)
+		}
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		n.cancel()
+		<-done
+	}
+	n.cancel()
+}
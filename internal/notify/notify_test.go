@@ -0,0 +1,234 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder 记录stub服务收到的请求体，前 fail 次返回500
+type recorder struct {
+	mu     sync.Mutex
+	fail   int
+	bodies []map[string]interface{}
+	paths  []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		http.Error(w, "try again", http.StatusInternalServerError)
+		return
+	}
+	var body map[string]interface{}
+	json.NewDecoder(req.Body).Decode(&body)
+	r.bodies = append(r.bodies, body)
+	r.paths = append(r.paths, req.URL.Path)
+}
+
+func (r *recorder) received() []map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]map[string]interface{}(nil), r.bodies...)
+}
+
+func newStub(t *testing.T, fail int) (*recorder, string) {
+	rec := &recorder{fail: fail}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	return rec, server.URL
+}
+
+func TestNotifier(t *testing.T) {
+	webhook, webhookURL := newStub(t, 1)
+	telegram, telegramURL := newStub(t, 0)
+	discord, discordURL := newStub(t, 0)
+
+	tmpl, err := ParseTemplate("{{.Type}}: {{.Path}} ({{.Reason}})")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New(zap.NewNop(),
+		Route{
+			Sink:       &Webhook{URL: webhookURL, Headers: map[string]string{"Authorization": "Bearer x"}},
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+		},
+		Route{
+			Sink:     &Telegram{Token: "123:abc", ChatID: "42", APIURL: telegramURL},
+			Events:   []string{EventDeleteBlocked, EventBreakerTripped},
+			Template: tmpl,
+		},
+		Route{
+			Sink:   &Discord{URL: discordURL},
+			Events: []string{EventMigrationFailed},
+		},
+	)
+	n.Notify(Event{Type: EventMigrationDone, Path: "/src/a.mkv", Target: "/dst/a.mkv"})
+	n.Notify(Event{Type: EventDeleteBlocked, Path: "/src/b.mkv", Reason: "checksum mismatch"})
+	n.Close(time.Minute)
+
+	// Webhook 接收全部事件，第一次失败后重试
+	got := webhook.received()
+	if len(got) != 2 {
+		t.Fatalf("webhook received %d events, want 2", len(got))
+	}
+	if got[0]["event"] != EventMigrationDone || got[0]["message"] != "[migration_done] /src/a.mkv -> /dst/a.mkv" {
+		t.Errorf("unexpected webhook body: %v", got[0])
+	}
+
+	got = telegram.received()
+	if len(got) != 1 {
+		t.Fatalf("telegram received %d events, want 1", len(got))
+	}
+	if got[0]["chat_id"] != "42" || got[0]["text"] != "delete_blocked: /src/b.mkv (checksum mismatch)" {
+		t.Errorf("unexpected telegram body: %v", got[0])
+	}
+	if telegram.paths[0] != "/bot123:abc/sendMessage" {
+		t.Errorf("telegram path = %s", telegram.paths[0])
+	}
+
+	if got := discord.received(); len(got) != 0 {
+		t.Errorf("discord received filtered events: %v", got)
+	}
+
+	// 关闭后不再发送
+	n.Notify(Event{Type: EventMigrationDone})
+}
+
+func TestNotifierCloseTimeout(t *testing.T) {
+	// 渠道始终失败且重试间隔很长，Close 到期后放弃剩余消息
+	webhook, webhookURL := newStub(t, 1000)
+	n := New(zap.NewNop(), Route{
+		Sink:       &Webhook{URL: webhookURL},
+		Retries:    5,
+		RetryDelay: time.Hour,
+	})
+	for i := 0; i < 3; i++ {
+		n.Notify(Event{Type: EventMigrationFailed})
+	}
+
+	start := time.Now()
+	n.Close(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	if got := webhook.received(); len(got) != 0 {
+		t.Errorf("webhook received %d messages, want 0", len(got))
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	discord, discordURL := newStub(t, 0)
+	tmpl, err := ParseTemplate("{{.Path}}{{if .Suppressed}} (+{{.Suppressed}} suppressed){{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New(zap.NewNop(), Route{Sink: &Discord{URL: discordURL}, Template: tmpl, RateLimit: 2})
+	for _, path := range []string{"a", "b", "c", "d"} {
+		n.Notify(Event{Type: EventMigrationFailed, Path: path})
+	}
+	// 令牌补充后，下一条消息附带被丢弃的条数
+	r := n.routes[0]
+	r.mu.Lock()
+	r.tokens = 1
+	r.mu.Unlock()
+	n.Notify(Event{Type: EventMigrationFailed, Path: "e"})
+	n.Close(time.Minute)
+
+	got := discord.received()
+	if len(got) != 3 {
+		t.Fatalf("discord received %d messages, want 3", len(got))
+	}
+	if got[2]["content"] != "e (+2 suppressed)" {
+		t.Errorf("content = %v", got[2]["content"])
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	if _, err := ParseTemplate("{{.Missing}}"); err == nil {
+		t.Error("expected error for unknown field")
+	}
+	if _, err := ParseTemplate("{{.Type"); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
+
+// smtpStub 只实现发送一封邮件所需命令的SMTP服务
+func smtpStub(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 stub ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					mails <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 stub")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func TestSMTP(t *testing.T) {
+	addr, mails := smtpStub(t)
+	sink := &SMTP{Address: addr, From: "epr@example.com", To: []string{"ops@example.com"}}
+	err := sink.Send(context.Background(), Message{
+		Event: Event{Type: EventBreakerTripped, Time: time.Now()},
+		Text:  "deletion circuit breaker tripped: target mount unhealthy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "Subject: embypathrefresh: breaker_tripped") ||
+		!strings.Contains(mail, "To: ops@example.com") ||
+		!strings.Contains(mail, "target mount unhealthy") {
+		t.Errorf("unexpected mail:\n%s", mail)
+	}
+}
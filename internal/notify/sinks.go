@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// postJSON 以JSON发送 body，非2xx响应视为失败
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// Webhook 将事件以JSON POST到任意地址
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// webhookBody Webhook 请求体
+type webhookBody struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	RecordID int64     `json:"record_id,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Message  string    `json:"message"`
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	e := msg.Event
+	return postJSON(ctx, httpClient(w.Client), w.URL, w.Headers, webhookBody{
+		Event:    e.Type,
+		Time:     e.Time,
+		RecordID: e.RecordID,
+		Path:     e.Path,
+		Target:   e.Target,
+		Size:     e.Size,
+		Reason:   e.Reason,
+		Message:  msg.Text,
+	})
+}
+
+// Telegram 通过Telegram机器人发送消息
+type Telegram struct {
+	Token  string
+	ChatID string
+	// Bot API地址，为空使用 https://api.telegram.org
+	APIURL string
+	Client *http.Client
+}
+
+func (t *Telegram) Name() string { return "telegram" }
+
+func (t *Telegram) Send(ctx context.Context, msg Message) error {
+	api := t.APIURL
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	err := postJSON(ctx, httpClient(t.Client), strings.TrimRight(api, "/")+"/bot"+t.Token+"/sendMessage", nil,
+		map[string]interface{}{
+			"chat_id":                  t.ChatID,
+			"text":                     msg.Text,
+			"disable_web_page_preview": true,
+		})
+	if err != nil && t.Token != "" {
+		// 请求地址中含有机器人token，不写入日志
+		return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), t.Token, "<token>"))
+	}
+	return err
+}
+
+// Discord 通过Discord频道的Webhook发送消息
+type Discord struct {
+	URL    string
+	Client *http.Client
+}
+
+// discordLimit Discord消息内容的长度上限
+const discordLimit = 2000
+
+func (d *Discord) Name() string { return "discord" }
+
+func (d *Discord) Send(ctx context.Context, msg Message) error {
+	text := msg.Text
+	if r := []rune(text); len(r) > discordLimit {
+		text = string(r[:discordLimit-1]) + "…"
+	}
+	return postJSON(ctx, httpClient(d.Client), d.URL, nil, map[string]string{"content": text})
+}
+
+// SMTP 通过邮件发送消息
+type SMTP struct {
+	Address  string // host:port
+	Username string // 为空时不认证
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Name() string { return "smtp" }
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	subject := msg.Subject
+	if subject == "" {
+		subject = "embypathrefresh: " + msg.Event.Type
+		if msg.Event.Path != "" {
+			subject += " " + msg.Event.Path
+		}
+	}
+	contentType := "text/plain"
+	if msg.HTML {
+		contentType = "text/html"
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", s.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", msg.Event.Time.Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: %s; charset=UTF-8\r\n\r\n", contentType)
+	body.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	body.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Address)
+		if err != nil {
+			return fmt.Errorf("parse smtp address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	// smtp.SendMail 不支持 ctx，放到协程中执行，超时后放弃等待
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Address, auth, s.From, s.To, body.Bytes())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("send mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+)
+
+// Ownership 目标文件和新建目录的属主及权限，UID/GID 为-1、mode 为0表示保持不变
+type Ownership struct {
+	UID      int
+	GID      int
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// WithOwnership 迁移后将目标文件和新建目录的属主改为 uid/gid，权限改为 fileMode/dirMode。
+// uid/gid 为-1或 mode 为0时保留源文件的值。设置了 Tier.Ownership 的层级使用该层级的设置
+func WithOwnership(uid, gid int, fileMode, dirMode os.FileMode) Option {
+	return func(p *Processor) {
+		p.owner = Ownership{
+			UID:      uid,
+			GID:      gid,
+			FileMode: fileMode,
+			DirMode:  dirMode,
+		}
+	}
+}
+
+// preservedMode 复制属性时保留的权限位，包括 setuid、setgid 和 sticky 位
+// （媒体目录常用 setgid 让新文件继承共享的组）
+const preservedMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// copyAttrs 将源文件的属主、权限、扩展属性和时间戳复制到 dst。
+// 没有权限保留属主或目标文件系统不支持扩展属性时只记录日志
+func (p *Processor) copyAttrs(src string, info os.FileInfo, dst string) error {
+	if uid, gid, ok := fileOwner(info); ok {
+		if err := os.Lchown(dst, uid, gid); err != nil {
+			p.logger.Debug("preserve file owner", zap.Error(err), zap.String("path", dst))
+		}
+	}
+	// 修改属主会清除 setuid/setgid 位，因此在之后设置权限
+	if err := os.Chmod(dst, info.Mode()&preservedMode); err != nil {
+		return fmt.Errorf("preserve file mode: %w", err)
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		p.logger.Warn("preserve extended attributes", zap.Error(err), zap.String("path", dst))
+	}
+	// 修改属主和权限不影响修改时间，最后设置时间戳
+	if err := os.Chtimes(dst, fileAtime(info), info.ModTime()); err != nil {
+		return fmt.Errorf("preserve file times: %w", err)
+	}
+	return nil
+}
+
+// copyDirAttrs 将源目录的属主和权限复制到新建的目标目录
+func (p *Processor) copyDirAttrs(info os.FileInfo, dst string) {
+	if uid, gid, ok := fileOwner(info); ok {
+		if err := os.Lchown(dst, uid, gid); err != nil {
+			p.logger.Debug("preserve directory owner", zap.Error(err), zap.String("path", dst))
+		}
+	}
+	if err := os.Chmod(dst, info.Mode()&preservedMode); err != nil {
+		p.logger.Warn("preserve directory mode", zap.Error(err), zap.String("path", dst))
+	}
+}
+
+// ownershipFor 返回 path 所在层级使用的属主和权限
+func (p *Processor) ownershipFor(path string) Ownership {
+	for _, tier := range p.tiers {
+		if _, ok := relWithin(tier.Dir, path); ok && tier.Ownership != nil {
+			return *tier.Ownership
+		}
+	}
+	return p.owner
+}
+
+// applyOwnership 应用 target 所在层级配置的属主和权限，path 为 target 或为它新建的目录、.strm 文件
+func (p *Processor) applyOwnership(path, target string, isDir bool) error {
+	owner := p.ownershipFor(target)
+	mode := owner.FileMode
+	if isDir {
+		mode = owner.DirMode
+	}
+	if owner.UID >= 0 || owner.GID >= 0 {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("change owner: %w", err)
+		}
+		if err := os.Lchown(path, owner.UID, owner.GID); err != nil {
+			return fmt.Errorf("change owner: %w", err)
+		}
+		// 修改属主会清除 setuid/setgid 位，没有配置权限时恢复原来的权限
+		if mode == 0 && info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			mode = info.Mode() & preservedMode
+		}
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("change mode: %w", err)
+		}
+	}
+	return nil
+}
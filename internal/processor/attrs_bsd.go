@@ -0,0 +1,30 @@
+//go:build darwin || freebsd
+
+package processor
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+func fileAtime(info os.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec))
+}
+
+// copyXattrs 在该平台上不复制扩展属性
+func copyXattrs(src, dst string) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessor_CopyPreservesXattrs(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "e01.mkv", "episode")
+	if err := unix.Setxattr(source, "user.embypathrefresh", []byte("tagged"), 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			t.Skipf("user xattrs not supported: %v", err)
+		}
+		t.Fatal(err)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := getXattr(filepath.Join(env.targetDir, "e01.mkv"), "user.embypathrefresh")
+	if err != nil || string(value) != "tagged" {
+		t.Errorf("xattr was not preserved: %q, %v", value, err)
+	}
+}
+
+func TestProcessor_OwnershipOverrideChown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owner requires root")
+	}
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithOwnership(1234, 5678, 0, 0))
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(env.targetDir, "show", "e01.mkv")
+	for _, path := range []string{target, filepath.Dir(target)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := info.Sys().(*syscall.Stat_t)
+		if st.Uid != 1234 || st.Gid != 5678 {
+			t.Errorf("%s owner = %d:%d, want 1234:5678", path, st.Uid, st.Gid)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd
+
+package processor
+
+import (
+	"os"
+	"time"
+)
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func fileAtime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// copyXattrs 在该平台上不复制扩展属性
+func copyXattrs(src, dst string) error {
+	return nil
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_CopyPreservesAttributes(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if err := os.Chmod(source, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Dir(source), 0750); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(source, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(env.targetDir, "show", "e01.mkv")
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("target mode = %v, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("target mtime = %v, want %v", info.ModTime(), mtime)
+	}
+	if dir, err := os.Stat(filepath.Dir(target)); err != nil || dir.Mode().Perm() != 0750 {
+		t.Errorf("target directory mode was not preserved: %v", err)
+	}
+}
+
+func TestProcessor_CopyPreservesSetgid(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	// 媒体目录设置 setgid，新文件继承共享的组
+	if err := os.Chmod(filepath.Dir(source), 0775|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(source, 0664|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(env.targetDir, "show", "e01.mkv")
+	if dir, err := os.Stat(filepath.Dir(target)); err != nil || dir.Mode()&preservedMode != 0775|os.ModeSetgid {
+		t.Errorf("target directory setgid bit was not preserved: %v", err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode()&preservedMode != 0664|os.ModeSetgid {
+		t.Errorf("target file setgid bit was not preserved: %v", err)
+	}
+}
+
+func TestProcessor_OwnershipOverride(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithOwnership(-1, -1, 0664, 0775))
+
+	source := env.writeFile(t, env.sourceDir, "movie/movie.mkv", "movie")
+	if err := os.Chmod(source, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(env.targetDir, "movie", "movie.mkv")
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0664 {
+		t.Errorf("configured file mode was not applied: %v", err)
+	}
+	if info, err := os.Stat(filepath.Dir(target)); err != nil || info.Mode().Perm() != 0775 {
+		t.Errorf("configured directory mode was not applied: %v", err)
+	}
+}
+
+func TestProcessor_TierOwnership(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "cold")
+	if err := os.Mkdir(coldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	proc := env.newProcessor(t, WithOwnership(-1, -1, 0664, 0775),
+		WithTiers([]Tier{{Dir: coldDir, Ownership: &Ownership{UID: -1, GID: -1, FileMode: 0640, DirMode: 0750}}}))
+
+	// 目标目录使用全局设置，配置了权限的层级使用该层级的设置
+	for _, tt := range []struct {
+		root              string
+		fileMode, dirMode os.FileMode
+	}{
+		{env.targetDir, 0664, 0775},
+		{coldDir, 0640, 0750},
+	} {
+		source := env.writeFile(t, env.sourceDir, "movie/movie.mkv", "movie")
+		target := filepath.Join(tt.root, "movie", "movie.mkv")
+		if err := proc.makeTargetDirs(filepath.Dir(target)); err != nil {
+			t.Fatal(err)
+		}
+		if err := proc.moveFile(context.Background(), source, target, false); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(target); err != nil || info.Mode().Perm() != tt.fileMode {
+			t.Errorf("%s: configured file mode %v was not applied: %v", tt.root, tt.fileMode, err)
+		}
+		if info, err := os.Stat(filepath.Dir(target)); err != nil || info.Mode().Perm() != tt.dirMode {
+			t.Errorf("%s: configured directory mode %v was not applied: %v", tt.root, tt.dirMode, err)
+		}
+	}
+}
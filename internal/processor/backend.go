@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Backend 迁移链最后一级的远程存储后端，key 为文件相对该级目录的路径（以 / 分隔）。
+// 本地目录（包括已挂载的网络存储）的层级直接读写文件系统，不经过 Backend
+type Backend interface {
+	// Upload 将本地文件 src 写入 key 并校验，返回内容的sha256
+	Upload(ctx context.Context, key, src string) (string, error)
+	// Stat 返回 key 的大小，不存在时返回 os.ErrNotExist
+	Stat(ctx context.Context, key string) (int64, error)
+	// Checksum 读取 key 的内容并计算sha256
+	Checksum(ctx context.Context, key string) (string, error)
+	// Download 将 key 下载到本地文件 dst，大小和sha256与 size、checksum 一致后才写入 dst，
+	// checksum 为空时只校验大小
+	Download(ctx context.Context, key, dst string, size int64, checksum string) error
+	// Delete 删除 key，不存在时不报错
+	Delete(ctx context.Context, key string) error
+}
+
+// LazyBackend 返回在第一次使用时才调用 create 创建的存储后端，创建失败时下次使用再重试。
+// 只读取状态库的命令不会连接远程存储
+func LazyBackend(create func() (Backend, error)) Backend {
+	return &lazyBackend{create: create}
+}
+
+type lazyBackend struct {
+	mu      sync.Mutex
+	create  func() (Backend, error)
+	backend Backend
+}
+
+func (b *lazyBackend) get() (Backend, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.backend == nil {
+		backend, err := b.create()
+		if err != nil {
+			return nil, err
+		}
+		b.backend = backend
+	}
+	return b.backend, nil
+}
+
+func (b *lazyBackend) Upload(ctx context.Context, key, src string) (string, error) {
+	backend, err := b.get()
+	if err != nil {
+		return "", err
+	}
+	return backend.Upload(ctx, key, src)
+}
+
+func (b *lazyBackend) Stat(ctx context.Context, key string) (int64, error) {
+	backend, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	return backend.Stat(ctx, key)
+}
+
+func (b *lazyBackend) Checksum(ctx context.Context, key string) (string, error) {
+	backend, err := b.get()
+	if err != nil {
+		return "", err
+	}
+	return backend.Checksum(ctx, key)
+}
+
+func (b *lazyBackend) Download(ctx context.Context, key, dst string, size int64, checksum string) error {
+	backend, err := b.get()
+	if err != nil {
+		return err
+	}
+	return backend.Download(ctx, key, dst, size, checksum)
+}
+
+func (b *lazyBackend) Delete(ctx context.Context, key string) error {
+	backend, err := b.get()
+	if err != nil {
+		return err
+	}
+	return backend.Delete(ctx, key)
+}
+
+// Close 关闭已创建的后端，未使用过时不做任何事
+func (b *lazyBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if closer, ok := b.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// writeDownload 将 r 的内容经临时文件写入 dst，大小和sha256与 size、checksum 一致后才重命名为 dst。
+// checksum 为空时只校验大小
+func writeDownload(r io.Reader, dst string, size int64, checksum string) error {
+	tmp := dst + partialSuffix
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != size {
+		err = fmt.Errorf("downloaded %d bytes, want %d", n, size)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); err == nil && checksum != "" && got != checksum {
+		err = fmt.Errorf("downloaded checksum %s does not match recorded checksum %s", got, checksum)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// backendFor 返回 path 所在层级的存储后端及对象key，该层级是普通目录时返回 nil
+func (p *Processor) backendFor(path string) (Backend, string) {
+	for _, tier := range p.tiers {
+		if tier.Backend == nil {
+			continue
+		}
+		if rel, ok := relWithin(tier.Dir, path); ok && rel != "." {
+			return tier.Backend, filepath.ToSlash(rel)
+		}
+	}
+	return nil, ""
+}
+
+// statTarget 返回目标文件的大小，目标位于存储后端时查询后端
+func (p *Processor) statTarget(ctx context.Context, path string) (int64, error) {
+	if backend, key := p.backendFor(path); backend != nil {
+		return backend.Stat(ctx, key)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("target is not a regular file")
+	}
+	return info.Size(), nil
+}
+
+// targetChecksum 计算目标文件的sha256，目标位于存储后端时读取后端中的内容
+func (p *Processor) targetChecksum(ctx context.Context, path string) (string, error) {
+	if backend, key := p.backendFor(path); backend != nil {
+		return backend.Checksum(ctx, key)
+	}
+	return fileChecksum(path)
+}
+
+// upload 将文件写入存储后端并切换Emby路径。源文件保留到 CleanupFiles 按计划删除
+func (p *Processor) upload(ctx context.Context, record *model.FileRecord, backend Backend, key string) error {
+	if err := p.beforeMove(ctx, record); err != nil {
+		return err
+	}
+	checksum, err := backend.Upload(ctx, key, record.SourcePath)
+	if err != nil {
+		return fmt.Errorf("upload file: %w", err)
+	}
+	record.Checksum = checksum
+	p.recordEvent(record.ID, record.SourcePath, EventCopied, "uploaded to "+record.TargetPath, nil)
+	p.afterHooks(ctx, HookAfterMove, record)
+
+	itemIDs, err := p.updateEmbyPath(ctx, record)
+	if err != nil {
+		if rbErr := backend.Delete(context.Background(), key); rbErr != nil {
+			p.logger.Error("roll back upload", zap.Error(rbErr), zap.String("key", key))
+		}
+		return err
+	}
+
+	if err := p.markProcessed(record, itemIDs); err != nil {
+		return err
+	}
+	p.afterHooks(ctx, HookAfterEmbyUpdate, record)
+	p.refreshEmby(ctx, []refreshRequest{{recordID: record.ID, itemIDs: itemIDs}})
+	return nil
+}
+
+// fetchTarget 将记录 id 在存储后端中的目标文件下载到本地路径 dst，按记录的大小和校验值校验
+func (p *Processor) fetchTarget(ctx context.Context, id int64, target, dst string) error {
+	backend, key := p.backendFor(target)
+	if backend == nil {
+		return fmt.Errorf("%s is not in a storage backend", target)
+	}
+	record, err := p.appDB.Record(id)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("record %d not found", id)
+	}
+	return backend.Download(ctx, key, dst, record.FileSize, record.Checksum)
+}
+
+// removeTarget 删除目标文件，目标位于存储后端时删除对象
+func (p *Processor) removeTarget(ctx context.Context, path string) error {
+	if backend, key := p.backendFor(path); backend != nil {
+		return backend.Delete(ctx, key)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// statBackend 只实现 Stat 的测试后端，读取 root 下的文件
+type statBackend struct {
+	Backend
+	root string
+}
+
+func (b statBackend) Stat(ctx context.Context, key string) (int64, error) {
+	info, err := os.Stat(filepath.Join(b.root, key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func TestLazyBackend(t *testing.T) {
+	root := t.TempDir()
+	calls := 0
+	fail := true
+	backend := LazyBackend(func() (Backend, error) {
+		calls++
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		return statBackend{root: root}, nil
+	})
+	if calls != 0 {
+		t.Fatal("backend was created before first use")
+	}
+
+	// 创建失败时下次使用再重试
+	if _, err := backend.Stat(context.Background(), "a.mkv"); err == nil {
+		t.Fatal("expected error")
+	}
+	fail = false
+	if err := os.WriteFile(filepath.Join(root, "a.mkv"), []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if size, err := backend.Stat(context.Background(), "a.mkv"); err != nil || size != 5 {
+			t.Errorf("Stat() = %d, %v", size, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("backend created %d times, want 2", calls)
+	}
+}
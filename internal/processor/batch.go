@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// embyBatch Emby路径批量切换的配置和状态
+type embyBatch struct {
+	size    int
+	maxWait time.Duration
+
+	mu      sync.Mutex // 保护 pending 和 timer
+	pending int
+	timer   *time.Timer
+	flushMu sync.Mutex // 串行执行 FlushEmby
+}
+
+// WithEmbyBatch 将Emby路径切换合并为批量事务：文件移动后先记录为 copied，
+// 累计 size 个或第一个文件等待 maxWait 后在同一个事务中切换。size 不大于1时每个文件立即切换
+func WithEmbyBatch(size int, maxWait time.Duration) Option {
+	return func(p *Processor) {
+		p.batch = &embyBatch{
+			size:    size,
+			maxWait: maxWait,
+		}
+	}
+}
+
+// queueEmbySwitch 保存已移动文件的记录并加入待切换批次，批次已满时立即切换
+func (p *Processor) queueEmbySwitch(record *model.FileRecord) error {
+	if err := p.recordTarget(record); err != nil {
+		return err
+	}
+	record.Status = "copied"
+	record.UpdatedAt = time.Now()
+	if err := p.saveRecord(record); err != nil {
+		return err
+	}
+
+	b := p.batch
+	b.mu.Lock()
+	b.pending++
+	full := b.pending >= b.size
+	if !full && b.timer == nil && b.maxWait > 0 {
+		b.timer = time.AfterFunc(b.maxWait, func() {
+			if err := p.FlushEmby(); err != nil {
+				p.logger.Error("flush emby path switches", zap.Error(err))
+			}
+		})
+	}
+	b.mu.Unlock()
+
+	if full {
+		return p.FlushEmby()
+	}
+	return nil
+}
+
+// FlushEmby 在一个事务中切换所有 copied 记录的Emby路径。
+// 单条更新失败时回滚到该条的保存点并撤销其文件移动，不影响同批的其他记录。
+// 多个实例共享状态库时只切换取得租约的记录
+func (p *Processor) FlushEmby() error {
+	b := p.batch
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	b.pending = 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	records, release, err := p.claimCopied()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if len(records) == 0 {
+		return nil
+	}
+
+	tx, err := p.embyDB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	itemIDs := make([][]int64, len(records))
+	failures := make([]error, len(records))
+	for i := range records {
+		savepoint := fmt.Sprintf("record_%d", records[i].ID)
+		if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+			return fmt.Errorf("create savepoint: %w", err)
+		}
+		itemIDs[i], failures[i] = switchEmbyPath(tx, records[i].SourcePath, records[i].TargetPath)
+		records[i].EmbyItems = int64(len(itemIDs[i]))
+		if failures[i] != nil {
+			if _, err := tx.Exec("ROLLBACK TO " + savepoint); err != nil {
+				return fmt.Errorf("roll back to savepoint: %w", err)
+			}
+		}
+		if _, err := tx.Exec("RELEASE " + savepoint); err != nil {
+			return fmt.Errorf("release savepoint: %w", err)
+		}
+	}
+	// 提交失败时记录保持 copied，下次重试
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	switched := 0
+	var refreshes []refreshRequest
+	for i := range records {
+		record := &records[i]
+		if failures[i] != nil {
+			p.logger.Error("switch emby path", zap.Error(failures[i]), zap.String("path", record.SourcePath))
+			p.abortCopied(record, failures[i])
+			p.migrationFailed(record, failures[i])
+			continue
+		}
+		if err := p.markProcessed(record, itemIDs[i]); err != nil {
+			p.logger.Error("update record status", zap.Error(err), zap.Int64("id", record.ID))
+			continue
+		}
+		p.afterHooks(context.Background(), HookAfterEmbyUpdate, record)
+		refreshes = append(refreshes, refreshRequest{recordID: record.ID, itemIDs: itemIDs[i]})
+		switched++
+		// 同一文件系统内重命名后源文件已不存在，清理变空的源目录
+		if _, err := os.Lstat(record.SourcePath); os.IsNotExist(err) {
+			p.pruneEmptyDirs(filepath.Dir(record.SourcePath))
+		}
+	}
+
+	p.logger.Info("switched emby paths",
+		zap.Int("records", len(records)),
+		zap.Int("switched", switched))
+	p.refreshEmby(context.Background(), refreshes)
+	return nil
+}
+
+// abortCopied 切换Emby路径失败时撤销文件移动，并将记录标记为 failed 保存失败原因，文件之后重新迁移时沿用该记录。
+// 源文件仍在说明是跨文件系统复制，删除目标文件即可
+func (p *Processor) abortCopied(record *model.FileRecord, cause error) {
+	_, err := os.Lstat(record.SourcePath)
+	copied := err == nil
+	if !copied {
+		if err := os.MkdirAll(filepath.Dir(record.SourcePath), 0755); err != nil {
+			p.logger.Error("create source directory", zap.Error(err), zap.String("path", record.SourcePath))
+			return
+		}
+	}
+	if err := undoMove(record.SourcePath, record.TargetPath, copied); err != nil {
+		p.logger.Error("roll back file move", zap.Error(err), zap.String("path", record.TargetPath))
+		return
+	}
+	record.Status = "failed"
+	record.FailureReason = cause.Error()
+	record.EmbyItems = 0
+	record.UpdatedAt = time.Now()
+	if err := p.saveRecord(record); err != nil {
+		p.logger.Error("update record status", zap.Error(err), zap.Int64("id", record.ID))
+	}
+}
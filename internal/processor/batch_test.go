@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addEmbyItems 为每个源文件插入一条Emby条目，条目ID从1开始
+func (env *testEnv) addEmbyItems(t *testing.T, paths ...string) {
+	t.Helper()
+	for i, path := range paths {
+		if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (?, ?)", i+1, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessor_EmbyBatch(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithEmbyBatch(3, time.Hour))
+
+	var sources []string
+	for _, name := range []string{"e01.mkv", "e02.mkv", "e03.mkv"} {
+		sources = append(sources, env.writeFile(t, env.sourceDir, filepath.Join("show", name), name))
+	}
+	env.addEmbyItems(t, sources...)
+
+	var ids []int64
+	for _, source := range sources[:2] {
+		ids = append(ids, env.migrate(t, proc, source))
+	}
+	// 批次未满，文件已移动但Emby路径尚未切换
+	for i, id := range ids {
+		if status := env.recordStatus(t, id); status != "copied" {
+			t.Errorf("record %d status = %s, want copied", id, status)
+		}
+		if path := env.embyPath(t, int64(i+1)); path != sources[i] {
+			t.Errorf("emby path switched before batch was full: %s", path)
+		}
+	}
+
+	ids = append(ids, env.migrate(t, proc, sources[2]))
+	for i, id := range ids {
+		if status := env.recordStatus(t, id); status != "processed" {
+			t.Errorf("record %d status = %s, want processed", id, status)
+		}
+		want := filepath.Join(env.targetDir, "show", filepath.Base(sources[i]))
+		if path := env.embyPath(t, int64(i+1)); path != want {
+			t.Errorf("emby path = %s, want %s", path, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(env.sourceDir, "show")); err != nil {
+		t.Error("source directory should be kept when pruning is disabled")
+	}
+}
+
+func TestProcessor_EmbyBatchItemFailure(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithEmbyBatch(2, time.Hour))
+
+	good := env.writeFile(t, env.sourceDir, "good.mkv", "good")
+	bad := env.writeFile(t, env.sourceDir, "bad.mkv", "bad")
+	env.addEmbyItems(t, good, bad)
+	if _, err := env.embyDB.Exec(`
+		CREATE TRIGGER reject_bad BEFORE UPDATE ON MediaItems
+		WHEN NEW.Path LIKE '%bad.mkv'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	goodID := env.migrate(t, proc, good)
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: bad, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 失败的条目单独回滚，同批的其他条目照常切换
+	if status := env.recordStatus(t, goodID); status != "processed" {
+		t.Errorf("good record status = %s, want processed", status)
+	}
+	if path := env.embyPath(t, 1); path != filepath.Join(env.targetDir, "good.mkv") {
+		t.Errorf("good emby path = %s", path)
+	}
+	if path := env.embyPath(t, 2); path != bad {
+		t.Errorf("bad emby path = %s, want %s", path, bad)
+	}
+	if _, err := os.Stat(bad); err != nil {
+		t.Errorf("failed file was not moved back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.targetDir, "bad.mkv")); !os.IsNotExist(err) {
+		t.Error("failed file was left in target")
+	}
+	var status, reason string
+	if err := env.appDB.QueryRow("SELECT status, failure_reason FROM file_records WHERE source_path = ?", bad).
+		Scan(&status, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" || !strings.Contains(reason, "rejected") {
+		t.Errorf("failed record = %s %q, want failed with reason", status, reason)
+	}
+}
+
+func TestProcessor_EmbyBatchMaxWait(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithEmbyBatch(100, 20*time.Millisecond))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	id := env.migrate(t, proc, source)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for env.recordStatus(t, id) != "processed" {
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not flushed after max wait")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessor_FlushEmbyResumesCopied(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithEmbyBatch(100, time.Hour))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	env.addEmbyItems(t, source)
+	id := env.migrate(t, proc, source)
+	proc.Close()
+
+	// 重启后切换上次未完成的记录
+	restarted := env.newProcessor(t)
+	if err := restarted.FlushEmby(); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "processed" {
+		t.Errorf("record status = %s, want processed", status)
+	}
+	if path := env.embyPath(t, 1); path != filepath.Join(env.targetDir, "movie.mkv") {
+		t.Errorf("emby path = %s", path)
+	}
+}
+
+func TestProcessor_FlushEmbyLeased(t *testing.T) {
+	env := newTestEnv(t)
+	a := env.newProcessor(t, WithEmbyBatch(100, time.Hour), WithLeases("a", time.Minute))
+	b := env.newProcessor(t, WithEmbyBatch(100, time.Hour), WithLeases("b", time.Minute))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	env.addEmbyItems(t, source)
+	id := env.migrate(t, a, source)
+
+	// a 正在切换该文件时 b 跳过
+	if ok, err := a.acquireLease(fileLease(source)); err != nil || !ok {
+		t.Fatalf("acquireLease() = %v, %v", ok, err)
+	}
+	if err := b.FlushEmby(); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "copied" {
+		t.Errorf("record switched while leased by another instance, status %s", status)
+	}
+
+	if err := a.FlushEmby(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.FlushEmby(); err != nil {
+		t.Fatal(err)
+	}
+	var items int64
+	if err := env.appDB.QueryRow("SELECT emby_items FROM file_records WHERE id = ?", id).Scan(&items); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "processed" || items != 1 {
+		t.Errorf("record = %s with %d emby items, want processed with 1", status, items)
+	}
+}
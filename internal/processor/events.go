@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"time"
+)
+
+// 审计事件
+const (
+	EventDetected          = "detected"           // 发现需要迁移的文件
+	EventScheduled         = "scheduled"          // 分层策略或停留到期选中文件
+	EventWaitingForSpace   = "waiting_for_space"  // 目标空间不足，等待重试
+	EventCopied            = "copied"             // 文件已移动、复制或上传到目标路径
+	EventEmbyUpdated       = "emby_updated"       // Emby路径已切换
+	EventDeletionScheduled = "deletion_scheduled" // 安排删除源文件
+	EventVerified          = "verified"           // 删除前校验通过
+	EventDeleteBlocked     = "delete_blocked"
+	EventDeleted           = "deleted"
+	EventFailed            = "failed"
+	EventReverted          = "reverted"
+	EventRestored          = "restored" // 源文件从回收站恢复
+	EventPromoted          = "promoted"
+	EventReplaced          = "replaced" // 导入时用导入的数据覆盖记录
+)
+
+// 执行操作的一方
+const (
+	ActorDaemon = "daemon"
+	ActorCLI    = "cli"
+)
+
+// WithActor 设置审计事件中记录的操作方，默认为 daemon
+func WithActor(actor string) Option {
+	return func(p *Processor) {
+		p.actor = actor
+	}
+}
+
+// recordEvent 追加一条审计事件，写入失败只记录日志，不影响迁移。
+// recordID 为0时事件先只记录路径，记录保存后由 saveRecord 关联
+func (p *Processor) recordEvent(recordID int64, path, event, details string, cause error) {
+	var errText string
+	if cause != nil {
+		errText = cause.Error()
+	}
+	err := p.appDB.InsertEvent(&model.RecordEvent{
+		RecordID:  recordID,
+		Path:      path,
+		Event:     event,
+		Actor:     p.actor,
+		Details:   details,
+		Error:     errText,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		p.logger.Error("record event", zap.Error(err), zap.String("event", event), zap.String("path", path))
+	}
+}
+
+// EventFilter 查询审计事件的条件，零值字段不限制
+type EventFilter struct {
+	RecordID int64
+	// 源路径或目标路径，同时包含以该路径为源或目标的记录的事件
+	Path  string
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// Events 按时间顺序返回符合条件的审计事件
+func (p *Processor) Events(filter EventFilter) ([]model.RecordEvent, error) {
+	return p.appDB.Events(database.EventFilter(filter))
+}
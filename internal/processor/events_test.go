@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessor_RecordEvents(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	id := env.migrate(t, proc, source)
+
+	cli := env.newProcessor(t, WithActor(ActorCLI))
+	if _, err := cli.Undo(UndoSelector{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按目标路径查询同样能找到该记录的全部事件，包括记录保存前发生的
+	events, err := proc.Events(EventFilter{Path: filepath.Join(env.targetDir, "show", "e01.mkv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range events {
+		if e.RecordID != id {
+			t.Errorf("event %s has record id %d, want %d", e.Event, e.RecordID, id)
+		}
+		got = append(got, e.Event+"/"+e.Actor)
+	}
+	want := "detected/daemon copied/daemon emby_updated/daemon deletion_scheduled/daemon reverted/cli"
+	if strings.Join(got, " ") != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+
+	// 删除被阻止时记录原因
+	blocked := env.insertRecord(t, env.writeFile(t, env.sourceDir, "gone.mkv", "gone"),
+		filepath.Join(env.targetDir, "gone.mkv"), time.Now().Add(-time.Hour))
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events, err = proc.Events(EventFilter{RecordID: blocked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Event != EventDeleteBlocked || !strings.Contains(events[0].Details, "stat target") {
+		t.Errorf("blocked events = %+v", events)
+	}
+
+	events, err = proc.Events(EventFilter{From: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %d events after the time range", len(events))
+	}
+	events, err = proc.Events(EventFilter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Event != EventDetected {
+		t.Errorf("limited events = %+v", events)
+	}
+}
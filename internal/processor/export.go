@@ -0,0 +1,416 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 导出格式
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// 导入的数据与已有数据冲突时的处理方式
+const (
+	ConflictSkip    = "skip"    // 保留已有数据
+	ConflictReplace = "replace" // 用导入的数据覆盖ID相同的行
+	ConflictFail    = "fail"    // 有任何冲突时不导入
+)
+
+// ExportFilter 导出的条件，零值字段不限制。
+// 事件按所属记录的状态、目录映射和路径筛选，时间范围按各自的创建时间
+type ExportFilter struct {
+	Statuses []string
+	Hop      *int   // 迁移链上的第几跳，即使用的哪一组目录映射
+	Prefix   string // 源路径或目标路径的前缀
+	From     time.Time
+	To       time.Time
+}
+
+// ExportRecords 按条件将迁移记录写入 w，返回导出的条数
+func (p *Processor) ExportRecords(w io.Writer, format string, filter ExportFilter) (int, error) {
+	return p.export(w, format, database.RecordsTable, filter)
+}
+
+// ExportEvents 按条件将审计事件写入 w，返回导出的条数
+func (p *Processor) ExportEvents(w io.Writer, format string, filter ExportFilter) (int, error) {
+	return p.export(w, format, database.EventsTable, filter)
+}
+
+func (p *Processor) export(w io.Writer, format string, table database.Table, filter ExportFilter) (int, error) {
+	var enc rowEncoder
+	switch format {
+	case FormatJSONL:
+		enc = &jsonlEncoder{w: w, columns: table.Columns}
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(table.Names()); err != nil {
+			return 0, fmt.Errorf("write csv header: %w", err)
+		}
+		enc = &csvEncoder{w: cw}
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	n, err := p.appDB.ExportRows(table, database.ExportFilter(filter), func(values []interface{}) error {
+		if err := enc.encode(values); err != nil {
+			return fmt.Errorf("write %s: %w", table.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, enc.flush()
+}
+
+type rowEncoder interface {
+	encode(values []interface{}) error
+	flush() error
+}
+
+// jsonlEncoder 每行一个JSON对象，字段按列的顺序输出
+type jsonlEncoder struct {
+	w       io.Writer
+	columns []database.Column
+}
+
+func (e *jsonlEncoder) encode(values []interface{}) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, c := range e.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(c.Name))
+		b.WriteByte(':')
+		v := values[i]
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+	}
+	b.WriteString("}\n")
+	_, err := e.w.Write(b.Bytes())
+	return err
+}
+
+func (e *jsonlEncoder) flush() error {
+	return nil
+}
+
+// csvEncoder NULL 输出为空字段
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) encode(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			record[i] = v.Format(time.RFC3339Nano)
+		case string:
+			record[i] = v
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ImportSummary 汇总一次导入的结果
+type ImportSummary struct {
+	Imported int // 新增的行数
+	Replaced int // 覆盖已有行的行数
+	Skipped  int // 因冲突跳过的行数
+}
+
+// ImportRecords 从 r 导入迁移记录，所有行在一个事务中导入，出错时不导入任何行。记录保留原ID，删除计划、迁移链和审计事件的关联保持不变，
+// 重建的主机可以从原主机停下的地方继续。ID已存在，或源路径已有其他进行中的记录时为冲突，按 conflict 处理；
+// 源路径冲突的记录在 replace 时同样跳过，进行中或被租约持有的记录不覆盖
+func (p *Processor) ImportRecords(r io.Reader, format, conflict string) (*ImportSummary, error) {
+	return p.importRows(r, format, conflict, database.RecordsTable)
+}
+
+// ImportEvents 从 r 导入审计事件，保留原ID和所属记录。ID已存在时为冲突，按 conflict 处理
+func (p *Processor) ImportEvents(r io.Reader, format, conflict string) (*ImportSummary, error) {
+	return p.importRows(r, format, conflict, database.EventsTable)
+}
+
+func (p *Processor) importRows(r io.Reader, format, conflict string, table database.Table) (*ImportSummary, error) {
+	switch conflict {
+	case ConflictSkip, ConflictReplace, ConflictFail:
+	default:
+		return nil, fmt.Errorf("unknown conflict mode %q", conflict)
+	}
+
+	var rows [][]interface{}
+	var err error
+	switch format {
+	case FormatJSONL:
+		rows, err = decodeJSONL(r, table.Columns)
+	case FormatCSV:
+		rows, err = decodeCSV(r, table.Columns)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 按ID顺序导入，上一跳的记录先于下一跳写入
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0].(int64) < rows[j][0].(int64) })
+
+	summary := &ImportSummary{}
+	err = p.appDB.InTx(func(q database.Queries) error {
+		err := p.importTx(q, rows, conflict, table, summary)
+		// 出错时整个导入回滚。仍然同步序列：PostgreSQL 的 setval 不随事务回滚，
+		// 同步后的序列总不小于表中的最大ID
+		if syncErr := q.SyncSequence(table); err == nil {
+			err = syncErr
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// importTx 在事务 q 中导入 rows。ConflictFail 时先检查所有行，检查和写入在同一事务中，
+// 期间其他实例写入的行不会被漏掉
+func (p *Processor) importTx(q database.Queries, rows [][]interface{}, conflict string, table database.Table, summary *ImportSummary) error {
+	if conflict == ConflictFail {
+		for _, row := range rows {
+			exists, taken, err := importConflict(q, table, row)
+			if err != nil {
+				return err
+			}
+			if exists || taken {
+				return fmt.Errorf("%s row %d conflicts with existing data", table.Name, row[0])
+			}
+		}
+	}
+
+	for _, row := range rows {
+		exists, taken, err := importConflict(q, table, row)
+		if err != nil {
+			return err
+		}
+		switch {
+		case taken:
+			p.logger.Warn("skip imported record, source path is in use by another record",
+				zap.Int64("id", row[0].(int64)), zap.Any("path", row[1]))
+			summary.Skipped++
+		case exists && conflict == ConflictReplace:
+			replaced, err := p.replaceRow(q, table, row)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				summary.Replaced++
+			} else {
+				summary.Skipped++
+			}
+		case exists:
+			summary.Skipped++
+		default:
+			if err := q.InsertRow(table, row); err != nil {
+				return fmt.Errorf("insert %s row %d: %w", table.Name, row[0], err)
+			}
+			summary.Imported++
+		}
+	}
+	return nil
+}
+
+// replaceRow 用导入的行覆盖ID相同的行。正在迁移、等待空间或等待删除源文件的记录，
+// 以及源文件租约由某个实例持有的记录不覆盖，返回 false；覆盖的记录追加一条审计事件
+func (p *Processor) replaceRow(q database.Queries, table database.Table, row []interface{}) (bool, error) {
+	id := row[0].(int64)
+	if table.Name != database.RecordsTable.Name {
+		if err := q.ReplaceRow(table, row); err != nil {
+			return false, fmt.Errorf("replace %s row %d: %w", table.Name, id, err)
+		}
+		return true, nil
+	}
+
+	existing, err := q.Record(id)
+	if err != nil {
+		return false, err
+	}
+	switch existing.Status {
+	case "pending", "copied", "waiting_for_space", "processed":
+		p.logger.Warn("skip imported record, existing record is in progress",
+			zap.Int64("id", id), zap.String("status", existing.Status))
+		return false, nil
+	}
+	held, err := q.LeaseHeld(fileLease(existing.SourcePath))
+	if err != nil {
+		return false, err
+	}
+	if held {
+		p.logger.Warn("skip imported record, existing record is leased by an instance",
+			zap.Int64("id", id), zap.String("path", existing.SourcePath))
+		return false, nil
+	}
+
+	if err := q.ReplaceRow(table, row); err != nil {
+		return false, fmt.Errorf("replace %s row %d: %w", table.Name, id, err)
+	}
+	// 列顺序见 database.RecordsTable
+	source, _ := row[1].(string)
+	status, _ := row[6].(string)
+	err = q.InsertEvent(&model.RecordEvent{
+		RecordID:  id,
+		Path:      source,
+		Event:     EventReplaced,
+		Actor:     p.actor,
+		Details:   fmt.Sprintf("status %s replaced by imported %s", existing.Status, status),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// importConflict 检查导入的行是否与已有数据冲突：exists 为ID已存在，
+// taken 为源路径已有其他进行中的记录
+func importConflict(q database.Queries, table database.Table, row []interface{}) (exists, taken bool, err error) {
+	id := row[0].(int64)
+	if exists, err = q.RowExists(table, id); err != nil {
+		return false, false, err
+	}
+	if table.Name != database.RecordsTable.Name {
+		return exists, false, nil
+	}
+	// 列顺序见 database.RecordsTable
+	if status, _ := row[6].(string); status == "deleted" || status == "promoted" {
+		return exists, false, nil
+	}
+	source, _ := row[1].(string)
+	if taken, err = q.SourceInUse(id, source); err != nil {
+		return false, false, err
+	}
+	return exists, taken, nil
+}
+
+// decodeJSONL 读取每行一个JSON对象的数据，缺少的字段为 NULL，多余的字段忽略
+func decodeJSONL(r io.Reader, columns []database.Column) ([][]interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var rows [][]interface{}
+	for line := 1; ; line++ {
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decode row %d: %w", line, err)
+		}
+		row := make([]interface{}, len(columns))
+		for i, c := range columns {
+			v, err := parseValue(c, obj[c.Name])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", line, err)
+			}
+			row[i] = v
+		}
+		if row[0] == nil {
+			return nil, fmt.Errorf("row %d has no id", line)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// decodeCSV 读取首行为列名的CSV，空字段为 NULL，多余的列忽略
+func decodeCSV(r io.Reader, columns []database.Column) ([][]interface{}, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[name] = i
+	}
+	if _, ok := index["id"]; !ok {
+		return nil, fmt.Errorf("csv header has no id column")
+	}
+
+	var rows [][]interface{}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		row := make([]interface{}, len(columns))
+		for i, c := range columns {
+			var raw interface{}
+			if j, ok := index[c.Name]; ok && record[j] != "" {
+				raw = record[j]
+			}
+			v, err := parseValue(c, raw)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			row[i] = v
+		}
+		if row[0] == nil {
+			return nil, fmt.Errorf("line %d has no id", line)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseValue 将导入的值转换为列的类型。时间转换为本地时区，与程序写入的时间格式一致
+func parseValue(c database.Column, raw interface{}) (interface{}, error) {
+	if raw == nil {
+		if c.Kind == database.CountColumn {
+			return int64(0), nil
+		}
+		return nil, nil
+	}
+	s := fmt.Sprint(raw)
+	switch c.Kind {
+	case database.IntColumn, database.CountColumn:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", c.Name, s)
+		}
+		return n, nil
+	case database.TimeColumn:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", c.Name, s)
+		}
+		return t.Local(), nil
+	default:
+		if _, ok := raw.(string); !ok {
+			return nil, fmt.Errorf("invalid %s %v", c.Name, raw)
+		}
+		return s, nil
+	}
+}
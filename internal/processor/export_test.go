@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessor_ExportImportRecords(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			env := newTestEnv(t)
+			proc := env.newProcessor(t)
+
+			migrated := env.migrate(t, proc, env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode"))
+			due := time.Now().Add(36 * time.Hour).Truncate(time.Microsecond)
+			scheduled := env.insertRecord(t, filepath.Join(env.sourceDir, "movie.mkv"),
+				filepath.Join(env.targetDir, "movie.mkv"), due)
+			if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted' WHERE id = ?", migrated); err != nil {
+				t.Fatal(err)
+			}
+
+			var records, events bytes.Buffer
+			if n, err := proc.ExportRecords(&records, format, ExportFilter{}); err != nil || n != 2 {
+				t.Fatalf("ExportRecords() = %d, %v", n, err)
+			}
+			if n, err := proc.ExportEvents(&events, format, ExportFilter{}); err != nil || n == 0 {
+				t.Fatalf("ExportEvents() = %d, %v", n, err)
+			}
+			var filtered bytes.Buffer
+			if n, err := proc.ExportRecords(&filtered, format, ExportFilter{Statuses: []string{"processed"}, Prefix: env.sourceDir}); err != nil || n != 1 {
+				t.Errorf("filtered ExportRecords() = %d, %v", n, err)
+			}
+
+			// 导入到重建的主机，删除计划和ID保持不变
+			rebuilt := newTestEnv(t)
+			target := rebuilt.newProcessor(t)
+			summary, err := target.ImportRecords(bytes.NewReader(records.Bytes()), format, ConflictFail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Imported != 2 {
+				t.Errorf("imported %d records, want 2", summary.Imported)
+			}
+			if _, err := target.ImportEvents(bytes.NewReader(events.Bytes()), format, ConflictSkip); err != nil {
+				t.Fatal(err)
+			}
+			var got time.Time
+			var status string
+			if err := rebuilt.appDB.QueryRow("SELECT delete_scheduled, status FROM file_records WHERE id = ?", scheduled).
+				Scan(&got, &status); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(due) || status != "processed" {
+				t.Errorf("imported record = %v %s, want %v processed", got, status, due)
+			}
+			history, err := target.Events(EventFilter{RecordID: migrated})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) == 0 || history[0].Event != EventDetected {
+				t.Errorf("imported events = %+v", history)
+			}
+
+			// 新记录的ID不与导入的冲突
+			id := rebuilt.insertRecord(t, "/src/new.mkv", "/dst/new.mkv", time.Now())
+			if id <= scheduled {
+				t.Errorf("new record id %d, want greater than %d", id, scheduled)
+			}
+
+			// 再次导入时按冲突方式处理
+			if _, err := target.ImportRecords(bytes.NewReader(records.Bytes()), format, ConflictFail); err == nil {
+				t.Error("expected conflict error")
+			}
+			summary, err = target.ImportRecords(bytes.NewReader(records.Bytes()), format, ConflictSkip)
+			if err != nil || summary.Skipped != 2 || summary.Imported != 0 {
+				t.Errorf("skip import = %+v, %v", summary, err)
+			}
+			if _, err := rebuilt.appDB.Exec("UPDATE file_records SET status = 'delete_blocked' WHERE id = ?", scheduled); err != nil {
+				t.Fatal(err)
+			}
+			summary, err = target.ImportRecords(bytes.NewReader(records.Bytes()), format, ConflictReplace)
+			if err != nil || summary.Replaced != 2 {
+				t.Errorf("replace import = %+v, %v", summary, err)
+			}
+			if status := rebuilt.recordStatus(t, scheduled); status != "processed" {
+				t.Errorf("replaced record status = %s", status)
+			}
+			history, err = target.Events(EventFilter{RecordID: scheduled})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := history[len(history)-1]; last.Event != EventReplaced {
+				t.Errorf("last event = %s, want %s", last.Event, EventReplaced)
+			}
+
+			// 等待删除源文件或租约被持有的记录不覆盖
+			if _, err := rebuilt.appDB.Exec("UPDATE file_records SET status = 'delete_blocked' WHERE id = ?", migrated); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rebuilt.appDB.Exec("INSERT INTO leases (name, owner, expires_at, heartbeat_at) VALUES (?, 'b', ?, ?)",
+				fileLease(filepath.Join(env.sourceDir, "show/e01.mkv")), time.Now().Add(time.Hour), time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			summary, err = target.ImportRecords(bytes.NewReader(records.Bytes()), format, ConflictReplace)
+			if err != nil || summary.Replaced != 0 || summary.Skipped != 2 {
+				t.Errorf("replace in-progress import = %+v, %v", summary, err)
+			}
+			if status := rebuilt.recordStatus(t, migrated); status != "delete_blocked" {
+				t.Errorf("leased record was replaced, status %s", status)
+			}
+		})
+	}
+}
+
+func TestImportRejectsInvalidInput(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	for name, tt := range map[string]struct{ format, conflict, data string }{
+		"unknown format":   {"xml", ConflictSkip, ""},
+		"unknown conflict": {FormatJSONL, "merge", ""},
+		"missing id":       {FormatJSONL, ConflictSkip, `{"source_path":"/a"}`},
+		"bad time":         {FormatCSV, ConflictSkip, "id,created_at\n1,yesterday\n"},
+	} {
+		if _, err := proc.ImportRecords(strings.NewReader(tt.data), tt.format, tt.conflict); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestImportRollsBackOnError(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	// 第二行缺少源路径，插入失败时第一行也不导入
+	data := `{"id":1,"source_path":"/src/a.mkv","target_path":"/dst/a.mkv","status":"processed","created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}
+{"id":2,"target_path":"/dst/b.mkv","status":"processed","created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}
+`
+	if _, err := proc.ImportRecords(strings.NewReader(data), FormatJSONL, ConflictSkip); err == nil {
+		t.Fatal("expected error")
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM file_records").Scan(&n); err != nil || n != 0 {
+		t.Errorf("records left after failed import = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,176 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 钩子的触发时机
+const (
+	HookBeforeMove      = "before_move"       // 移动（或上传）文件之前，失败时中止迁移
+	HookAfterMove       = "after_move"        // 文件已移动、尚未切换Emby路径
+	HookAfterEmbyUpdate = "after_emby_update" // Emby路径已切换，记录已标记为 processed
+	HookBeforeDelete    = "before_delete"     // 删除源文件之前，失败时阻止删除
+	HookOnFailure       = "on_failure"        // 迁移或删除失败
+)
+
+// defaultHookTimeout 未设置超时的钩子最长运行时间
+const defaultHookTimeout = time.Minute
+
+// hookOutputLimit 失败原因中保留的钩子输出长度
+const hookOutputLimit = 512
+
+// Hook 在迁移的某个时机执行的shell命令。记录通过 EPR_* 环境变量和标准输入的JSON传给命令
+type Hook struct {
+	Event   string
+	Command string // 通过 /bin/sh -c 执行
+	Timeout time.Duration
+}
+
+// WithHooks 配置迁移各个时机执行的钩子，同一时机的多个钩子按顺序执行。
+// before_* 钩子以非零状态退出时中止该步骤，并把输出记录为失败原因
+func WithHooks(hooks []Hook) Option {
+	return func(p *Processor) {
+		p.hooks = hooks
+	}
+}
+
+// hookPayload 通过标准输入传给钩子的JSON
+type hookPayload struct {
+	Event      string `json:"event"`
+	ID         int64  `json:"id,omitempty"`
+	SourcePath string `json:"source_path"`
+	TargetPath string `json:"target_path"`
+	Status     string `json:"status"`
+	Hop        int    `json:"hop"`
+	ParentID   int64  `json:"parent_id,omitempty"`
+	FileSize   int64  `json:"file_size"`
+	Checksum   string `json:"checksum,omitempty"`
+	EmbyItems  int64  `json:"emby_items"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (h hookPayload) env() []string {
+	return []string{
+		"EPR_EVENT=" + h.Event,
+		"EPR_RECORD_ID=" + strconv.FormatInt(h.ID, 10),
+		"EPR_SOURCE_PATH=" + h.SourcePath,
+		"EPR_TARGET_PATH=" + h.TargetPath,
+		"EPR_STATUS=" + h.Status,
+		"EPR_HOP=" + strconv.Itoa(h.Hop),
+		"EPR_FILE_SIZE=" + strconv.FormatInt(h.FileSize, 10),
+		"EPR_CHECKSUM=" + h.Checksum,
+		"EPR_EMBY_ITEMS=" + strconv.FormatInt(h.EmbyItems, 10),
+		"EPR_ERROR=" + h.Error,
+	}
+}
+
+// runHooks 依次执行 event 的钩子，遇到第一个失败的钩子时返回错误。cause 为 on_failure 的失败原因
+func (p *Processor) runHooks(ctx context.Context, event string, record *model.FileRecord, cause error) error {
+	payload := hookPayload{
+		Event:      event,
+		ID:         record.ID,
+		SourcePath: record.SourcePath,
+		TargetPath: record.TargetPath,
+		Status:     record.Status,
+		Hop:        record.Hop,
+		ParentID:   record.ParentID,
+		FileSize:   record.FileSize,
+		Checksum:   record.Checksum,
+		EmbyItems:  record.EmbyItems,
+	}
+	if cause != nil {
+		payload.Error = cause.Error()
+	}
+
+	for _, hook := range p.hooks {
+		if hook.Event != event {
+			continue
+		}
+		if err := runHook(ctx, hook, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook Hook, payload hookPayload) error {
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode hook input: %w", err)
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(), payload.env()...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// 命令启动的后台进程继续占用输出时不等待它们
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	msg := strings.TrimSpace(output.String())
+	if len(msg) > hookOutputLimit {
+		msg = "..." + msg[len(msg)-hookOutputLimit:]
+	}
+	if msg != "" {
+		return fmt.Errorf("%s hook %q: %w: %s", hook.Event, hook.Command, err, msg)
+	}
+	return fmt.Errorf("%s hook %q: %w", hook.Event, hook.Command, err)
+}
+
+// afterHooks 执行 after_* 钩子，失败只记录日志
+func (p *Processor) afterHooks(ctx context.Context, event string, record *model.FileRecord) {
+	if err := p.runHooks(ctx, event, record, nil); err != nil {
+		p.logger.Warn("hook failed", zap.Error(err), zap.String("path", record.SourcePath))
+	}
+}
+
+// failureHooks 执行 on_failure 钩子。迁移已经失败，ctx 取消时仍然执行
+func (p *Processor) failureHooks(record *model.FileRecord, cause error) {
+	if err := p.runHooks(context.Background(), HookOnFailure, record, cause); err != nil {
+		p.logger.Warn("hook failed", zap.Error(err), zap.String("path", record.SourcePath))
+	}
+}
+
+// beforeMove 执行 before_move 钩子。钩子失败时将记录标记为 failed 并保存失败原因，
+// 文件再次出现时重新迁移
+func (p *Processor) beforeMove(ctx context.Context, record *model.FileRecord) error {
+	hookErr := p.runHooks(ctx, HookBeforeMove, record, nil)
+	if hookErr == nil {
+		return nil
+	}
+	record.Status = "failed"
+	record.FailureReason = hookErr.Error()
+	record.UpdatedAt = time.Now()
+	if err := p.saveRecord(record); err != nil {
+		return err
+	}
+	p.logger.Warn("migration aborted by hook",
+		zap.String("path", record.SourcePath),
+		zap.String("reason", record.FailureReason))
+	return hookErr
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessor_Hooks(t *testing.T) {
+	env := newTestEnv(t)
+	logDir := t.TempDir()
+	allow := filepath.Join(logDir, "allow")
+	proc := env.newProcessor(t, WithHooks([]Hook{
+		{Event: HookBeforeMove, Command: `test -e "` + allow + `" || { echo "torrent still seeding"; exit 3; }`},
+		{Event: HookAfterMove, Command: `cat > "` + filepath.Join(logDir, "after_move.json") + `"`},
+		{Event: HookAfterEmbyUpdate, Command: `echo "$EPR_EVENT $EPR_RECORD_ID $EPR_TARGET_PATH" > "` + filepath.Join(logDir, "after_emby_update") + `"`},
+		{Event: HookOnFailure, Command: `echo "$EPR_ERROR" >> "` + filepath.Join(logDir, "on_failure") + `"`},
+	}))
+
+	source := env.writeFile(t, env.sourceDir, "movies/movie.mkv", "movie")
+	record := &model.FileRecord{SourcePath: source, ModifiedTime: time.Now(), Status: "pending"}
+
+	// before_move 失败时中止迁移并记录失败原因
+	err := proc.ProcessFile(context.Background(), record)
+	if err == nil || !strings.Contains(err.Error(), "torrent still seeding") {
+		t.Fatalf("ProcessFile error = %v", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source was moved despite the hook: %v", err)
+	}
+	var status, reason string
+	if err := env.appDB.QueryRow("SELECT status, failure_reason FROM file_records WHERE source_path = ?", source).
+		Scan(&status, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" || !strings.Contains(reason, "torrent still seeding") {
+		t.Errorf("record = %s, %q", status, reason)
+	}
+	if failure, err := os.ReadFile(filepath.Join(logDir, "on_failure")); err != nil || !strings.Contains(string(failure), "torrent still seeding") {
+		t.Errorf("on_failure hook output = %q, %v", failure, err)
+	}
+
+	// 钩子放行后重新迁移，沿用原记录
+	if err := os.WriteFile(allow, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	record = &model.FileRecord{SourcePath: source, ModifiedTime: time.Now(), Status: "pending"}
+	if err := proc.ProcessFile(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM file_records WHERE source_path = ?", source).Scan(&count); err != nil || count != 1 {
+		t.Errorf("records for source = %d, %v", count, err)
+	}
+	if env.recordStatus(t, record.ID) != "processed" {
+		t.Errorf("status = %s, want processed", env.recordStatus(t, record.ID))
+	}
+
+	input, err := os.ReadFile(filepath.Join(logDir, "after_move.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload hookPayload
+	if err := json.Unmarshal(input, &payload); err != nil {
+		t.Fatalf("decode hook input %q: %v", input, err)
+	}
+	target := filepath.Join(env.targetDir, "movies", "movie.mkv")
+	if payload.Event != HookAfterMove || payload.SourcePath != source || payload.TargetPath != target {
+		t.Errorf("unexpected hook input: %+v", payload)
+	}
+	out, err := os.ReadFile(filepath.Join(logDir, "after_emby_update"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := HookAfterEmbyUpdate + " " + strconv.FormatInt(record.ID, 10) + " " + target + "\n"; string(out) != want {
+		t.Errorf("after_emby_update env = %q, want %q", out, want)
+	}
+}
+
+func TestProcessor_BeforeDeleteHook(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithHooks([]Hook{
+		{Event: HookBeforeDelete, Command: `echo "file in use"; exit 1`},
+	}))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	target := env.writeFile(t, env.targetDir, "movie.mkv", "movie")
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source deleted despite the hook: %v", err)
+	}
+	if env.recordStatus(t, id) != "delete_blocked" {
+		t.Errorf("status = %s, want delete_blocked", env.recordStatus(t, id))
+	}
+	var reason string
+	if err := env.appDB.QueryRow("SELECT block_reason FROM file_records WHERE id = ?", id).Scan(&reason); err != nil || !strings.Contains(reason, "file in use") {
+		t.Errorf("block reason = %q, %v", reason, err)
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	start := time.Now()
+	err := runHook(context.Background(), Hook{Event: HookBeforeMove, Command: "sleep 5", Timeout: 100 * time.Millisecond}, hookPayload{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runHook error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("hook ran for %s after its timeout", elapsed)
+	}
+}
@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// leaseOptions 多个实例共享状态库时的租约配置，owner 为空时不使用租约
+type leaseOptions struct {
+	owner string
+	ttl   time.Duration
+}
+
+// WithLeases 多个实例共享状态库时使用租约协调：同一文件只由一个实例迁移，清理同时只在一个实例运行。
+// owner 为本实例的标识，ttl 为租约有效期，不大于0时为1分钟。
+// 持有期间每 ttl/3 续期一次，实例退出后租约到期即由其他实例接管，各实例的时钟应保持同步
+func WithLeases(owner string, ttl time.Duration) Option {
+	return func(p *Processor) {
+		if ttl <= 0 {
+			ttl = time.Minute
+		}
+		p.lease = leaseOptions{owner: owner, ttl: ttl}
+	}
+}
+
+// cleanupLease 清理源文件的租约
+const cleanupLease = "cleanup"
+
+// fileLease 迁移某个源文件的租约
+func fileLease(path string) string {
+	return "file:" + path
+}
+
+// errClaimed 记录正由其他实例或本实例的其他任务处理，或在取得租约前已被处理
+var errClaimed = errors.New("record is being handled by another instance")
+
+// localLeases 本实例内各租约的持有数。数据库中的租约以实例为单位，
+// 同一实例的多个任务需先在这里互斥，持有数降为0时才释放数据库中的租约
+type localLeases struct {
+	mu   sync.Mutex
+	held map[string]int
+}
+
+// tryLock 在没有其他任务持有 name 时取得它
+func (l *localLeases) tryLock(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[name] > 0 {
+		return false
+	}
+	l.held[name] = 1
+	return true
+}
+
+// share 增加 name 的持有数，返回此前是否已由本实例的任务持有
+func (l *localLeases) share(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held[name]++
+	return l.held[name] > 1
+}
+
+// unlock 减少 name 的持有数，返回是否已没有任务持有
+func (l *localLeases) unlock(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held[name]--
+	if l.held[name] > 0 {
+		return false
+	}
+	delete(l.held, name)
+	return true
+}
+
+// acquireLease 获取或续期租约，租约由其他实例持有且尚未过期时返回 false
+func (p *Processor) acquireLease(name string) (bool, error) {
+	return p.appDB.AcquireLease(name, p.lease.owner, p.lease.ttl)
+}
+
+// releaseLease 释放本实例持有的租约
+func (p *Processor) releaseLease(name string) {
+	if err := p.appDB.ReleaseLease(name, p.lease.owner); err != nil {
+		p.logger.Error("release lease", zap.Error(err), zap.String("lease", name))
+	}
+}
+
+// unlockLease 释放本任务对 name 的持有，没有其他任务持有时释放数据库中的租约
+func (p *Processor) unlockLease(name string) {
+	if p.locks.unlock(name) && p.lease.owner != "" {
+		p.releaseLease(name)
+	}
+}
+
+// holdLease 获取租约并在后台续期。租约被其他实例或本实例的其他任务持有时 ok 为 false。
+// 返回的 ctx 在租约丢失时取消，进行中的操作随之中止；release 停止续期并释放租约。
+// 未启用租约时只在本实例内互斥
+func (p *Processor) holdLease(ctx context.Context, name string) (leaseCtx context.Context, release func(), ok bool, err error) {
+	if !p.locks.tryLock(name) {
+		return ctx, nil, false, nil
+	}
+	if p.lease.owner == "" {
+		return ctx, func() { p.unlockLease(name) }, true, nil
+	}
+	if ok, err := p.acquireLease(name); err != nil || !ok {
+		p.locks.unlock(name)
+		return ctx, nil, false, err
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(p.lease.ttl / 3)
+		defer ticker.Stop()
+		expires := time.Now().Add(p.lease.ttl)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ok, err := p.acquireLease(name)
+			if err != nil {
+				// 续期暂时失败时继续重试，直到租约到期
+				p.logger.Error("renew lease", zap.Error(err), zap.String("lease", name))
+				if time.Now().After(expires) {
+					cancel()
+					return
+				}
+				continue
+			}
+			if !ok {
+				p.logger.Warn("lease taken over by another instance", zap.String("lease", name))
+				cancel()
+				return
+			}
+			expires = time.Now().Add(p.lease.ttl)
+		}
+	}()
+
+	release = func() {
+		close(done)
+		<-stopped
+		cancel()
+		p.unlockLease(name)
+	}
+	return leaseCtx, release, true, nil
+}
+
+// claimRecord 取得记录源文件的租约，并确认此时记录仍为 status，避免多个实例重复处理同一条记录。
+// 租约被其他实例持有或记录状态已变化时返回 errClaimed
+func (p *Processor) claimRecord(ctx context.Context, id int64, sourcePath, status string) (leaseCtx context.Context, release func(), err error) {
+	leaseCtx, release, ok, err := p.holdLease(ctx, fileLease(sourcePath))
+	if err != nil {
+		return ctx, nil, err
+	}
+	if !ok {
+		return ctx, nil, errClaimed
+	}
+	record, err := p.appDB.Record(id)
+	if err != nil {
+		release()
+		return ctx, nil, err
+	}
+	if record == nil || record.Status != status {
+		release()
+		return ctx, nil, errClaimed
+	}
+	return leaseCtx, release, nil
+}
+
+// claimCopied 返回本实例取得租约的 copied 记录，其他实例正在切换或已切换的记录跳过。
+// 本实例中持有租约的任务是等待批量切换的 ProcessFile，与它共同持有而不是跳过。
+// 切换只涉及数据库操作，耗时远小于租约有效期，因此不在后台续期；release 释放取得的所有租约
+func (p *Processor) claimCopied() (records []model.FileRecord, release func(), err error) {
+	copied, err := p.appDB.CopiedRecords()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var held []string
+	release = func() {
+		for _, name := range held {
+			p.unlockLease(name)
+		}
+	}
+	for _, r := range copied {
+		name := fileLease(r.SourcePath)
+		if !p.locks.share(name) && p.lease.owner != "" {
+			ok, err := p.acquireLease(name)
+			if err != nil {
+				p.locks.unlock(name)
+				release()
+				return nil, nil, err
+			}
+			if !ok {
+				p.locks.unlock(name)
+				continue
+			}
+		}
+		held = append(held, name)
+		current, err := p.appDB.Record(r.ID)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		if current != nil && current.Status == "copied" {
+			records = append(records, *current)
+		}
+	}
+	return records, release, nil
+}
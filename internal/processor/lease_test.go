@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_FileLease(t *testing.T) {
+	env := newTestEnv(t)
+	a := env.newProcessor(t, WithLeases("a", time.Minute))
+	b := env.newProcessor(t, WithLeases("b", time.Minute))
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	_, release, ok, err := a.holdLease(context.Background(), fileLease(source))
+	if err != nil || !ok {
+		t.Fatalf("holdLease() = %v, %v", ok, err)
+	}
+
+	// 其他实例持有租约时跳过该文件
+	if id := env.migrate(t, b, source); id != 0 {
+		t.Errorf("file migrated while leased by another instance, id %d", id)
+	}
+
+	release()
+	if id := env.migrate(t, b, source); id == 0 {
+		t.Error("file not migrated after lease was released")
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM leases").Scan(&n); err != nil || n != 0 {
+		t.Errorf("leases left = %d, %v", n, err)
+	}
+}
+
+func TestProcessor_FileLeaseSameInstance(t *testing.T) {
+	env := newTestEnv(t)
+	started := filepath.Join(env.tmpDir, "started")
+	proceed := filepath.Join(env.tmpDir, "proceed")
+	// 第一次迁移在移动文件前等待，期间同一实例再次处理该文件
+	proc := env.newProcessor(t, WithLeases("a", time.Minute), WithHooks([]Hook{{
+		Event:   HookBeforeMove,
+		Command: "touch " + started + "; while [ ! -f " + proceed + " ]; do sleep 0.01; done",
+	}}))
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	done := make(chan error, 1)
+	go func() {
+		done <- proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first migration did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// 第二次处理跳过该文件，且不释放第一次持有的租约
+	held, err := proc.appDB.LeaseHeld(fileLease(source))
+	if err != nil || !held {
+		t.Errorf("lease held = %v, %v; want held while the first migration runs", held, err)
+	}
+
+	if err := os.WriteFile(proceed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM file_records WHERE source_path = ?", source).Scan(&n); err != nil || n != 1 {
+		t.Errorf("records for %s = %d, %v; want 1", source, n, err)
+	}
+	if held, err := proc.appDB.LeaseHeld(fileLease(source)); err != nil || held {
+		t.Errorf("lease held = %v, %v after the migration finished", held, err)
+	}
+}
+
+func TestProcessor_LeaseTakeover(t *testing.T) {
+	env := newTestEnv(t)
+	a := env.newProcessor(t, WithLeases("a", 50*time.Millisecond))
+	b := env.newProcessor(t, WithLeases("b", 50*time.Millisecond))
+
+	// a 取得租约后不再续期，相当于实例退出
+	if ok, err := a.acquireLease(cleanupLease); err != nil || !ok {
+		t.Fatalf("acquireLease() = %v, %v", ok, err)
+	}
+	summary, err := b.cleanup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Skipped {
+		t.Error("cleanup ran while another instance held the lease")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	summary, err = b.cleanup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Skipped {
+		t.Error("cleanup skipped after the lease expired")
+	}
+}
+
+func TestProcessor_LeaseLost(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithLeases("a", 30*time.Millisecond))
+
+	ctx, release, ok, err := proc.holdLease(context.Background(), cleanupLease)
+	if err != nil || !ok {
+		t.Fatalf("holdLease() = %v, %v", ok, err)
+	}
+	defer release()
+
+	// 续期使租约保持有效
+	time.Sleep(60 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("lease lost while renewing")
+	}
+
+	// 其他实例接管后进行中的操作被取消
+	if _, err := env.appDB.Exec("UPDATE leases SET owner = 'b', expires_at = ? WHERE name = ?",
+		time.Now().Add(time.Hour), cleanupLease); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("context not cancelled after the lease was taken over")
+	}
+}
@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// deletionBreaker 删除熔断器在 breaker_state 表中的名称
+const deletionBreaker = "deletion"
+
+// DeletionLimits 限制清理时删除源文件的数量和大小，0表示不限制。
+// 每日限制按最近24小时滚动计算
+type DeletionLimits struct {
+	MaxFilesPerRun int64
+	MaxBytesPerRun int64
+	MaxFilesPerDay int64
+	MaxBytesPerDay int64
+}
+
+// breakerOptions 删除熔断器的触发条件
+type breakerOptions struct {
+	missingRatio float64
+	minFiles     int
+}
+
+// WithDeletionLimits 设置每轮和每日的删除上限
+func WithDeletionLimits(limits DeletionLimits) Option {
+	return func(p *Processor) {
+		p.limits = limits
+	}
+}
+
+// WithCircuitBreaker 当到期记录中目标文件缺失的比例达到 missingRatio 且样本数不少于 minFiles 时，
+// 触发熔断并停止所有删除，需要手动复位。目标挂载点异常时始终触发熔断
+func WithCircuitBreaker(missingRatio float64, minFiles int) Option {
+	return func(p *Processor) {
+		p.breaker = breakerOptions{
+			missingRatio: missingRatio,
+			minFiles:     minFiles,
+		}
+	}
+}
+
+// BreakerState 熔断器状态
+type BreakerState struct {
+	Reason    string
+	TrippedAt time.Time
+}
+
+// BreakerStatus 返回删除熔断器的状态，未触发时返回 nil
+func (p *Processor) BreakerStatus() (*BreakerState, error) {
+	state, err := p.appDB.BreakerState(deletionBreaker)
+	return (*BreakerState)(state), err
+}
+
+// TripBreaker 触发删除熔断，已触发时保留最初的原因
+func (p *Processor) TripBreaker(reason string) error {
+	tripped, err := p.appDB.TripBreaker(deletionBreaker, reason, time.Now())
+	if err != nil {
+		return err
+	}
+	p.logger.Error("deletion circuit breaker tripped", zap.String("reason", reason))
+	// 已经处于熔断状态时不重复通知
+	if tripped {
+		p.notifier.Notify(notify.Event{Type: notify.EventBreakerTripped, Reason: reason})
+	}
+	return nil
+}
+
+// ResetBreaker 复位删除熔断器，恢复删除
+func (p *Processor) ResetBreaker() error {
+	if err := p.appDB.ResetBreaker(deletionBreaker); err != nil {
+		return err
+	}
+	p.logger.Info("deletion circuit breaker reset")
+	return nil
+}
+
+// detectAnomaly 在删除前检查整体异常，返回熔断原因，正常时返回空字符串
+func (p *Processor) detectAnomaly(ctx context.Context, due []dueFile) string {
+	if len(due) == 0 {
+		return ""
+	}
+	if err := p.checkTargetMount(); err != nil {
+		return err.Error()
+	}
+	if p.breaker.missingRatio <= 0 {
+		return ""
+	}
+
+	var checked, missing int
+	for _, f := range due {
+		if _, err := os.Stat(f.sourcePath); err != nil {
+			continue
+		}
+		checked++
+		if _, err := p.statTarget(ctx, f.targetPath); errors.Is(err, os.ErrNotExist) {
+			missing++
+		}
+	}
+	if checked == 0 || checked < p.breaker.minFiles {
+		return ""
+	}
+	if float64(missing)/float64(checked) >= p.breaker.missingRatio {
+		return fmt.Sprintf("%d of %d due targets are missing", missing, checked)
+	}
+	return ""
+}
+
+// deletionBudget 跟踪本轮和最近24小时内剩余的删除额度
+type deletionBudget struct {
+	limits   DeletionLimits
+	runFiles int64
+	runBytes int64
+	dayFiles int64
+	dayBytes int64
+}
+
+func (p *Processor) newDeletionBudget() (*deletionBudget, error) {
+	b := &deletionBudget{limits: p.limits}
+	if p.limits.MaxFilesPerDay <= 0 && p.limits.MaxBytesPerDay <= 0 {
+		return b, nil
+	}
+
+	var err error
+	if b.dayFiles, b.dayBytes, err = p.appDB.DeletionsSince(time.Now().Add(-24 * time.Hour)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// allow 判断删除 size 字节的文件是否超出额度。
+// 每轮的第一个文件不受每轮大小限制，否则大于该限制的文件永远无法删除
+func (b *deletionBudget) allow(size int64) bool {
+	l := b.limits
+	if l.MaxFilesPerRun > 0 && b.runFiles+1 > l.MaxFilesPerRun {
+		return false
+	}
+	if l.MaxBytesPerRun > 0 && b.runFiles > 0 && b.runBytes+size > l.MaxBytesPerRun {
+		return false
+	}
+	if l.MaxFilesPerDay > 0 && b.dayFiles+1 > l.MaxFilesPerDay {
+		return false
+	}
+	if l.MaxBytesPerDay > 0 && b.dayBytes+size > l.MaxBytesPerDay {
+		return false
+	}
+	return true
+}
+
+// oversized 判断 size 字节的文件是否大于每日大小限制，这样的文件需要手动处理
+func (b *deletionBudget) oversized(size int64) bool {
+	return b.limits.MaxBytesPerDay > 0 && size > b.limits.MaxBytesPerDay
+}
+
+func (b *deletionBudget) consume(size int64) {
+	b.runFiles++
+	b.runBytes += size
+	b.dayFiles++
+	b.dayBytes += size
+}
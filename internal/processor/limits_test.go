@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addDueFiles 创建 n 个已到期的源文件、目标文件和记录
+func addDueFiles(t *testing.T, env *testEnv, n int, content string) []int64 {
+	t.Helper()
+	var ids []int64
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file%d.mkv", i)
+		source := env.writeFile(t, env.sourceDir, name, content)
+		target := env.writeFile(t, env.targetDir, name, content)
+		ids = append(ids, env.insertRecord(t, source, target, time.Now().Add(-time.Duration(n-i)*time.Minute)))
+	}
+	return ids
+}
+
+func TestProcessor_DeletionLimits(t *testing.T) {
+	t.Run("files per run", func(t *testing.T) {
+		env := newTestEnv(t)
+		proc := env.newProcessor(t, WithDeletionLimits(DeletionLimits{MaxFilesPerRun: 2}))
+		addDueFiles(t, env, 5, "content")
+
+		summary, err := proc.cleanup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Deleted != 2 || summary.Deferred != 3 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+
+		summary, err = proc.cleanup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Due != 3 || summary.Deleted != 2 {
+			t.Errorf("deferred files were not picked up by the next run: %+v", summary)
+		}
+		// 推迟的文件不校验，每个删除的文件只校验一次
+		var verified int
+		if err := env.appDB.QueryRow("SELECT COUNT(*) FROM record_events WHERE event = ?", EventVerified).Scan(&verified); err != nil || verified != 4 {
+			t.Errorf("verified events = %d, %v; want 4", verified, err)
+		}
+	})
+
+	t.Run("bytes per run", func(t *testing.T) {
+		env := newTestEnv(t)
+		proc := env.newProcessor(t, WithDeletionLimits(DeletionLimits{MaxBytesPerRun: 25}))
+		addDueFiles(t, env, 4, "0123456789")
+
+		summary, err := proc.cleanup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Deleted != 2 || summary.Bytes != 20 || summary.Deferred != 2 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+	})
+
+	t.Run("file larger than the limits", func(t *testing.T) {
+		env := newTestEnv(t)
+		proc := env.newProcessor(t, WithDeletionLimits(DeletionLimits{MaxBytesPerRun: 5, MaxBytesPerDay: 15}))
+		ids := addDueFiles(t, env, 2, "0123456789")
+		large := env.insertRecord(t, env.writeFile(t, env.sourceDir, "large.mkv", "0123456789012345"),
+			env.writeFile(t, env.targetDir, "large.mkv", "0123456789012345"), time.Now())
+
+		// 每轮第一个文件即使超过每轮限制也删除，超过每日限制的文件阻止删除
+		summary, err := proc.cleanup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Deleted != 1 || summary.Deferred != 1 || summary.Blocked != 1 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+		if status := env.recordStatus(t, ids[0]); status != "deleted" {
+			t.Errorf("first file status = %s, want deleted", status)
+		}
+		if status := env.recordStatus(t, large); status != "delete_blocked" {
+			t.Errorf("large file status = %s, want delete_blocked", status)
+		}
+	})
+
+	t.Run("files per day", func(t *testing.T) {
+		env := newTestEnv(t)
+		proc := env.newProcessor(t, WithDeletionLimits(DeletionLimits{MaxFilesPerDay: 3}))
+		addDueFiles(t, env, 2, "content")
+		if _, err := proc.cleanup(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// 新到期的文件只剩一个名额
+		for i := 0; i < 2; i++ {
+			name := fmt.Sprintf("later%d.mkv", i)
+			env.insertRecord(t, env.writeFile(t, env.sourceDir, name, "c"), env.writeFile(t, env.targetDir, name, "c"),
+				time.Now().Add(-time.Minute))
+		}
+		summary, err := proc.cleanup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Deleted != 1 || summary.Deferred != 1 {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+	})
+}
+
+func TestProcessor_CircuitBreaker(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithCircuitBreaker(0.5, 4))
+	ids := addDueFiles(t, env, 4, "content")
+
+	// 一半的目标文件缺失，触发熔断
+	for i := 0; i < 2; i++ {
+		if err := os.Remove(filepath.Join(env.targetDir, fmt.Sprintf("file%d.mkv", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summary, err := proc.cleanup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.BreakerTripped || summary.Deleted != 0 || summary.Blocked != 0 {
+		t.Fatalf("expected breaker to trip without touching records: %+v", summary)
+	}
+	state, err := proc.BreakerStatus()
+	if err != nil || state == nil || state.Reason == "" {
+		t.Fatalf("BreakerStatus() = %+v, %v", state, err)
+	}
+
+	// 熔断期间即使异常消失也不删除
+	for i := 0; i < 2; i++ {
+		env.writeFile(t, env.targetDir, fmt.Sprintf("file%d.mkv", i), "content")
+	}
+	if summary, err = proc.cleanup(context.Background()); err != nil || !summary.BreakerTripped {
+		t.Fatalf("expected cleanup to stay halted: %+v, %v", summary, err)
+	}
+	for _, id := range ids {
+		if status := env.recordStatus(t, id); status != "processed" {
+			t.Errorf("record %d status = %s, want processed", id, status)
+		}
+	}
+
+	// 手动复位后恢复删除
+	if err := proc.ResetBreaker(); err != nil {
+		t.Fatal(err)
+	}
+	if summary, err = proc.cleanup(context.Background()); err != nil || summary.Deleted != 4 {
+		t.Fatalf("expected all files deleted after reset: %+v, %v", summary, err)
+	}
+}
+
+func TestProcessor_CircuitBreakerMount(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithVerification(false, ".mounted"))
+	ids := addDueFiles(t, env, 1, "content")
+
+	summary, err := proc.cleanup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.BreakerTripped {
+		t.Fatalf("expected missing mount marker to trip the breaker: %+v", summary)
+	}
+	if status := env.recordStatus(t, ids[0]); status != "processed" {
+		t.Errorf("record status = %s, want processed", status)
+	}
+
+	env.writeFile(t, env.targetDir, ".mounted", "")
+	if err := proc.ResetBreaker(); err != nil {
+		t.Fatal(err)
+	}
+	if summary, err = proc.cleanup(context.Background()); err != nil || summary.Deleted != 1 {
+		t.Fatalf("expected file deleted after reset: %+v, %v", summary, err)
+	}
+}
@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partialSuffix 复制过程中临时文件的后缀，复制完成后再重命名为目标文件
+const partialSuffix = ".partial"
+
+// moveFile 将 src 移动到 dst 并应用配置的属主和权限。copy 为 true 时（跨文件系统）复制文件并保留 src
+func (p *Processor) moveFile(ctx context.Context, src, dst string, copy bool) error {
+	if err := p.transferFile(ctx, src, dst, copy); err != nil {
+		return err
+	}
+	if err := p.applyOwnership(dst, dst, false); err != nil {
+		if rbErr := undoMove(src, dst, copy); rbErr != nil {
+			p.logger.Error("roll back file move", zap.Error(rbErr), zap.String("path", dst))
+		}
+		return err
+	}
+	return nil
+}
+
+// transferFile 将 src 重命名或复制为 dst，复制时保留源文件的属主、权限、时间戳和扩展属性。
+// ctx 取消时中止复制并删除临时文件
+func (p *Processor) transferFile(ctx context.Context, src, dst string, copy bool) error {
+	if !copy {
+		return os.Rename(src, dst)
+	}
+
+	tmp := dst + partialSuffix
+	var info os.FileInfo
+	var err error
+	if srcInfo, statErr := os.Stat(src); statErr == nil && p.resumable(srcInfo.Size()) {
+		// 大文件分块复制，中断后保留临时文件以便续传
+		info, err = p.resumableCopy(ctx, src, tmp)
+	} else {
+		info, err = copyToTemp(ctx, src, tmp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.copyAttrs(src, info, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// undoMove 撤销 moveFile
+func undoMove(src, dst string, copied bool) error {
+	if copied {
+		return os.Remove(dst)
+	}
+	return os.Rename(dst, src)
+}
+
+// copyToTemp 将 src 复制到临时文件 tmp 并同步到磁盘，返回源文件信息。失败时删除临时文件
+func copyToTemp(ctx context.Context, src, tmp string) (info os.FileInfo, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	if info, err = in.Stat(); err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	n, err := io.Copy(out, &contextReader{ctx: ctx, r: in})
+	if err != nil {
+		return nil, err
+	}
+	if n != info.Size() {
+		return nil, fmt.Errorf("copied %d bytes, expected %d", n, info.Size())
+	}
+	if err = out.Sync(); err != nil {
+		return nil, err
+	}
+	if err = out.Close(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// contextReader 在 ctx 取消后停止读取
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// makeTargetDirs 创建目标目录及缺失的上级目录，新建目录沿用上一级对应目录的属主和权限
+func (p *Processor) makeTargetDirs(dir string) error {
+	roots := p.tierRoots()
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, d)
+		if parent := filepath.Dir(d); parent == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := os.Mkdir(d, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		for j := len(roots) - 1; j >= 1; j-- {
+			if rel, ok := relWithin(roots[j], d); ok {
+				if rel == "." {
+					break
+				}
+				if info, err := os.Stat(filepath.Join(roots[j-1], rel)); err == nil && info.IsDir() {
+					p.copyDirAttrs(info, d)
+				}
+				break
+			}
+		}
+		if err := p.applyOwnership(d, dir, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relWithin 返回 path 相对 root 的路径，path 不在 root 之下时返回 false
+func relWithin(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
+)
+
+// WithNotifier 将迁移完成、失败、删除被阻止、熔断和空间不足等事件发送到通知渠道
+func WithNotifier(n *notify.Notifier) Option {
+	return func(p *Processor) {
+		p.notifier = n
+	}
+}
+
+// notifyRecord 发送与记录有关的事件
+func (p *Processor) notifyRecord(event string, record *model.FileRecord, reason string) {
+	p.notifier.Notify(notify.Event{
+		Type:     event,
+		RecordID: record.ID,
+		Path:     record.SourcePath,
+		Target:   record.TargetPath,
+		Size:     record.FileSize,
+		Reason:   reason,
+	})
+}
+
+// migrationFailed 迁移失败时执行 on_failure 钩子并发送通知
+func (p *Processor) migrationFailed(record *model.FileRecord, err error) {
+	p.failureHooks(record, err)
+	p.recordEvent(record.ID, record.SourcePath, EventFailed, "migration", err)
+	p.notifyRecord(notify.EventMigrationFailed, record, err.Error())
+}
+
+// deleteFailed 删除源文件失败时执行 on_failure 钩子并发送通知
+func (p *Processor) deleteFailed(f dueFile, err error) {
+	p.failureHooks(f.record(), err)
+	p.recordEvent(f.id, f.sourcePath, EventFailed, "delete source", err)
+	p.notifyRecord(notify.EventDeleteFailed, f.record(), err.Error())
+}
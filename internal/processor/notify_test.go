@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
+	"go.uber.org/zap"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventSink 记录收到的事件
+type eventSink struct {
+	mu     sync.Mutex
+	events []notify.Event
+}
+
+func (s *eventSink) Name() string { return "test" }
+
+func (s *eventSink) Send(ctx context.Context, msg notify.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, msg.Event)
+	return nil
+}
+
+func TestProcessor_Notifications(t *testing.T) {
+	env := newTestEnv(t)
+	sink := &eventSink{}
+	notifier := notify.New(zap.NewNop(), notify.Route{Sink: sink})
+	proc := env.newProcessor(t, WithNotifier(notifier))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	env.migrate(t, proc, source)
+
+	// 目标文件缺失时阻止删除
+	blockedSource := env.writeFile(t, env.sourceDir, "gone.mkv", "gone")
+	env.insertRecord(t, blockedSource, filepath.Join(env.targetDir, "gone.mkv"), time.Now().Add(-time.Hour))
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重复触发熔断只通知一次
+	for i := 0; i < 2; i++ {
+		if err := proc.TripBreaker("manual"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notifier.Close(time.Minute)
+
+	var got []string
+	for _, e := range sink.events {
+		got = append(got, e.Type)
+	}
+	want := []string{notify.EventMigrationDone, notify.EventDeleteBlocked, notify.EventBreakerTripped}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("events = %v, want %v", got, want)
+			break
+		}
+	}
+	if sink.events[0].Path != source {
+		t.Errorf("migration event path = %s, want %s", sink.events[0].Path, source)
+	}
+}
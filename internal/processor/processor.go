@@ -1,10 +1,14 @@
 package processor
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/database"
 	"github.com/sleepstars/embypathrefresh/internal/model"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
 	"go.uber.org/zap"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,146 +16,517 @@ import (
 
 type Processor struct {
 	embyDB     *sql.DB
-	appDB      *sql.DB
+	appDB      database.Store
 	sourceDir  string
 	targetDir  string
 	logger     *zap.Logger
 	deleteTime time.Duration
+	trash      *trash
+	verify     verifyOptions
+	limits     DeletionLimits
+	breaker    breakerOptions
+	space      *spaceTracker
+	prune      pruneOptions
+	owner      Ownership
+	resume     resumeOptions
+	policies   []TieringPolicy
+	hooks      []Hook
+	notifier   *notify.Notifier
+	promotion  PromotionPolicy
+	tiers      []Tier
+	batch      *embyBatch
+	refresh    refreshOptions
+	lease      leaseOptions
+	locks      *localLeases
+	actor      string
+	// 以下函数便于测试替换
+	sameDevice func(a, b string) bool
 }
 
-func New(embyDBPath, appDBPath, sourceDir, targetDir string, deleteTime time.Duration, logger *zap.Logger) (*Processor, error) {
+// Option 用于配置处理器的可选功能
+type Option func(*Processor)
+
+// New 创建处理器，appDB 为已完成结构变更的状态库，由处理器负责关闭
+func New(embyDBPath string, appDB database.Store, sourceDir, targetDir string, deleteTime time.Duration, logger *zap.Logger, opts ...Option) (*Processor, error) {
 	embyDB, err := sql.Open("sqlite3", embyDBPath+"?mode=ro")
 	if err != nil {
 		return nil, fmt.Errorf("open emby database: %w", err)
 	}
 
-	appDB, err := sql.Open("sqlite3", appDBPath+"?_journal_mode=WAL&_busy_timeout=5000")
-	if err != nil {
-		return nil, fmt.Errorf("open app database: %w", err)
-	}
-
-	return &Processor{
+	p := &Processor{
 		embyDB:     embyDB,
 		appDB:      appDB,
 		sourceDir:  sourceDir,
 		targetDir:  targetDir,
 		logger:     logger,
 		deleteTime: deleteTime,
-	}, nil
+		space:      newSpaceTracker(diskSpace),
+		owner:      Ownership{UID: -1, GID: -1},
+		batch:      &embyBatch{size: 1},
+		locks:      &localLeases{held: make(map[string]int)},
+		actor:      ActorDaemon,
+		sameDevice: sameDevice,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
 }
 
-func (p *Processor) ProcessFile(record *model.FileRecord) error {
+// ProcessFile 迁移文件。ctx 取消时中止尚未完成的复制并回滚已做的修改
+func (p *Processor) ProcessFile(ctx context.Context, record *model.FileRecord) error {
+	// 多个实例或本实例的多个任务同时发现同一文件时只由取得租约的一方迁移
+	ctx, release, ok, err := p.holdLease(ctx, fileLease(record.SourcePath))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		p.logger.Debug("file is being processed elsewhere", zap.String("path", record.SourcePath))
+		return nil
+	}
+	defer release()
+
 	// 检查文件是否已经处理过
-	var exists bool
-	err := p.appDB.QueryRow("SELECT EXISTS(SELECT 1 FROM file_records WHERE source_path = ? AND status != 'deleted')", 
-		record.SourcePath).Scan(&exists)
+	exists, err := p.hasActiveRecord(record.SourcePath)
 	if err != nil {
-		return fmt.Errorf("check file existence: %w", err)
+		return err
 	}
 	if exists {
 		return nil
 	}
 
-	// 计算目标路径
-	relPath, err := filepath.Rel(p.sourceDir, record.SourcePath)
+	// 计算目标路径，已迁移过的文件继续迁移到下一级
+	hop, from, to := p.route(record.SourcePath)
+	relPath, err := filepath.Rel(from, record.SourcePath)
 	if err != nil {
 		return fmt.Errorf("get relative path: %w", err)
 	}
-	record.TargetPath = filepath.Join(p.targetDir, relPath)
+	record.TargetPath = filepath.Join(to, relPath)
+	record.Hop = hop
+	if hop > 0 {
+		if record.ParentID, err = p.appDB.ParentRecordID(record.SourcePath); err != nil {
+			return err
+		}
+	}
 
-	// 在Emby数据库中更新路径
-	tx, err := p.embyDB.Begin()
+	info, err := os.Stat(record.SourcePath)
 	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+		return fmt.Errorf("stat source file: %w", err)
 	}
-	defer tx.Rollback()
+	record.FileSize = info.Size()
 
-	// 更新MediaItems表中的路径
-	_, err = tx.Exec("UPDATE MediaItems SET Path = ? WHERE Path = ?", 
-		record.TargetPath, record.SourcePath)
-	if err != nil {
-		return fmt.Errorf("update media items: %w", err)
+	// 被钩子中止过的文件沿用原记录
+	if record.ID, err = p.appDB.FailedRecordID(record.SourcePath); err != nil {
+		return err
 	}
+	p.recordEvent(record.ID, record.SourcePath, EventDetected, "target "+record.TargetPath, nil)
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit transaction: %w", err)
+	if err := p.migrate(ctx, record); err != nil {
+		p.migrationFailed(record, err)
+		return err
+	}
+	return nil
+}
+
+// migrate 移动文件、更新Emby路径并保存记录。
+// 跨文件系统复制前检查目标剩余空间，空间不足时记录为 waiting_for_space 等待重试
+func (p *Processor) migrate(ctx context.Context, record *model.FileRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 写入存储后端的文件不占用本地空间，也不参与批量切换
+	if backend, key := p.backendFor(record.TargetPath); backend != nil {
+		return p.upload(ctx, record, backend, key)
+	}
+
+	targetRoot := p.rootOf(record.TargetPath)
+	needCopy := !p.sameDevice(record.SourcePath, targetRoot)
+	if needCopy {
+		needed := p.spaceNeeded(record)
+		ok, err := p.reserveSpace(targetRoot, needed)
+		if err != nil {
+			return fmt.Errorf("check target free space: %w", err)
+		}
+		if !ok {
+			return p.waitForSpace(record)
+		}
+		defer p.releaseSpace(targetRoot, needed)
 	}
 
 	// 确保目标目录存在
-	if err := os.MkdirAll(filepath.Dir(record.TargetPath), 0755); err != nil {
+	if err := p.makeTargetDirs(filepath.Dir(record.TargetPath)); err != nil {
 		return fmt.Errorf("create target directory: %w", err)
 	}
 
-	// 移动文件
-	if err := os.Rename(record.SourcePath, record.TargetPath); err != nil {
+	if err := p.beforeMove(ctx, record); err != nil {
+		return err
+	}
+	// 移动文件，跨文件系统时复制并保留源文件，由 CleanupFiles 按计划删除
+	if err := p.moveFile(ctx, record.SourcePath, record.TargetPath, needCopy); err != nil {
 		return fmt.Errorf("move file: %w", err)
 	}
+	if needCopy {
+		p.recordEvent(record.ID, record.SourcePath, EventCopied, "copied to "+record.TargetPath, nil)
+	} else {
+		p.recordEvent(record.ID, record.SourcePath, EventCopied, "renamed to "+record.TargetPath, nil)
+	}
+	p.afterHooks(ctx, HookAfterMove, record)
+
+	// 批量模式下先记录为 copied，由 FlushEmby 合并切换Emby路径。生成 .strm 的层级不参与批量切换
+	if strm, _ := p.strmFor(record.TargetPath); p.batch.size > 1 && strm == nil {
+		return p.queueEmbySwitch(record)
+	}
+
+	// 在Emby数据库中更新路径，失败或 ctx 取消时撤销文件移动
+	itemIDs, err := p.updateEmbyPath(ctx, record)
+	if err != nil {
+		if rbErr := undoMove(record.SourcePath, record.TargetPath, needCopy); rbErr != nil {
+			p.logger.Error("roll back file move", zap.Error(rbErr), zap.String("path", record.TargetPath))
+		}
+		return err
+	}
+
+	if err := p.recordTarget(record); err != nil {
+		return err
+	}
+	if err := p.markProcessed(record, itemIDs); err != nil {
+		return err
+	}
+	p.afterHooks(ctx, HookAfterEmbyUpdate, record)
+	p.refreshEmby(ctx, []refreshRequest{{recordID: record.ID, itemIDs: itemIDs}})
+
+	// 源文件已移走，清理变空的源目录
+	if !needCopy {
+		p.pruneEmptyDirs(filepath.Dir(record.SourcePath))
+	}
+	return nil
+}
+
+// recordTarget 记录目标文件大小和校验值，删除源文件前用于校验目标文件
+func (p *Processor) recordTarget(record *model.FileRecord) error {
+	info, err := os.Stat(record.TargetPath)
+	if err != nil {
+		return fmt.Errorf("stat target file: %w", err)
+	}
+	record.FileSize = info.Size()
+	if p.verify.checksum {
+		if record.Checksum, err = fileChecksum(record.TargetPath); err != nil {
+			return fmt.Errorf("checksum target file: %w", err)
+		}
+	}
+	return nil
+}
 
-	// 记录处理状态
+// markProcessed 将已切换Emby路径的记录标记为 processed 并安排删除源文件
+func (p *Processor) markProcessed(record *model.FileRecord, itemIDs []int64) error {
 	now := time.Now()
 	record.ProcessedTime = now
-	if p.deleteTime > 0 {
-		deleteTime := now.Add(p.deleteTime)
+	hop, _, _ := p.route(record.SourcePath)
+	if delay := p.deleteAfter(hop); delay > 0 {
+		deleteTime := now.Add(delay)
 		record.DeleteScheduled = deleteTime
 	}
 	record.Status = "processed"
-	record.CreatedAt = now
 	record.UpdatedAt = now
 
-	// 保存记录
-	_, err = p.appDB.Exec(`
-		INSERT INTO file_records (
-			source_path, target_path, modified_time, processed_time, 
-			delete_scheduled, status, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		record.SourcePath, record.TargetPath, record.ModifiedTime,
-		record.ProcessedTime, record.DeleteScheduled, record.Status,
-		record.CreatedAt, record.UpdatedAt)
-	if err != nil {
-		return fmt.Errorf("insert record: %w", err)
+	if err := p.saveRecord(record); err != nil {
+		return err
 	}
-
+	p.recordEvent(record.ID, record.SourcePath, EventEmbyUpdated,
+		fmt.Sprintf("%d items point to %s", len(itemIDs), p.embyPath(record.TargetPath)), nil)
+	if !record.DeleteScheduled.IsZero() {
+		p.recordEvent(record.ID, record.SourcePath, EventDeletionScheduled,
+			"at "+record.DeleteScheduled.Format(time.DateTime), nil)
+	}
+	// 记录修改过的Emby条目，撤销迁移时逐条恢复
+	if err := p.appDB.InsertEmbyChanges(record.ID, itemIDs, record.SourcePath, p.embyPath(record.TargetPath), now); err != nil {
+		p.logger.Error("save emby changes", zap.Error(err), zap.Int64("id", record.ID))
+	}
+	p.notifyRecord(notify.EventMigrationDone, record, "")
 	return nil
 }
 
-func (p *Processor) CleanupFiles() error {
-	rows, err := p.appDB.Query(`
-		SELECT id, source_path FROM file_records 
-		WHERE status = 'processed' 
-		AND delete_scheduled IS NOT NULL 
-		AND delete_scheduled <= ?`,
-		time.Now())
+// updateEmbyPath 将Emby中指向源路径的条目改为目标路径，返回被修改的条目ID。
+// 目标层级使用 .strm 时先生成 .strm 文件，条目改为指向它，失败时删除
+func (p *Processor) updateEmbyPath(ctx context.Context, record *model.FileRecord) (itemIDs []int64, err error) {
+	if err := p.writeStrm(record.TargetPath); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			p.removeStrm(record.TargetPath)
+		}
+	}()
+
+	tx, err := p.embyDB.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("query files to delete: %w", err)
+		return nil, fmt.Errorf("begin transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
+	itemIDs, err = switchEmbyPath(tx, record.SourcePath, p.embyPath(record.TargetPath))
+	if err != nil {
+		return nil, err
+	}
+	record.EmbyItems = int64(len(itemIDs))
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return itemIDs, nil
+}
+
+// switchEmbyPath 在事务 tx 中把指向 from 的条目改为指向 to，返回被修改的条目ID。
+// 只修改路径，条目的元数据和用户数据（播放记录等）保持不变
+func switchEmbyPath(tx *sql.Tx, from, to string) ([]int64, error) {
+	rows, err := tx.Query("SELECT Id FROM MediaItems WHERE Path = ?", from)
+	if err != nil {
+		return nil, fmt.Errorf("query media items: %w", err)
+	}
+	var itemIDs []int64
 	for rows.Next() {
 		var id int64
-		var sourcePath string
-		if err := rows.Scan(&id, &sourcePath); err != nil {
-			p.logger.Error("scan row", zap.Error(err))
-			continue
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan media item: %w", err)
+		}
+		itemIDs = append(itemIDs, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("iterate media items: %w", err)
+	}
+
+	// 更新MediaItems表中的路径
+	if _, err := tx.Exec("UPDATE MediaItems SET Path = ? WHERE Path = ?", to, from); err != nil {
+		return nil, fmt.Errorf("update media items: %w", err)
+	}
+	return itemIDs, nil
+}
+
+// saveRecord 保存记录，新记录插入后回填ID，并在同一事务中关联保存前以路径记录的事件
+func (p *Processor) saveRecord(record *model.FileRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = record.UpdatedAt
+	}
+
+	if record.ID != 0 {
+		return p.appDB.UpdateRecord(record)
+	}
+	return p.appDB.InTx(func(q database.Queries) error {
+		if err := q.InsertRecord(record); err != nil {
+			return err
+		}
+		return q.LinkEvents(record.ID, record.SourcePath)
+	})
+}
+
+// hasActiveRecord 判断 path 是否已有进行中或已完成的迁移，提升回热存储的文件在冷却期内不再迁移，避免来回搬动
+func (p *Processor) hasActiveRecord(path string) (bool, error) {
+	return p.appDB.ActiveRecordExists(path, time.Now().Add(-p.promotion.Cooldown))
+}
+
+// CleanupFiles 删除到期的源文件。ctx 取消时处理完当前文件后停止，剩余文件留到下一轮
+func (p *Processor) CleanupFiles(ctx context.Context) error {
+	summary, err := p.cleanup(ctx)
+	if err != nil {
+		return err
+	}
+	if summary.BreakerTripped || summary.Skipped {
+		return nil
+	}
+	p.logger.Info("cleanup finished",
+		zap.Int("due", summary.Due),
+		zap.Int("deleted", summary.Deleted),
+		zap.Int("trashed", summary.Trashed),
+		zap.Int("blocked", summary.Blocked),
+		zap.Int("missing", summary.Missing),
+		zap.Int("deferred", summary.Deferred),
+		zap.Int("failed", summary.Failed),
+		zap.Int64("bytes", summary.Bytes))
+	return nil
+}
+
+// CleanupSummary 汇总一次清理的删除结果
+type CleanupSummary struct {
+	Due      int   // 到期待删除的记录数
+	Deleted  int   // 直接删除的源文件数
+	Trashed  int   // 移入回收站的源文件数
+	Blocked  int   // 未通过删除前校验的记录数
+	Missing  int   // 源文件已不存在的记录数
+	Deferred int   // 超出删除额度、推迟到下一轮的记录数
+	Failed   int   // 删除失败的记录数
+	Bytes    int64 // 释放（或移入回收站）的字节数
+
+	BreakerTripped bool // 熔断器已触发，本轮未删除任何文件
+	Skipped        bool // 其他实例正在清理，本轮跳过
+}
+
+func (p *Processor) cleanup(ctx context.Context) (*CleanupSummary, error) {
+	summary := &CleanupSummary{}
+
+	ctx, release, ok, err := p.holdLease(ctx, cleanupLease)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		p.logger.Info("cleanup is running on another instance, skipping")
+		summary.Skipped = true
+		return summary, nil
+	}
+	defer release()
+
+	// 熔断器触发后停止所有删除（包括清理回收站），直到手动复位
+	breaker, err := p.BreakerStatus()
+	if err != nil {
+		return nil, err
+	}
+	if breaker != nil {
+		p.logger.Warn("deletion circuit breaker is tripped, skipping cleanup",
+			zap.String("reason", breaker.Reason),
+			zap.Time("tripped_at", breaker.TrippedAt))
+		summary.BreakerTripped = true
+		return summary, nil
+	}
+
+	records, err := p.appDB.DueRecords(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("query files to delete: %w", err)
+	}
+	due := make([]dueFile, len(records))
+	for i, r := range records {
+		due[i] = dueFile{
+			id:         r.ID,
+			sourcePath: r.SourcePath,
+			targetPath: r.TargetPath,
+			size:       r.FileSize,
+			checksum:   r.Checksum,
+			embyItems:  r.EmbyItems,
 		}
+	}
+	summary.Due = len(due)
 
-		if err := os.Remove(sourcePath); err != nil {
-			if !os.IsNotExist(err) {
-				p.logger.Error("remove file", zap.Error(err), zap.String("path", sourcePath))
+	// 挂载点异常或大量目标文件缺失时触发熔断，避免映射错误导致批量误删
+	if reason := p.detectAnomaly(ctx, due); reason != "" {
+		if err := p.TripBreaker(reason); err != nil {
+			return summary, err
+		}
+		summary.BreakerTripped = true
+		return summary, nil
+	}
+
+	budget, err := p.newDeletionBudget()
+	if err != nil {
+		return summary, err
+	}
+
+	for i, f := range due {
+		if ctx.Err() != nil {
+			summary.Deferred += len(due) - i
+			p.logger.Info("cleanup interrupted", zap.Int("remaining", len(due)-i))
+			break
+		}
+
+		info, err := os.Stat(f.sourcePath)
+		var deletedAt time.Time
+		deletedBytes := int64(0)
+		details := "source already gone"
+		switch {
+		case os.IsNotExist(err):
+			// 源文件已不存在（例如同一文件系统内直接重命名），只需更新状态
+			summary.Missing++
+		case err != nil:
+			p.logger.Error("stat source file", zap.Error(err), zap.String("path", f.sourcePath))
+			summary.Failed++
+			continue
+		default:
+			if budget.oversized(info.Size()) {
+				p.blockDelete(f, fmt.Sprintf("file size %d exceeds the daily deletion size limit", info.Size()))
+				summary.Blocked++
+				continue
+			}
+			// 先检查额度，超出额度推迟的文件不校验，下一轮再校验
+			if !budget.allow(info.Size()) {
+				summary.Deferred++
+				continue
+			}
+			if reason := p.verifyBeforeDelete(ctx, f); reason != "" {
+				p.blockDelete(f, reason)
+				summary.Blocked++
+				continue
+			}
+			p.recordEvent(f.id, f.sourcePath, EventVerified, "target "+f.targetPath, nil)
+			if err := p.runHooks(ctx, HookBeforeDelete, f.record(), nil); err != nil {
+				p.blockDelete(f, err.Error())
+				p.failureHooks(f.record(), err)
+				summary.Blocked++
 				continue
 			}
+
+			if _, inSource := relWithin(p.sourceDir, f.sourcePath); p.trash != nil && inSource {
+				// 移入回收站，保留恢复的机会
+				if err := p.moveToTrash(f.id, f.sourcePath); err != nil {
+					p.logger.Error("move file to trash", zap.Error(err), zap.String("path", f.sourcePath))
+					p.deleteFailed(f, err)
+					summary.Failed++
+					continue
+				}
+				summary.Trashed++
+				details = "moved to trash"
+			} else {
+				if err := os.Remove(f.sourcePath); err != nil && !os.IsNotExist(err) {
+					p.logger.Error("remove file", zap.Error(err), zap.String("path", f.sourcePath))
+					p.deleteFailed(f, err)
+					summary.Failed++
+					continue
+				}
+				summary.Deleted++
+				details = "removed"
+			}
+			deletedAt = time.Now()
+			deletedBytes = info.Size()
+			budget.consume(deletedBytes)
+			summary.Bytes += deletedBytes
 		}
 
-		_, err = p.appDB.Exec("UPDATE file_records SET status = 'deleted', updated_at = ? WHERE id = ?",
-			time.Now(), id)
-		if err != nil {
-			p.logger.Error("update record status", zap.Error(err), zap.Int64("id", id))
+		if err := p.appDB.MarkDeleted(f.id, deletedAt, deletedBytes, time.Now()); err != nil {
+			p.logger.Error("update record status", zap.Error(err), zap.Int64("id", f.id))
 		}
+		p.recordEvent(f.id, f.sourcePath, EventDeleted, fmt.Sprintf("%s, %d bytes", details, deletedBytes), nil)
+		p.pruneEmptyDirs(filepath.Dir(f.sourcePath))
+	}
+	if summary.Deferred > 0 {
+		p.logger.Warn("deletion limit reached, deferring remaining files", zap.Int("deferred", summary.Deferred))
 	}
 
-	return rows.Err()
+	if p.trash != nil && ctx.Err() == nil {
+		if err := p.purgeTrash(); err != nil {
+			return summary, fmt.Errorf("purge trash: %w", err)
+		}
+	}
+
+	return summary, nil
 }
 
 func (p *Processor) Close() error {
+	// 未切换的记录保持 copied，下次启动时由 FlushEmby 继续
+	p.batch.mu.Lock()
+	if p.batch.timer != nil {
+		p.batch.timer.Stop()
+		p.batch.timer = nil
+	}
+	p.batch.mu.Unlock()
+
+	for _, tier := range p.tiers {
+		if closer, ok := tier.Backend.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				p.logger.Error("close storage backend", zap.Error(err), zap.String("dir", tier.Dir))
+			}
+		}
+	}
 	if err := p.embyDB.Close(); err != nil {
 		p.logger.Error("close emby database", zap.Error(err))
 	}
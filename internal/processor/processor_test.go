@@ -1,7 +1,10 @@
 package processor
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/sleepstars/embypathrefresh/internal/database"
 	"github.com/sleepstars/embypathrefresh/internal/model"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
@@ -53,33 +56,16 @@ func TestProcessor_ProcessFile(t *testing.T) {
 
 	// 创建应用数据库
 	appDBPath := filepath.Join(tmpDir, "app.db")
+	createAppSchema(t, appDBPath)
 	appDB, err := sql.Open("sqlite3", appDBPath)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer appDB.Close()
 
-	// 创建应用表
-	_, err = appDB.Exec(`
-		CREATE TABLE file_records (
-			id INTEGER PRIMARY KEY,
-			source_path TEXT,
-			target_path TEXT,
-			modified_time DATETIME,
-			processed_time DATETIME,
-			delete_scheduled DATETIME,
-			status TEXT,
-			created_at DATETIME,
-			updated_at DATETIME
-		);
-	`)
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	// 创建处理器
 	logger := zap.NewNop()
-	proc, err := New(embyDBPath, appDBPath, sourceDir, targetDir, 24*time.Hour, logger)
+	proc, err := New(embyDBPath, openAppStore(t, appDBPath), sourceDir, targetDir, 24*time.Hour, logger)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,7 +78,7 @@ func TestProcessor_ProcessFile(t *testing.T) {
 		Status:      "pending",
 	}
 
-	if err := proc.ProcessFile(record); err != nil {
+	if err := proc.ProcessFile(context.Background(), record); err != nil {
 		t.Fatal(err)
 	}
 
@@ -121,7 +107,7 @@ func TestProcessor_ProcessFile(t *testing.T) {
 
 	// 测试重复处理同一文件
 	t.Run("duplicate file", func(t *testing.T) {
-		if err := proc.ProcessFile(record); err != nil {
+		if err := proc.ProcessFile(context.Background(), record); err != nil {
 			t.Fatal(err)
 		}
 		var duplicateCount int
@@ -146,10 +132,14 @@ func TestProcessor_CleanupFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// 创建测试文件
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 创建测试文件，目标文件也需存在才能通过删除前校验
 	testFile1 := filepath.Join(sourceDir, "test1.mkv")
 	testFile2 := filepath.Join(sourceDir, "test2.mkv")
-	for _, file := range []string{testFile1, testFile2} {
+	for _, file := range []string{testFile1, testFile2, filepath.Join(targetDir, "test1.mkv"), filepath.Join(targetDir, "test2.mkv")} {
 		if err := os.WriteFile(file, []byte("test content"), 0644); err != nil {
 			t.Fatal(err)
 		}
@@ -170,6 +160,7 @@ func TestProcessor_CleanupFiles(t *testing.T) {
 
 	// 创建应用数据库
 	appDBPath := filepath.Join(tmpDir, "app.db")
+	createAppSchema(t, appDBPath)
 	appDB, err := sql.Open("sqlite3", appDBPath)
 	if err != nil {
 		t.Fatal(err)
@@ -180,19 +171,8 @@ func TestProcessor_CleanupFiles(t *testing.T) {
 	pastTime := now.Add(-2 * time.Hour)
 	futureTime := now.Add(2 * time.Hour)
 
-	// 创建应用表并插入测试数据
+	// 插入测试数据
 	_, err = appDB.Exec(`
-		CREATE TABLE file_records (
-			id INTEGER PRIMARY KEY,
-			source_path TEXT,
-			target_path TEXT,
-			modified_time DATETIME,
-			processed_time DATETIME,
-			delete_scheduled DATETIME,
-			status TEXT,
-			created_at DATETIME,
-			updated_at DATETIME
-		);
 		INSERT INTO file_records (source_path, target_path, modified_time, processed_time, delete_scheduled, status, created_at, updated_at)
 		VALUES 
 		(?, ?, ?, ?, ?, 'processed', ?, ?),
@@ -207,14 +187,14 @@ func TestProcessor_CleanupFiles(t *testing.T) {
 
 	// 创建处理器
 	logger := zap.NewNop()
-	proc, err := New(embyDBPath, appDBPath, sourceDir, targetDir, 24*time.Hour, logger)
+	proc, err := New(embyDBPath, openAppStore(t, appDBPath), sourceDir, targetDir, 24*time.Hour, logger)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer proc.Close()
 
 	// 运行清理
-	if err := proc.CleanupFiles(); err != nil {
+	if err := proc.CleanupFiles(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -246,3 +226,181 @@ func TestProcessor_CleanupFiles(t *testing.T) {
 		t.Error("file2 status was incorrectly updated")
 	}
 }
+
+// testEnv 测试用的目录和数据库
+type testEnv struct {
+	tmpDir     string
+	sourceDir  string
+	targetDir  string
+	embyDBPath string
+	appDBPath  string
+	embyDB     *sql.DB
+	appDB      *sql.DB
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	tmpDir := t.TempDir()
+	env := &testEnv{
+		tmpDir:     tmpDir,
+		sourceDir:  filepath.Join(tmpDir, "source"),
+		targetDir:  filepath.Join(tmpDir, "target"),
+		embyDBPath: filepath.Join(tmpDir, "library.db"),
+		appDBPath:  filepath.Join(tmpDir, "app.db"),
+	}
+	for _, dir := range []string{env.sourceDir, env.targetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	embyDB, err := sql.Open("sqlite3", env.embyDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { embyDB.Close() })
+	if _, err := embyDB.Exec(`CREATE TABLE MediaItems (Id INTEGER PRIMARY KEY, Path TEXT);`); err != nil {
+		t.Fatal(err)
+	}
+	env.embyDB = embyDB
+
+	createAppSchema(t, env.appDBPath)
+	appDB, err := sql.Open("sqlite3", env.appDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { appDB.Close() })
+	env.appDB = appDB
+
+	return env
+}
+
+// createAppSchema 使用正式的schema创建应用数据库
+func createAppSchema(t *testing.T, path string) {
+	t.Helper()
+	db, err := database.New(database.DriverSQLite, path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+// openAppStore 打开应用数据库的状态库，由处理器关闭
+func openAppStore(t *testing.T, path string) database.Store {
+	t.Helper()
+	db, err := database.New(database.DriverSQLite, path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.Store()
+}
+
+func (env *testEnv) newProcessor(t *testing.T, opts ...Option) *Processor {
+	t.Helper()
+	proc, err := New(env.embyDBPath, openAppStore(t, env.appDBPath), env.sourceDir, env.targetDir, 24*time.Hour, zap.NewNop(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proc.Close() })
+	return proc
+}
+
+// writeFile 在 dir 下创建文件并返回完整路径
+func (env *testEnv) writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// insertRecord 插入一条已处理的记录并返回其ID
+func (env *testEnv) insertRecord(t *testing.T, sourcePath, targetPath string, deleteScheduled time.Time) int64 {
+	t.Helper()
+	now := time.Now()
+	res, err := env.appDB.Exec(`
+		INSERT INTO file_records (source_path, target_path, modified_time, processed_time, delete_scheduled, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 'processed', ?, ?)`,
+		sourcePath, targetPath, now, now, deleteScheduled, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (env *testEnv) recordStatus(t *testing.T, id int64) string {
+	t.Helper()
+	var status string
+	if err := env.appDB.QueryRow("SELECT status FROM file_records WHERE id = ?", id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestProcessor_ProcessFileCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := proc.ProcessFile(ctx, &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessFile() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Error("source was moved after cancellation")
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM file_records").Scan(&n); err != nil || n != 0 {
+		t.Errorf("record was saved after cancellation: %d, %v", n, err)
+	}
+}
+
+func TestCopyToTempCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	tmp := filepath.Join(env.targetDir, "movie.mkv"+partialSuffix)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := copyToTemp(ctx, source, tmp); !errors.Is(err, context.Canceled) {
+		t.Fatalf("copyToTemp() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("partial file was left behind")
+	}
+}
+
+func TestProcessor_CleanupFilesCanceled(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	target := env.writeFile(t, env.targetDir, "movie.mkv", "movie")
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err := proc.cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deferred != 1 || summary.Deleted != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Error("source was deleted after cancellation")
+	}
+	if status := env.recordStatus(t, id); status != "processed" {
+		t.Errorf("record status = %s, want processed", status)
+	}
+}
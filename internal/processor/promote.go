@@ -0,0 +1,351 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PromotionPolicy 将近期频繁播放的文件从目标目录移回源目录（热存储）
+type PromotionPolicy struct {
+	// 统计播放的时间窗口
+	Window time.Duration
+	// 窗口内播放的用户数达到该值才提升，0表示不启用
+	MinPlays int
+	// 按目标目录下前几级目录合并统计（如剧集按 剧名/季 为2），0表示按单个文件
+	SeriesDepth int
+	// 迁移后该时长内不提升；提升后该时长内不再迁移，避免来回搬动
+	Cooldown time.Duration
+	// 每次最多提升的字节数，0表示不限
+	MaxBytesPerRun int64
+}
+
+// WithPromotion 启用热度提升，由 PromoteActive 定期评估
+func WithPromotion(policy PromotionPolicy) Option {
+	return func(p *Processor) {
+		p.promotion = policy
+	}
+}
+
+// PromotionSummary 汇总一次热度提升的结果
+type PromotionSummary struct {
+	Candidates int // 评估的已迁移文件数
+	Groups     int // 达到播放阈值的分组数
+	Promoted   int // 移回源目录的文件数
+	Bytes      int64
+	Skipped    int // 超出单次字节上限或正由其他实例处理而跳过的文件数
+	Failed     int
+}
+
+// promotionCandidate 可提升的已迁移文件
+type promotionCandidate struct {
+	id         int64
+	sourcePath string
+	targetPath string
+	status     string
+	size       int64
+}
+
+// promotionGroup 合并统计播放的一组文件
+type promotionGroup struct {
+	key     string
+	plays   int
+	size    int64
+	records []promotionCandidate
+}
+
+// PromoteActive 统计已迁移文件在时间窗口内的播放，将达到阈值的文件（或整季）移回源目录并切换Emby路径
+func (p *Processor) PromoteActive(ctx context.Context) (*PromotionSummary, error) {
+	summary := &PromotionSummary{}
+	if p.promotion.MinPlays <= 0 {
+		return summary, nil
+	}
+
+	candidates, err := p.promotionCandidates()
+	if err != nil {
+		return summary, err
+	}
+	summary.Candidates = len(candidates)
+
+	since := time.Now().Add(-p.promotion.Window)
+	groups := make(map[string]*promotionGroup)
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		size, err := p.statTarget(ctx, c.targetPath)
+		if err != nil {
+			continue
+		}
+		plays, err := p.recentPlays(p.embyPath(c.targetPath), since)
+		if err != nil {
+			p.logger.Error("query recent plays", zap.Error(err), zap.String("path", c.targetPath))
+			summary.Failed++
+			continue
+		}
+		key := p.promotionKey(c.targetPath)
+		g := groups[key]
+		if g == nil {
+			g = &promotionGroup{key: key}
+			groups[key] = g
+		}
+		c.size = size
+		g.plays += plays
+		g.size += c.size
+		g.records = append(g.records, c)
+	}
+
+	var active []*promotionGroup
+	for _, g := range groups {
+		if g.plays >= p.promotion.MinPlays {
+			active = append(active, g)
+		}
+	}
+	// 播放最多的优先提升
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].plays != active[j].plays {
+			return active[i].plays > active[j].plays
+		}
+		return active[i].key < active[j].key
+	})
+	summary.Groups = len(active)
+
+	for _, g := range active {
+		if p.promotion.MaxBytesPerRun > 0 && summary.Bytes+g.size > p.promotion.MaxBytesPerRun {
+			p.logger.Info("promotion limit reached, skipping group",
+				zap.String("group", g.key),
+				zap.Int64("size", g.size))
+			summary.Skipped += len(g.records)
+			continue
+		}
+		p.logger.Info("promoting active group",
+			zap.String("group", g.key),
+			zap.Int("plays", g.plays),
+			zap.Int("files", len(g.records)))
+		for _, c := range g.records {
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			if err := p.promote(ctx, c); errors.Is(err, errClaimed) {
+				p.logger.Debug("file is being handled by another instance", zap.String("path", c.targetPath))
+				summary.Skipped++
+				continue
+			} else if err != nil {
+				p.logger.Error("promote file", zap.Error(err), zap.String("path", c.targetPath))
+				summary.Failed++
+				continue
+			}
+			summary.Promoted++
+			summary.Bytes += c.size
+		}
+	}
+
+	p.logger.Info("promotion evaluated",
+		zap.Int("candidates", summary.Candidates),
+		zap.Int("groups", summary.Groups),
+		zap.Int("promoted", summary.Promoted),
+		zap.Int64("bytes", summary.Bytes),
+		zap.Int("skipped", summary.Skipped),
+		zap.Int("failed", summary.Failed))
+	return summary, nil
+}
+
+// promotionCandidates 返回已切换Emby路径且迁移超过冷却期的记录
+func (p *Processor) promotionCandidates() ([]promotionCandidate, error) {
+	records, err := p.appDB.PromotionCandidates(time.Now().Add(-p.promotion.Cooldown))
+	if err != nil {
+		return nil, fmt.Errorf("query promotion candidates: %w", err)
+	}
+	candidates := make([]promotionCandidate, len(records))
+	for i, r := range records {
+		candidates[i] = promotionCandidate{id: r.ID, sourcePath: r.SourcePath, targetPath: r.TargetPath, status: r.Status}
+	}
+	return candidates, nil
+}
+
+// recentPlays 返回 since 之后播放过 path 的用户数
+func (p *Processor) recentPlays(path string, since time.Time) (int, error) {
+	rows, err := p.embyDB.Query(`
+		SELECT u.LastPlayedDate
+		FROM MediaItems m
+		JOIN UserDatas u ON u.ItemId = m.Id
+		WHERE m.Path = ?`, path)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	plays := 0
+	for rows.Next() {
+		var lastPlayed interface{}
+		if err := rows.Scan(&lastPlayed); err != nil {
+			return 0, err
+		}
+		if embyTime(lastPlayed).After(since) {
+			plays++
+		}
+	}
+	return plays, rows.Err()
+}
+
+// promotionKey 返回 target 所属的统计分组：所在层级目录下的前 SeriesDepth 级目录
+func (p *Processor) promotionKey(target string) string {
+	if p.promotion.SeriesDepth <= 0 {
+		return target
+	}
+	rel, err := filepath.Rel(p.rootOf(target), filepath.Dir(target))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return target
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if len(parts) > p.promotion.SeriesDepth {
+		parts = parts[:p.promotion.SeriesDepth]
+	}
+	return filepath.Join(parts...)
+}
+
+// promote 将文件移回源目录并把Emby路径切换回源路径。
+// 源文件尚未删除（跨文件系统复制）时直接复用，同时取消对它的删除。
+// 经过多级迁移的文件沿 parent_id 找到第一跳，移回最初的源路径，迁移链上的各条记录一起标记为已提升
+func (p *Processor) promote(ctx context.Context, c promotionCandidate) error {
+	ctx, release, err := p.claimRecord(ctx, c.id, c.sourcePath, c.status)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	chain, err := p.migrationChain(c.id)
+	if err != nil {
+		return err
+	}
+	root := chain[len(chain)-1]
+	if root.ID != c.id {
+		_, releaseRoot, ok, err := p.holdLease(ctx, fileLease(root.SourcePath))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errClaimed
+		}
+		defer releaseRoot()
+		c.sourcePath = root.SourcePath
+	}
+
+	moved, copied := false, false
+	if _, err := os.Lstat(c.sourcePath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(c.sourcePath), 0755); err != nil {
+			return fmt.Errorf("create source directory: %w", err)
+		}
+		if backend, _ := p.backendFor(c.targetPath); backend != nil {
+			copied = true
+			if err := p.fetchTarget(ctx, c.id, c.targetPath, c.sourcePath); err != nil {
+				return fmt.Errorf("download file: %w", err)
+			}
+		} else {
+			copied = !p.sameDevice(c.targetPath, filepath.Dir(c.sourcePath))
+			if err := p.moveFile(ctx, c.targetPath, c.sourcePath, copied); err != nil {
+				return fmt.Errorf("move file back: %w", err)
+			}
+		}
+		moved = true
+	} else if err != nil {
+		return fmt.Errorf("stat source file: %w", err)
+	} else if root.Status == "deleted" {
+		// 源文件已删除过，现在的同名文件是新加入的
+		return fmt.Errorf("source path %s is occupied by another file", c.sourcePath)
+	}
+
+	tx, err := p.embyDB.BeginTx(ctx, nil)
+	if err != nil {
+		p.undoPromote(c, moved, copied)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	itemIDs, err := switchEmbyPath(tx, p.embyPath(c.targetPath), c.sourcePath)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		p.undoPromote(c, moved, copied)
+		return fmt.Errorf("switch emby path: %w", err)
+	}
+
+	// 复制回来的或源文件仍在时，目标文件已不再需要
+	if !moved || copied {
+		if err := p.removeTarget(ctx, c.targetPath); err != nil {
+			p.logger.Warn("remove promoted target", zap.Error(err), zap.String("path", c.targetPath))
+		}
+	}
+	removeEmptyParents(filepath.Dir(c.targetPath), p.rootOf(c.targetPath))
+	p.removeStrm(c.targetPath)
+	// 中间各级尚未删除的副本也不再需要
+	for _, r := range chain[:len(chain)-1] {
+		if err := p.removeTarget(ctx, r.SourcePath); err != nil {
+			p.logger.Warn("remove intermediate copy", zap.Error(err), zap.String("path", r.SourcePath))
+			continue
+		}
+		removeEmptyParents(filepath.Dir(r.SourcePath), p.rootOf(r.SourcePath))
+	}
+
+	// Emby已指回最初的源路径，链上各跳的路径修改都已撤销
+	now := time.Now()
+	err = p.appDB.InTx(func(q database.Queries) error {
+		for _, r := range chain {
+			if err := q.MarkPromoted(r.ID, now); err != nil {
+				return err
+			}
+			if err := q.RevertEmbyChanges(r.ID, now); err != nil {
+				return err
+			}
+		}
+		return q.InsertEmbyChanges(c.id, itemIDs, p.embyPath(c.targetPath), c.sourcePath, now)
+	})
+	if err != nil {
+		return err
+	}
+	p.refreshEmby(ctx, []refreshRequest{{recordID: c.id, itemIDs: itemIDs}})
+	for _, r := range chain {
+		p.recordEvent(r.ID, r.SourcePath, EventPromoted, "moved back to "+c.sourcePath+" from "+c.targetPath, nil)
+	}
+
+	p.logger.Info("promoted file",
+		zap.String("source", c.sourcePath),
+		zap.String("target", c.targetPath),
+		zap.Int("emby_items", len(itemIDs)))
+	return nil
+}
+
+// migrationChain 返回从记录 id 沿 parent_id 到第一跳的各条记录，id 本身在最前
+func (p *Processor) migrationChain(id int64) ([]model.FileRecord, error) {
+	var chain []model.FileRecord
+	for id != 0 {
+		r, err := p.appDB.Record(id)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			return nil, fmt.Errorf("record %d not found", id)
+		}
+		chain = append(chain, *r)
+		id = r.ParentID
+	}
+	return chain, nil
+}
+
+// undoPromote 切换Emby路径失败时将文件放回目标目录
+func (p *Processor) undoPromote(c promotionCandidate, moved, copied bool) {
+	if !moved {
+		return
+	}
+	if err := undoMove(c.targetPath, c.sourcePath, copied); err != nil {
+		p.logger.Error("roll back file move", zap.Error(err), zap.String("path", c.sourcePath))
+	}
+}
@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// migrateWithPlays 迁移 name 并为其添加 recent 个近期播放和 old 个早期播放，返回记录ID
+func (env *testEnv) migrateWithPlays(t *testing.T, proc *Processor, itemID int64, name string, recent, old int) int64 {
+	t.Helper()
+	source := env.writeFile(t, env.sourceDir, name, name)
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (?, ?)", itemID, source); err != nil {
+		t.Fatal(err)
+	}
+	id := env.migrate(t, proc, source)
+
+	// 迁移时间早于冷却期
+	past := time.Now().Add(-48 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET processed_time = ? WHERE id = ?", past, id); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < recent+old; i++ {
+		played := time.Now().Add(-time.Hour)
+		if i >= recent {
+			played = time.Now().Add(-30 * 24 * time.Hour)
+		}
+		if _, err := env.embyDB.Exec("INSERT INTO UserDatas (ItemId, UserId, PlayCount, LastPlayedDate) VALUES (?, ?, 1, ?)", itemID, i, played); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestProcessor_PromoteActive(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithPromotion(PromotionPolicy{
+		Window:      7 * 24 * time.Hour,
+		MinPlays:    2,
+		SeriesDepth: 2,
+		Cooldown:    24 * time.Hour,
+	}))
+	env.addPlayData(t)
+
+	// 同一季的两集合计达到阈值，电影只有一次窗口内的播放
+	e01 := env.migrateWithPlays(t, proc, 1, "show/s01/e01.mkv", 1, 0)
+	e02 := env.migrateWithPlays(t, proc, 2, "show/s01/e02.mkv", 1, 0)
+	movie := env.migrateWithPlays(t, proc, 3, "movies/movie.mkv", 1, 3)
+
+	summary, err := proc.PromoteActive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Candidates != 3 || summary.Groups != 1 || summary.Promoted != 2 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	for i, name := range []string{"show/s01/e01.mkv", "show/s01/e02.mkv"} {
+		source := filepath.Join(env.sourceDir, name)
+		if _, err := os.Stat(source); err != nil {
+			t.Errorf("%s was not moved back: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(env.targetDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists in target: %v", name, err)
+		}
+		if path := env.embyPath(t, int64(i+1)); path != source {
+			t.Errorf("emby path of %s = %s, want %s", name, path, source)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(env.targetDir, "show")); !os.IsNotExist(err) {
+		t.Error("empty target directories were not removed")
+	}
+	for _, id := range []int64{e01, e02} {
+		if status := env.recordStatus(t, id); status != "promoted" {
+			t.Errorf("record %d status = %s, want promoted", id, status)
+		}
+	}
+	if status := env.recordStatus(t, movie); status != "processed" {
+		t.Errorf("movie status = %s, want processed", status)
+	}
+
+	// 冷却期内不再迁移提升回来的文件
+	source := filepath.Join(env.sourceDir, "show/s01/e01.mkv")
+	env.migrate(t, proc, source)
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("promoted file was migrated again during cooldown: %v", err)
+	}
+}
+
+func TestProcessor_PromoteActiveByteLimit(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithPromotion(PromotionPolicy{
+		Window:         7 * 24 * time.Hour,
+		MinPlays:       1,
+		MaxBytesPerRun: int64(len("movies/b.mkv")),
+	}))
+	env.addPlayData(t)
+
+	// 播放较多的 a 优先，b 超出上限
+	a := env.migrateWithPlays(t, proc, 1, "movies/a.mkv", 2, 0)
+	b := env.migrateWithPlays(t, proc, 2, "movies/b.mkv", 1, 0)
+
+	summary, err := proc.PromoteActive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Promoted != 1 || summary.Skipped != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if status := env.recordStatus(t, a); status != "promoted" {
+		t.Errorf("a status = %s, want promoted", status)
+	}
+	if status := env.recordStatus(t, b); status != "processed" {
+		t.Errorf("b status = %s, want processed", status)
+	}
+}
+
+func TestProcessor_PromoteFromLaterTier(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "cold")
+	if err := os.Mkdir(coldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	proc := env.newProcessor(t,
+		WithTiers([]Tier{{Dir: coldDir, UpdateAfter: 24 * time.Hour}}),
+		WithPromotion(PromotionPolicy{Window: 7 * 24 * time.Hour, MinPlays: 1}))
+	env.addPlayData(t)
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	first := env.migrate(t, proc, source)
+	past := time.Now().Add(-48 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted', processed_time = ? WHERE id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proc.AdvanceTiers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	loc, err := proc.Locate(source)
+	if err != nil || len(loc.History) != 2 {
+		t.Fatalf("Locate() = %+v, %v", loc, err)
+	}
+	second := loc.History[1].ID
+	if _, err := env.embyDB.Exec("INSERT INTO UserDatas (ItemId, UserId, PlayCount, LastPlayedDate) VALUES (1, 1, 1, ?)", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := proc.PromoteActive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Promoted != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	// 文件回到最初的源路径，而不是上一级目录
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("file was not moved back to the original source: %v", err)
+	}
+	for _, path := range []string{filepath.Join(env.targetDir, "movie.mkv"), filepath.Join(coldDir, "movie.mkv")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", path, err)
+		}
+	}
+	if path := env.embyPath(t, 1); path != source {
+		t.Errorf("emby path = %s, want %s", path, source)
+	}
+	for _, id := range []int64{first, second} {
+		if status := env.recordStatus(t, id); status != "promoted" {
+			t.Errorf("record %d status = %s, want promoted", id, status)
+		}
+	}
+	var pending int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM emby_changes WHERE record_id = ? AND reverted_at IS NULL", first).Scan(&pending); err != nil || pending != 0 {
+		t.Errorf("unreverted emby changes of the first hop = %d, %v", pending, err)
+	}
+}
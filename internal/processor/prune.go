@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pruneOptions 源目录空文件夹清理配置
+type pruneOptions struct {
+	enabled bool
+	junk    []string
+}
+
+// WithDirPruning 在源文件移走或删除后清理变空的源目录。
+// junk 为可忽略的文件名模式（如 .DS_Store、Thumbs.db），目录中只剩这些文件时也视为空目录
+func WithDirPruning(junk []string) Option {
+	return func(p *Processor) {
+		p.prune = pruneOptions{
+			enabled: true,
+			junk:    junk,
+		}
+	}
+}
+
+// pruneEmptyDirs 从 dir 开始逐级向上删除空目录，不会删除源目录（或所在层级目录）本身
+func (p *Processor) pruneEmptyDirs(dir string) {
+	if !p.prune.enabled {
+		return
+	}
+
+	root := filepath.Clean(p.sourceDir)
+	if _, ok := relWithin(root, dir); !ok {
+		root = filepath.Clean(p.rootOf(dir))
+	}
+	for dir = filepath.Clean(dir); dir != root; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !p.isJunk(entry.Name()) {
+				return
+			}
+		}
+		for _, entry := range entries {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				p.logger.Warn("remove junk file", zap.Error(err), zap.String("path", filepath.Join(dir, entry.Name())))
+				return
+			}
+		}
+		if err := os.Remove(dir); err != nil {
+			p.logger.Warn("remove empty directory", zap.Error(err), zap.String("path", dir))
+			return
+		}
+		p.logger.Debug("removed empty source directory", zap.String("path", dir))
+	}
+}
+
+func (p *Processor) isJunk(name string) bool {
+	for _, pattern := range p.prune.junk {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
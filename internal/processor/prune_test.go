@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_PruneAfterMove(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithDirPruning([]string{".DS_Store", "Thumbs.db"}))
+
+	source := env.writeFile(t, env.sourceDir, "show/season1/e01.mkv", "episode")
+	env.writeFile(t, env.sourceDir, "show/season1/.DS_Store", "")
+	env.writeFile(t, env.sourceDir, "show/poster.jpg", "poster")
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只剩垃圾文件的目录被删除，仍有其他文件的上级目录保留
+	if _, err := os.Stat(filepath.Join(env.sourceDir, "show", "season1")); !os.IsNotExist(err) {
+		t.Error("empty season directory was not pruned")
+	}
+	if _, err := os.Stat(filepath.Join(env.sourceDir, "show", "poster.jpg")); err != nil {
+		t.Error("non-empty parent directory was pruned")
+	}
+}
+
+func TestProcessor_PruneAfterCleanup(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithDirPruning(nil))
+
+	source := env.writeFile(t, env.sourceDir, "movie/movie.mkv", "movie")
+	target := env.writeFile(t, env.targetDir, "movie/movie.mkv", "movie")
+	env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(env.sourceDir, "movie")); !os.IsNotExist(err) {
+		t.Error("empty movie directory was not pruned")
+	}
+	if _, err := os.Stat(env.sourceDir); err != nil {
+		t.Error("source root must never be pruned")
+	}
+}
+
+func TestProcessor_PruneDisabled(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(env.sourceDir, "show")); err != nil {
+		t.Error("directory was pruned although pruning is disabled")
+	}
+}
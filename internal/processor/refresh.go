@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/emby"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// refreshOptions 切换路径后刷新Emby条目的配置
+type refreshOptions struct {
+	client      *emby.Client
+	parents     bool
+	concurrency int
+}
+
+// WithEmbyRefresh 切换Emby路径后通过API刷新被修改的条目，parents 为 true 时改为刷新条目所在的目录。
+// 同时进行的刷新请求不超过 concurrency 个，不大于0时为4
+func WithEmbyRefresh(client *emby.Client, parents bool, concurrency int) Option {
+	return func(p *Processor) {
+		if concurrency <= 0 {
+			concurrency = 4
+		}
+		p.refresh = refreshOptions{client: client, parents: parents, concurrency: concurrency}
+	}
+}
+
+// refreshRequest 一条记录修改过的Emby条目
+type refreshRequest struct {
+	recordID int64
+	itemIDs  []int64
+}
+
+// refreshEmby 刷新一批记录修改过的Emby条目，同一条目只刷新一次，并将结果保存到各条记录。
+// 刷新失败不影响迁移结果，Emby 会在下次扫描媒体库时更新
+func (p *Processor) refreshEmby(ctx context.Context, requests []refreshRequest) {
+	if p.refresh.client == nil {
+		return
+	}
+
+	seen := make(map[int64]bool)
+	var items []int64
+	for _, r := range requests {
+		for _, id := range r.itemIDs {
+			if !seen[id] {
+				seen[id] = true
+				items = append(items, id)
+			}
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	parents := map[int64]int64{}
+	if p.refresh.parents {
+		var err error
+		if parents, err = p.refresh.client.ParentIDs(ctx, items); err != nil {
+			// 查不到上级目录时直接刷新条目本身
+			p.logger.Warn("query emby parent items", zap.Error(err))
+			parents = map[int64]int64{}
+		}
+	}
+	// 每条记录需要刷新的条目
+	targets := make(map[int64][]int64)
+	unique := make(map[int64]bool)
+	for _, r := range requests {
+		for _, id := range r.itemIDs {
+			target := id
+			if parent, ok := parents[id]; ok {
+				target = parent
+			}
+			targets[r.recordID] = append(targets[r.recordID], target)
+			unique[target] = true
+		}
+	}
+
+	ids := make([]int64, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[int64]error, len(ids))
+	sem := make(chan struct{}, p.refresh.concurrency)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := p.refresh.client.RefreshItem(ctx, id)
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	failed := 0
+	for id, err := range results {
+		if err != nil {
+			failed++
+			p.logger.Warn("refresh emby item", zap.Error(err), zap.Int64("item", id))
+		}
+	}
+
+	now := time.Now()
+	for _, r := range requests {
+		if len(targets[r.recordID]) == 0 {
+			continue
+		}
+		status, reason := "refreshed", ""
+		for _, id := range targets[r.recordID] {
+			if err := results[id]; err != nil {
+				status, reason = "refresh_failed", err.Error()
+				break
+			}
+		}
+		if err := p.appDB.SaveRefreshResult(r.recordID, status, reason, now); err != nil {
+			p.logger.Error("save emby refresh result", zap.Error(err), zap.Int64("id", r.recordID))
+		}
+	}
+
+	p.logger.Info("refreshed emby items",
+		zap.Int("records", len(requests)),
+		zap.Int("items", len(ids)),
+		zap.Int("failed", failed))
+}
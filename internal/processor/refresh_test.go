@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"database/sql"
+	"github.com/sleepstars/embypathrefresh/internal/emby"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// embyStub 模拟Emby的刷新和条目查询接口，记录被刷新的条目
+type embyStub struct {
+	mu        sync.Mutex
+	refreshed []string
+	inFlight  int
+	maxFlight int
+	fail      map[string]bool   // 刷新这些条目时返回500
+	parents   map[string]string // 条目ID到上级目录ID
+}
+
+func (s *embyStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Emby-Token") != "key" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/Items" {
+		var items []string
+		for _, id := range strings.Split(r.URL.Query().Get("Ids"), ",") {
+			items = append(items, `{"Id":"`+id+`","ParentId":"`+s.parents[id]+`"}`)
+		}
+		w.Write([]byte(`{"Items":[` + strings.Join(items, ",") + `]}`))
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/Items/"), "/Refresh")
+
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if s.fail[id] {
+		http.Error(w, "refresh failed", http.StatusInternalServerError)
+		return
+	}
+	s.refreshed = append(s.refreshed, id)
+}
+
+func newEmbyStub(t *testing.T, stub *embyStub) *emby.Client {
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return emby.NewClient(server.URL, "key")
+}
+
+func (env *testEnv) refreshStatus(t *testing.T, id int64) (string, string) {
+	t.Helper()
+	var status, reason sql.NullString
+	if err := env.appDB.QueryRow("SELECT refresh_status, refresh_error FROM file_records WHERE id = ?", id).
+		Scan(&status, &reason); err != nil {
+		t.Fatal(err)
+	}
+	return status.String, reason.String
+}
+
+func TestProcessor_EmbyRefreshBatch(t *testing.T) {
+	env := newTestEnv(t)
+	stub := &embyStub{fail: map[string]bool{"3": true}}
+	proc := env.newProcessor(t, WithEmbyBatch(3, time.Hour), WithEmbyRefresh(newEmbyStub(t, stub), false, 2))
+
+	var sources []string
+	for _, name := range []string{"e01.mkv", "e02.mkv", "e03.mkv"} {
+		sources = append(sources, env.writeFile(t, env.sourceDir, filepath.Join("show", name), name))
+	}
+	env.addEmbyItems(t, sources...)
+
+	var ids []int64
+	for _, source := range sources {
+		ids = append(ids, env.migrate(t, proc, source))
+	}
+
+	sort.Strings(stub.refreshed)
+	if strings.Join(stub.refreshed, ",") != "1,2" {
+		t.Errorf("refreshed items = %v, want [1 2]", stub.refreshed)
+	}
+	if stub.maxFlight > 2 {
+		t.Errorf("%d concurrent refreshes, want at most 2", stub.maxFlight)
+	}
+	for i, id := range ids {
+		status, reason := env.refreshStatus(t, id)
+		want := "refreshed"
+		if i == 2 {
+			want = "refresh_failed"
+			if !strings.Contains(reason, "refresh failed") {
+				t.Errorf("refresh error = %q", reason)
+			}
+		}
+		if status != want {
+			t.Errorf("record %d refresh status = %s, want %s", id, status, want)
+		}
+		// 刷新失败不影响迁移
+		if status := env.recordStatus(t, id); status != "processed" {
+			t.Errorf("record %d status = %s, want processed", id, status)
+		}
+	}
+}
+
+func TestProcessor_EmbyRefreshParents(t *testing.T) {
+	env := newTestEnv(t)
+	stub := &embyStub{parents: map[string]string{"1": "10", "2": "10"}}
+	proc := env.newProcessor(t, WithEmbyRefresh(newEmbyStub(t, stub), true, 0))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	for _, id := range []int64{1, 2} {
+		if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (?, ?)", id, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := env.migrate(t, proc, source)
+
+	// 两个条目在同一目录下，目录只刷新一次
+	if strings.Join(stub.refreshed, ",") != "10" {
+		t.Errorf("refreshed items = %v, want [10]", stub.refreshed)
+	}
+	if status, _ := env.refreshStatus(t, id); status != "refreshed" {
+		t.Errorf("refresh status = %s, want refreshed", status)
+	}
+
+	// 没有Emby条目的文件不刷新
+	other := env.migrate(t, proc, env.writeFile(t, env.sourceDir, "other.mkv", "other"))
+	if status, _ := env.refreshStatus(t, other); status != "" {
+		t.Errorf("refresh status = %s, want empty", status)
+	}
+}
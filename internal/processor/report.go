@@ -0,0 +1,222 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Report 一段时间内迁移活动的汇总
+type Report struct {
+	From time.Time
+	To   time.Time
+
+	Hops []HopActivity // 每一跳（源目录 → 目标目录 → 各级存储）迁移的文件
+
+	Deleted      int // 期间删除（或移入回收站）的源文件
+	DeletedBytes int64
+
+	PendingDeletes     int // 等待删除源文件的记录，Overdue 为已到期的数量
+	PendingDeleteBytes int64
+	OverdueDeletes     int
+
+	Failures []FailureCount // 期间的迁移失败、删除失败和删除阻止事件，按原因汇总
+	Space    []TierSpace    // 各级目录的剩余空间
+
+	OldestPending *model.FileRecord // 最早的未完成迁移，没有时为 nil
+}
+
+// HopActivity 一跳的迁移数量
+type HopActivity struct {
+	Hop   int
+	From  string
+	To    string
+	Files int
+	Bytes int64
+}
+
+// FailureCount 一种失败原因的事件数
+type FailureCount struct {
+	Event  string // failed 或 delete_blocked
+	Reason string
+	Count  int
+}
+
+// TierSpace 一级目录的剩余空间，存储后端的容量不统计
+type TierSpace struct {
+	Dir   string
+	Free  uint64
+	Total uint64
+	Err   string
+}
+
+// Report 从 file_records 生成 [from, to) 期间的迁移汇总
+func (p *Processor) Report(from, to time.Time) (*Report, error) {
+	r := &Report{From: from, To: to}
+	roots := p.tierRoots()
+
+	hops, err := p.appDB.HopTotals(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, total := range hops {
+		h := HopActivity{Hop: total.Hop, Files: total.Files, Bytes: total.Bytes}
+		if h.Hop+1 < len(roots) {
+			h.From, h.To = roots[h.Hop], roots[h.Hop+1]
+		}
+		r.Hops = append(r.Hops, h)
+	}
+
+	if r.Deleted, r.DeletedBytes, err = p.appDB.Deletions(from, to); err != nil {
+		return nil, err
+	}
+	if r.PendingDeletes, r.PendingDeleteBytes, r.OverdueDeletes, err = p.appDB.PendingDeletions(to); err != nil {
+		return nil, err
+	}
+
+	failures, err := p.appDB.Failures(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range failures {
+		r.Failures = append(r.Failures, FailureCount(f))
+	}
+
+	for i, dir := range roots {
+		if i > 1 && p.tiers[i-2].Backend != nil {
+			continue
+		}
+		s := TierSpace{Dir: dir}
+		if free, total, err := p.space.statFS(dir); err != nil {
+			s.Err = err.Error()
+		} else {
+			s.Free, s.Total = free, total
+		}
+		r.Space = append(r.Space, s)
+	}
+
+	if r.OldestPending, err = p.appDB.OldestPending(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Migrated 返回各跳迁移的文件总数和字节数
+func (r *Report) Migrated() (files int, bytes int64) {
+	for _, h := range r.Hops {
+		files += h.Files
+		bytes += h.Bytes
+	}
+	return files, bytes
+}
+
+// Markdown 以Markdown格式输出报告
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Migration report %s – %s\n\n", r.From.Format(time.DateTime), r.To.Format(time.DateTime))
+
+	b.WriteString("## Migrated\n\n")
+	if len(r.Hops) == 0 {
+		b.WriteString("No files were migrated.\n\n")
+	} else {
+		b.WriteString("| From | To | Files | Size |\n|---|---|---:|---:|\n")
+		for _, h := range r.Hops {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s |\n", h.From, h.To, h.Files, formatBytes(h.Bytes))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Deletions\n\n")
+	fmt.Fprintf(&b, "- Deleted: %d files, %s\n", r.Deleted, formatBytes(r.DeletedBytes))
+	fmt.Fprintf(&b, "- Pending: %d files, %s (%d overdue)\n\n", r.PendingDeletes, formatBytes(r.PendingDeleteBytes), r.OverdueDeletes)
+
+	b.WriteString("## Failures\n\n")
+	if len(r.Failures) == 0 {
+		b.WriteString("None.\n\n")
+	} else {
+		b.WriteString("| Event | Reason | Count |\n|---|---|---:|\n")
+		for _, f := range r.Failures {
+			fmt.Fprintf(&b, "| %s | %s | %d |\n", f.Event, markdownCell(f.Reason), f.Count)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Free space\n\n| Directory | Free | Total |\n|---|---:|---:|\n")
+	for _, s := range r.Space {
+		if s.Err != "" {
+			fmt.Fprintf(&b, "| %s | error: %s | |\n", s.Dir, markdownCell(s.Err))
+			continue
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", s.Dir, formatBytes(int64(s.Free)), formatBytes(int64(s.Total)))
+	}
+	b.WriteString("\n")
+
+	b.WriteString("## Oldest pending\n\n")
+	if r.OldestPending == nil {
+		b.WriteString("None.\n")
+	} else {
+		o := r.OldestPending
+		fmt.Fprintf(&b, "Record %d (%s) since %s: %s\n", o.ID, o.Status, o.CreatedAt.Format(time.DateTime), o.SourcePath)
+	}
+	return b.String()
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"time":  func(t time.Time) string { return t.Format(time.DateTime) },
+	"u64":   func(n uint64) int64 { return int64(n) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Migration report</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px}</style>
+</head><body>
+<h1>Migration report {{time .From}} – {{time .To}}</h1>
+<h2>Migrated</h2>
+{{if .Hops}}<table><tr><th>From</th><th>To</th><th>Files</th><th>Size</th></tr>
+{{range .Hops}}<tr><td>{{.From}}</td><td>{{.To}}</td><td>{{.Files}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}</table>{{else}}<p>No files were migrated.</p>{{end}}
+<h2>Deletions</h2>
+<ul><li>Deleted: {{.Deleted}} files, {{bytes .DeletedBytes}}</li>
+<li>Pending: {{.PendingDeletes}} files, {{bytes .PendingDeleteBytes}} ({{.OverdueDeletes}} overdue)</li></ul>
+<h2>Failures</h2>
+{{if .Failures}}<table><tr><th>Event</th><th>Reason</th><th>Count</th></tr>
+{{range .Failures}}<tr><td>{{.Event}}</td><td>{{.Reason}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+<h2>Free space</h2>
+<table><tr><th>Directory</th><th>Free</th><th>Total</th></tr>
+{{range .Space}}<tr><td>{{.Dir}}</td>{{if .Err}}<td colspan="2">error: {{.Err}}</td>{{else}}<td>{{bytes (u64 .Free)}}</td><td>{{bytes (u64 .Total)}}</td>{{end}}</tr>
+{{end}}</table>
+<h2>Oldest pending</h2>
+{{with .OldestPending}}<p>Record {{.ID}} ({{.Status}}) since {{time .CreatedAt}}: {{.SourcePath}}</p>{{else}}<p>None.</p>{{end}}
+</body></html>
+`))
+
+// HTML 以HTML格式输出报告
+func (r *Report) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := reportHTML.Execute(&buf, r); err != nil {
+		return "", fmt.Errorf("render report: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// markdownCell 转义表格单元格中的竖线和换行
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// formatBytes 以二进制单位显示字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
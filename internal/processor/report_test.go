@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessor_Report(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	proc.space.statFS = (&fakeDisk{free: 3 << 30, total: 4 << 30}).statFS
+
+	env.migrate(t, proc, env.writeFile(t, env.sourceDir, "a.mkv", "12345"))
+	env.migrate(t, proc, env.writeFile(t, env.sourceDir, "b.mkv", "123456"))
+
+	now := time.Now()
+	overdue := env.insertRecord(t, "/src/old.mkv", "/dst/old.mkv", now.Add(-time.Hour))
+	deleted := env.insertRecord(t, "/src/gone.mkv", "/dst/gone.mkv", now.Add(-time.Hour))
+	blocked := env.insertRecord(t, "/src/blocked.mkv", "/dst/blocked.mkv", now.Add(-time.Hour))
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE file_records SET file_size = 100 WHERE id = ?", []interface{}{overdue}},
+		{"UPDATE file_records SET status = 'deleted', deleted_at = ?, deleted_bytes = 7 WHERE id = ?", []interface{}{now, deleted}},
+		{"UPDATE file_records SET status = 'delete_blocked', block_reason = 'target | missing' WHERE id = ?", []interface{}{blocked}},
+	} {
+		if _, err := env.appDB.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, created := range []time.Time{now.Add(-time.Hour), now.Add(-48 * time.Hour)} {
+		_, err := env.appDB.Exec(`
+			INSERT INTO file_records (source_path, target_path, modified_time, status, failure_reason, created_at, updated_at)
+			VALUES (?, '', ?, 'failed', 'hook <before_move> failed', ?, ?)`,
+			filepath.Join(env.sourceDir, "failed", string(rune('a'+i))+".mkv"), now, created, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 失败按期间内的事件统计，期间之前的事件不计
+	for _, e := range []struct {
+		event, details, errText string
+		at                      time.Time
+	}{
+		{EventFailed, "migration", "hook <before_move> failed", now.Add(-time.Hour)},
+		{EventFailed, "migration", "hook <before_move> failed", now.Add(-2 * time.Hour)},
+		{EventFailed, "migration", "hook <before_move> failed", now.Add(-48 * time.Hour)},
+		{EventDeleteBlocked, "target | missing", "", now.Add(-time.Hour)},
+	} {
+		if _, err := env.appDB.Exec("INSERT INTO record_events (path, event, actor, details, error, created_at) VALUES ('/src/x.mkv', ?, 'daemon', ?, ?, ?)",
+			e.event, e.details, e.errText, e.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := proc.Report(now.Add(-24*time.Hour), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Hops) != 1 || report.Hops[0].From != env.sourceDir || report.Hops[0].To != env.targetDir {
+		t.Fatalf("hops = %+v", report.Hops)
+	}
+	// 两个迁移的文件及插入的三条已处理记录
+	if files, bytes := report.Migrated(); files != 5 || bytes != 111 {
+		t.Errorf("migrated = %d files, %d bytes, want 5 files, 111 bytes", files, bytes)
+	}
+	if report.Deleted != 1 || report.DeletedBytes != 7 {
+		t.Errorf("deleted = %d files, %d bytes", report.Deleted, report.DeletedBytes)
+	}
+	if report.PendingDeletes != 3 || report.OverdueDeletes != 1 || report.PendingDeleteBytes != 111 {
+		t.Errorf("pending deletes = %d (%d overdue), %d bytes", report.PendingDeletes, report.OverdueDeletes, report.PendingDeleteBytes)
+	}
+	if len(report.Failures) != 2 || report.Failures[0].Event != EventFailed || report.Failures[0].Count != 2 ||
+		report.Failures[0].Reason != "migration: hook <before_move> failed" || report.Failures[1].Reason != "target | missing" {
+		t.Errorf("failures = %+v", report.Failures)
+	}
+	if len(report.Space) != 2 || report.Space[1].Free != 3<<30 {
+		t.Errorf("space = %+v", report.Space)
+	}
+	if o := report.OldestPending; o == nil || !strings.HasSuffix(o.SourcePath, "failed/b.mkv") {
+		t.Errorf("oldest pending = %+v", o)
+	}
+
+	md := report.Markdown()
+	for _, want := range []string{"| 5 | 111 B |", `target \| missing`, "3.0 GiB", "failed/b.mkv"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+	html, err := report.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "hook &lt;before_move&gt; failed") {
+		t.Errorf("html is not escaped:\n%s", html)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1536:          "1.5 KiB",
+		5 << 30:       "5.0 GiB",
+		3<<40 + 1<<39: "3.5 TiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// resumeOptions 大文件续传配置
+type resumeOptions struct {
+	minSize   int64
+	chunkSize int64
+	retries   int
+}
+
+// WithResumableCopy 对不小于 minSize 字节的文件分块复制：每复制 chunkSize 字节同步到磁盘并记录进度
+// （偏移量和sha256中间状态），重启或IO错误后从最近的检查点继续，最多重试 retries 次。
+// 复制完成后对临时文件做完整校验，通过后才重命名为目标文件
+func WithResumableCopy(minSize, chunkSize int64, retries int) Option {
+	return func(p *Processor) {
+		p.resume = resumeOptions{
+			minSize:   minSize,
+			chunkSize: chunkSize,
+			retries:   retries,
+		}
+	}
+}
+
+func (p *Processor) resumable(size int64) bool {
+	return p.resume.chunkSize > 0 && size >= p.resume.minSize
+}
+
+// resumableCopy 将 src 分块复制到临时文件 tmp，返回源文件信息。
+// ctx 取消时保留临时文件和进度，下次迁移同一文件时继续
+func (p *Processor) resumableCopy(ctx context.Context, src, tmp string) (os.FileInfo, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var info os.FileInfo
+		if info, err = p.copyChunks(ctx, src, tmp); err == nil {
+			return info, nil
+		}
+		if ctx.Err() != nil || attempt >= p.resume.retries {
+			return nil, err
+		}
+
+		p.logger.Warn("copy interrupted, retrying from last checkpoint",
+			zap.Error(err),
+			zap.String("path", src),
+			zap.Int("attempt", attempt+1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+// copyChunks 从最近的检查点继续复制，完成后按源文件的校验值校验临时文件
+func (p *Processor) copyChunks(ctx context.Context, src, tmp string) (os.FileInfo, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	offset, err := p.loadCopyProgress(src, tmp, info, h)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	// 丢弃检查点之后未确认的数据
+	if err := out.Truncate(offset); err != nil {
+		return nil, err
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		p.logger.Info("resuming copy",
+			zap.String("path", src),
+			zap.Int64("offset", offset),
+			zap.Int64("size", info.Size()))
+	}
+
+	for offset < info.Size() {
+		n, err := io.CopyN(io.MultiWriter(out, h), &contextReader{ctx: ctx, r: in}, min(p.resume.chunkSize, info.Size()-offset))
+		offset += n
+		if err != nil {
+			return nil, err
+		}
+		if err := out.Sync(); err != nil {
+			return nil, err
+		}
+		if err := p.saveCopyProgress(src, tmp, info, offset, h); err != nil {
+			return nil, err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	// 复制期间源文件被修改时重新开始
+	if now, err := os.Stat(src); err != nil || now.Size() != info.Size() || !now.ModTime().Equal(info.ModTime()) {
+		p.discardCopy(tmp)
+		return nil, fmt.Errorf("source file changed during copy")
+	}
+
+	// 重新读取源文件和临时文件分别计算校验值，覆盖中断前写入的部分；
+	// 复制时累计的哈希是读到的数据，与源文件的校验值不同说明读取出错，或源文件在大小和修改时间不变的情况下被改写
+	type result struct {
+		sum string
+		err error
+	}
+	source := make(chan result, 1)
+	go func() {
+		sum, err := fileChecksum(src)
+		source <- result{sum, err}
+	}()
+	got, err := fileChecksum(tmp)
+	want := <-source
+	if err != nil {
+		return nil, fmt.Errorf("checksum copied file: %w", err)
+	}
+	if want.err != nil {
+		return nil, fmt.Errorf("checksum source file: %w", want.err)
+	}
+	if streamed := hex.EncodeToString(h.Sum(nil)); streamed != want.sum {
+		p.discardCopy(tmp)
+		return nil, fmt.Errorf("data read during copy does not match source checksum %s", want.sum)
+	}
+	if got != want.sum {
+		p.discardCopy(tmp)
+		return nil, fmt.Errorf("copied file checksum %s does not match source checksum %s", got, want.sum)
+	}
+
+	if err := p.appDB.DeleteCopyProgress(tmp); err != nil {
+		p.logger.Error("delete copy progress", zap.Error(err), zap.String("path", tmp))
+	}
+	return info, nil
+}
+
+// loadCopyProgress 恢复 tmp 的复制进度并将哈希状态载入 h，返回可继续的偏移量。
+// 源文件已变化、临时文件不完整或没有进度时从头开始
+func (p *Processor) loadCopyProgress(src, tmp string, info os.FileInfo, h hash.Hash) (int64, error) {
+	progress, err := p.appDB.CopyProgress(tmp)
+	if err != nil || progress == nil {
+		return 0, err
+	}
+
+	if progress.SourcePath != src || progress.SourceSize != info.Size() || progress.SourceMtime != info.ModTime().UnixNano() {
+		return 0, nil
+	}
+	if tmpInfo, err := os.Stat(tmp); err != nil || tmpInfo.Size() < progress.Copied {
+		return 0, nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(progress.HashState); err != nil {
+		h.Reset()
+		return 0, nil
+	}
+	return progress.Copied, nil
+}
+
+func (p *Processor) saveCopyProgress(src, tmp string, info os.FileInfo, offset int64, h hash.Hash) error {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal hash state: %w", err)
+	}
+	return p.appDB.SaveCopyProgress(&model.CopyProgress{
+		TempPath:    tmp,
+		SourcePath:  src,
+		SourceSize:  info.Size(),
+		SourceMtime: info.ModTime().UnixNano(),
+		Copied:      offset,
+		HashState:   state,
+		UpdatedAt:   time.Now(),
+	})
+}
+
+// discardCopy 删除临时文件及其进度
+func (p *Processor) discardCopy(tmp string) {
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		p.logger.Warn("remove partial file", zap.Error(err), zap.String("path", tmp))
+	}
+	if err := p.appDB.DeleteCopyProgress(tmp); err != nil {
+		p.logger.Error("delete copy progress", zap.Error(err), zap.String("path", tmp))
+	}
+}
+
+// ResumeCopies 继续上次中断的分块复制。源文件已不存在时丢弃临时文件
+func (p *Processor) ResumeCopies(ctx context.Context) error {
+	pending, err := p.appDB.CopyProgresses()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := os.Stat(c.SourcePath)
+		if os.IsNotExist(err) {
+			p.logger.Info("source of interrupted copy disappeared", zap.String("path", c.SourcePath))
+			p.discardCopy(c.TempPath)
+			continue
+		}
+		if err != nil {
+			p.logger.Error("stat source file", zap.Error(err), zap.String("path", c.SourcePath))
+			continue
+		}
+
+		record := &model.FileRecord{
+			SourcePath:   c.SourcePath,
+			ModifiedTime: info.ModTime(),
+			Status:       "pending",
+		}
+		if err := p.ProcessFile(ctx, record); err != nil {
+			p.logger.Error("resume interrupted copy", zap.Error(err), zap.String("path", c.SourcePath))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cancelAfter 在 Err 被调用 n 次后返回 context.Canceled，用于在复制中途中断
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestProcessor_ResumableCopy(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithResumableCopy(0, 4, 0))
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	target := filepath.Join(env.targetDir, "movie.mkv")
+	tmp := target + partialSuffix
+
+	// 复制两个分块后中断，临时文件和进度保留
+	ctx := &cancelAfter{Context: context.Background(), n: 2}
+	if _, err := proc.resumableCopy(ctx, source, tmp); err == nil {
+		t.Fatal("expected copy to be interrupted")
+	}
+	var copied int64
+	if err := env.appDB.QueryRow("SELECT copied FROM copy_progress WHERE temp_path = ?", tmp).Scan(&copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied != 8 {
+		t.Fatalf("checkpoint = %d, want 8", copied)
+	}
+	if content, err := os.ReadFile(tmp); err != nil || string(content) != "01234567" {
+		t.Fatalf("partial file = %q, %v", content, err)
+	}
+
+	// 重启后从检查点继续
+	if err := proc.ResumeCopies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "0123456789" {
+		t.Errorf("target = %q, %v", content, err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("partial file was left behind")
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM copy_progress").Scan(&n); err != nil || n != 0 {
+		t.Errorf("copy progress was not removed: %d, %v", n, err)
+	}
+	if status := env.recordStatus(t, 1); status != "processed" {
+		t.Errorf("record status = %s, want processed", status)
+	}
+}
+
+func TestProcessor_ResumableCopyDetectsCorruption(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithResumableCopy(0, 4, 1))
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	tmp := filepath.Join(env.targetDir, "movie.mkv") + partialSuffix
+
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	if _, err := proc.resumableCopy(ctx, source, tmp); err == nil {
+		t.Fatal("expected copy to be interrupted")
+	}
+	// 中断期间临时文件被损坏，完整校验失败后从头重新复制
+	if err := os.WriteFile(tmp, []byte("XXXX"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(env.targetDir, "movie.mkv")); err != nil || string(content) != "0123456789" {
+		t.Errorf("target = %q, %v", content, err)
+	}
+}
+
+func TestProcessor_ResumableCopyVerifiesSource(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithResumableCopy(0, 4, 1))
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	tmp := filepath.Join(env.targetDir, "movie.mkv") + partialSuffix
+
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	if _, err := proc.resumableCopy(ctx, source, tmp); err == nil {
+		t.Fatal("expected copy to be interrupted")
+	}
+	// 中断期间已复制的部分在源文件中被改写，大小和修改时间不变，检查点仍然有效
+	info, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, []byte("ABCD456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(source, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 与源文件的校验值不符，从头重新复制
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(env.targetDir, "movie.mkv")); err != nil || string(content) != "ABCD456789" {
+		t.Errorf("target = %q, %v", content, err)
+	}
+}
+
+func TestProcessor_ResumeCopiesSourceGone(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithResumableCopy(0, 4, 0))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	tmp := filepath.Join(env.targetDir, "movie.mkv") + partialSuffix
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	if _, err := proc.resumableCopy(ctx, source, tmp); err == nil {
+		t.Fatal("expected copy to be interrupted")
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.ResumeCopies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("partial file of a vanished source was kept")
+	}
+}
@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeS3 启动进程内的S3服务并返回已创建存储桶的后端
+func newFakeS3(t *testing.T) *S3Backend {
+	t.Helper()
+	mem := s3mem.New()
+	if err := mem.CreateBucket("media"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(mem).Server())
+	t.Cleanup(server.Close)
+
+	backend, err := NewS3Backend(context.Background(), S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Bucket:    "media",
+		Prefix:    "library",
+		PartSize:  5 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestS3Backend(t *testing.T) {
+	backend := newFakeS3(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// 超过分片大小，走分片上传
+	content := bytes.Repeat([]byte("0123456789abcdef"), (6<<20)/16)
+	src := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := fileChecksum(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := backend.Upload(ctx, "movies/movie.mkv", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != want {
+		t.Errorf("upload checksum = %s, want %s", sum, want)
+	}
+	if size, err := backend.Stat(ctx, "movies/movie.mkv"); err != nil || size != int64(len(content)) {
+		t.Errorf("Stat = %d, %v, want %d", size, err, len(content))
+	}
+	if got, err := backend.Checksum(ctx, "movies/movie.mkv"); err != nil || got != want {
+		t.Errorf("Checksum = %s, %v, want %s", got, err, want)
+	}
+
+	// 内容与记录的校验值不符时不写入 dst
+	dst := filepath.Join(dir, "downloaded.mkv")
+	if err := backend.Download(ctx, "movies/movie.mkv", dst, int64(len(content)), strings.Repeat("0", 64)); err == nil {
+		t.Error("expected checksum mismatch")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("unverified download left at destination: %v", err)
+	}
+	if err := backend.Download(ctx, "movies/movie.mkv", dst, int64(len(content)), want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded content differs: %v", err)
+	}
+
+	if err := backend.Delete(ctx, "movies/movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "movies/movie.mkv"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat after delete = %v, want ErrNotExist", err)
+	}
+}
+
+func TestProcessor_MigrateToS3Tier(t *testing.T) {
+	env := newTestEnv(t)
+	// Emby通过挂载点访问对象存储中的文件
+	mountDir := filepath.Join(env.tmpDir, "s3mount")
+	proc := env.newProcessor(t,
+		WithVerification(true, ""),
+		WithTiers([]Tier{{Dir: mountDir, UpdateAfter: time.Hour, DeleteAfter: time.Hour, Backend: newFakeS3(t)}}))
+
+	source := env.writeFile(t, env.sourceDir, "movies/movie.mkv", "movie")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	first := env.migrate(t, proc, source)
+	past := time.Now().Add(-2 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted', processed_time = ? WHERE id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := proc.AdvanceTiers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Migrated != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	warm := filepath.Join(env.targetDir, "movies", "movie.mkv")
+	cold := filepath.Join(mountDir, "movies", "movie.mkv")
+	if path := env.embyPath(t, 1); path != cold {
+		t.Errorf("emby path = %s, want %s", path, cold)
+	}
+	// 上传后保留上一级文件，到期后校验对象再删除
+	if _, err := os.Stat(warm); err != nil {
+		t.Fatalf("warm copy removed before cleanup: %v", err)
+	}
+	if _, err := env.appDB.Exec("UPDATE file_records SET delete_scheduled = ? WHERE parent_id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(warm); !os.IsNotExist(err) {
+		t.Errorf("warm copy was not deleted after verification: %v", err)
+	}
+
+	// 撤销时从对象存储下载回上一级
+	loc, err := proc.Locate(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := proc.Undo(UndoSelector{ID: loc.History[1].ID}); err != nil || summary.Reverted != 1 {
+		t.Fatalf("undo: %+v, %v", summary, err)
+	}
+	if content, err := os.ReadFile(warm); err != nil || string(content) != "movie" {
+		t.Errorf("file was not downloaded back: %q, %v", content, err)
+	}
+	if path := env.embyPath(t, 1); path != warm {
+		t.Errorf("emby path after undo = %s, want %s", path, warm)
+	}
+}
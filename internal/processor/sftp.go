@@ -0,0 +1,343 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTPOptions 通过SSH/SFTP访问远程主机的配置
+type SFTPOptions struct {
+	Address        string // host:port
+	User           string
+	Password       string
+	PrivateKeyFile string
+	// 校验远程主机密钥的 known_hosts 文件
+	KnownHostsFile string
+	// 不校验主机密钥，仅用于可信网络
+	InsecureIgnoreHostKey bool
+	// 远程主机上与该级目录对应的路径，Emby路径由该级目录加相对路径得到
+	RemoteDir string
+	// 传输中断时重连并从远程临时文件续传的次数
+	Retries int
+}
+
+// SFTPBackend 通过SFTP把文件上传到远程主机
+type SFTPBackend struct {
+	opts   SFTPOptions
+	config *ssh.ClientConfig
+	logger *zap.Logger
+
+	mu     sync.Mutex // 保护 conn 和 client
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// NewSFTPBackend 创建SFTP存储后端并检查能否连接远程主机
+func NewSFTPBackend(opts SFTPOptions, logger *zap.Logger) (*SFTPBackend, error) {
+	var auth []ssh.AuthMethod
+	if opts.PrivateKeyFile != "" {
+		key, err := os.ReadFile(opts.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if opts.Password != "" {
+		auth = append(auth, ssh.Password(opts.Password))
+	}
+
+	var hostKey ssh.HostKeyCallback
+	switch {
+	case opts.KnownHostsFile != "":
+		var err error
+		if hostKey, err = knownhosts.New(opts.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("load known hosts: %w", err)
+		}
+	case opts.InsecureIgnoreHostKey:
+		hostKey = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("sftp host key verification requires known_hosts_file")
+	}
+
+	b := &SFTPBackend{
+		opts: opts,
+		config: &ssh.ClientConfig{
+			User:            opts.User,
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+			Timeout:         30 * time.Second,
+		},
+		logger: logger,
+	}
+	if _, err := b.connect(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// connect 返回已建立的SFTP连接，断开后重新连接
+func (b *SFTPBackend) connect() (*sftp.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", b.opts.Address, b.config)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", b.opts.Address, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("start sftp session: %w", err)
+	}
+	b.conn, b.client = conn, client
+	return client, nil
+}
+
+// reset 关闭当前连接，下次操作时重新连接
+func (b *SFTPBackend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		b.client.Close()
+		b.conn.Close()
+		b.client, b.conn = nil, nil
+	}
+}
+
+// Close 关闭与远程主机的连接
+func (b *SFTPBackend) Close() error {
+	b.reset()
+	return nil
+}
+
+// do 在SFTP连接上执行 fn，连接出错时重置以便下次重连
+func (b *SFTPBackend) do(fn func(client *sftp.Client) error) error {
+	client, err := b.connect()
+	if err != nil {
+		return err
+	}
+	err = fn(client)
+	var status *sftp.StatusError
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.As(err, &status) {
+		b.reset()
+	}
+	return err
+}
+
+func (b *SFTPBackend) remote(key string) string {
+	return path.Join(b.opts.RemoteDir, key)
+}
+
+// Upload 上传到远程临时文件后核对大小和sha256再重命名。
+// 中断后重连并从远程临时文件的末尾续传，最多重试 Retries 次
+func (b *SFTPBackend) Upload(ctx context.Context, key, src string) (string, error) {
+	sum, err := fileChecksum(src)
+	if err != nil {
+		return "", fmt.Errorf("checksum source file: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := b.do(func(client *sftp.Client) error {
+			return b.upload(ctx, client, key, src, sum)
+		})
+		if err == nil {
+			return sum, nil
+		}
+		if ctx.Err() != nil || attempt >= b.opts.Retries {
+			return "", err
+		}
+
+		b.logger.Warn("sftp upload interrupted, resuming",
+			zap.Error(err),
+			zap.String("key", key),
+			zap.Int("attempt", attempt+1))
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		}
+	}
+}
+
+func (b *SFTPBackend) upload(ctx context.Context, client *sftp.Client, key, src, sum string) error {
+	dst := b.remote(key)
+	tmp := dst + partialSuffix
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("create remote directory: %w", err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("open remote file: %w", err)
+	}
+	defer out.Close()
+
+	// 从上次中断的位置继续，远程临时文件比源文件大时重新开始
+	remote, err := out.Stat()
+	if err != nil {
+		return fmt.Errorf("stat remote file: %w", err)
+	}
+	offset := remote.Size()
+	if offset > info.Size() {
+		offset = 0
+	}
+	if err := out.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate remote file: %w", err)
+	}
+	if offset > 0 {
+		b.logger.Info("resuming sftp upload",
+			zap.String("key", key),
+			zap.Int64("offset", offset),
+			zap.Int64("size", info.Size()))
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, &contextReader{ctx: ctx, r: in}); err != nil {
+		return fmt.Errorf("upload file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close remote file: %w", err)
+	}
+
+	uploaded, err := client.Stat(tmp)
+	if err != nil {
+		return fmt.Errorf("stat remote file: %w", err)
+	}
+	if uploaded.Size() != info.Size() {
+		return fmt.Errorf("remote file size %d does not match source size %d", uploaded.Size(), info.Size())
+	}
+	// 续传前写入的部分可能已损坏，校验失败时删除临时文件，下次从头上传
+	got, err := b.remoteChecksum(client, tmp)
+	if err != nil {
+		return err
+	}
+	if got != sum {
+		client.Remove(tmp)
+		return fmt.Errorf("remote file checksum %s does not match source checksum %s", got, sum)
+	}
+
+	if err := client.PosixRename(tmp, dst); err != nil {
+		return fmt.Errorf("rename remote file: %w", err)
+	}
+	return nil
+}
+
+// remoteChecksum 在远程主机上执行 sha256sum 计算文件的sha256，
+// 远程主机不支持执行命令时通过SFTP读取文件计算
+func (b *SFTPBackend) remoteChecksum(client *sftp.Client, file string) (string, error) {
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	if conn != nil {
+		if session, err := conn.NewSession(); err == nil {
+			out, err := session.Output("sha256sum " + shellQuote(file))
+			session.Close()
+			if fields := strings.Fields(string(out)); err == nil && len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+				return fields[0], nil
+			}
+		}
+	}
+
+	f, err := client.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("open remote file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := f.WriteTo(h); err != nil {
+		return "", fmt.Errorf("read remote file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (b *SFTPBackend) Stat(ctx context.Context, key string) (int64, error) {
+	var size int64
+	err := b.do(func(client *sftp.Client) error {
+		info, err := client.Stat(b.remote(key))
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", b.remote(key))
+		}
+		size = info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("stat remote file: %w", err)
+	}
+	return size, nil
+}
+
+func (b *SFTPBackend) Checksum(ctx context.Context, key string) (string, error) {
+	var sum string
+	err := b.do(func(client *sftp.Client) error {
+		var err error
+		sum, err = b.remoteChecksum(client, b.remote(key))
+		return err
+	})
+	return sum, err
+}
+
+func (b *SFTPBackend) Download(ctx context.Context, key, dst string, size int64, checksum string) error {
+	return b.do(func(client *sftp.Client) error {
+		in, err := client.Open(b.remote(key))
+		if err != nil {
+			return fmt.Errorf("open remote file: %w", err)
+		}
+		defer in.Close()
+
+		if err := writeDownload(in, dst, size, checksum); err != nil {
+			return fmt.Errorf("download remote file: %w", err)
+		}
+		return nil
+	})
+}
+
+func (b *SFTPBackend) Delete(ctx context.Context, key string) error {
+	return b.do(func(client *sftp.Client) error {
+		if err := client.Remove(b.remote(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove remote file: %w", err)
+		}
+		return nil
+	})
+}
+
+// shellQuote 将 s 用单引号包裹，作为远程命令的参数
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,233 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSFTPServer 启动进程内的SSH/SFTP服务（不支持执行命令），返回连接配置
+func newSFTPServer(t *testing.T) SFTPOptions {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "media" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(ln.Addr().String())}, signer.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return SFTPOptions{
+		Address:        ln.Addr().String(),
+		User:           "media",
+		Password:       "secret",
+		KnownHostsFile: knownHosts,
+		RemoteDir:      t.TempDir(),
+	}
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				return
+			}
+		}()
+	}
+}
+
+func newSFTPBackend(t *testing.T, opts SFTPOptions) *SFTPBackend {
+	t.Helper()
+	backend, err := NewSFTPBackend(opts, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+func TestSFTPBackend(t *testing.T) {
+	opts := newSFTPServer(t)
+	backend := newSFTPBackend(t, opts)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<14)
+	src := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := fileChecksum(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum, err := backend.Upload(ctx, "movies/movie.mkv", src); err != nil || sum != want {
+		t.Fatalf("Upload = %s, %v, want %s", sum, err, want)
+	}
+	if got, err := os.ReadFile(filepath.Join(opts.RemoteDir, "movies", "movie.mkv")); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("remote content differs: %v", err)
+	}
+	if size, err := backend.Stat(ctx, "movies/movie.mkv"); err != nil || size != int64(len(content)) {
+		t.Errorf("Stat = %d, %v, want %d", size, err, len(content))
+	}
+	if got, err := backend.Checksum(ctx, "movies/movie.mkv"); err != nil || got != want {
+		t.Errorf("Checksum = %s, %v, want %s", got, err, want)
+	}
+
+	// 内容与记录的校验值不符时不写入 dst
+	dst := filepath.Join(dir, "downloaded.mkv")
+	if err := backend.Download(ctx, "movies/movie.mkv", dst, int64(len(content)), strings.Repeat("0", 64)); err == nil {
+		t.Error("expected checksum mismatch")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("unverified download left at destination: %v", err)
+	}
+	if err := backend.Download(ctx, "movies/movie.mkv", dst, int64(len(content)), want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded content differs: %v", err)
+	}
+
+	if err := backend.Delete(ctx, "movies/movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "movies/movie.mkv"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat after delete = %v, want ErrNotExist", err)
+	}
+}
+
+func TestSFTPBackendResume(t *testing.T) {
+	opts := newSFTPServer(t)
+	backend := newSFTPBackend(t, opts)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<12)
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(opts.RemoteDir, "movie.mkv")
+
+	// 中断后留下的前半部分从末尾续传
+	if err := os.WriteFile(remote+partialSuffix, content[:len(content)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Upload(ctx, "movie.mkv", src); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(remote); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("resumed content differs: %v", err)
+	}
+
+	// 已损坏的部分会被续传保留，整体校验失败后删除，下次从头上传
+	corrupt := bytes.Repeat([]byte("x"), len(content)/2)
+	if err := os.WriteFile(remote+partialSuffix, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Upload(ctx, "movie.mkv", src); err == nil {
+		t.Fatal("upload over a corrupt partial file should fail verification")
+	}
+	if _, err := os.Stat(remote + partialSuffix); !os.IsNotExist(err) {
+		t.Errorf("corrupt partial file was kept: %v", err)
+	}
+	if _, err := backend.Upload(ctx, "movie.mkv", src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessor_MigrateToSFTPTier(t *testing.T) {
+	env := newTestEnv(t)
+	opts := newSFTPServer(t)
+	// Emby通过该路径访问远程主机上 RemoteDir 下的文件
+	embyDir := "/mnt/archive"
+	proc := env.newProcessor(t, WithTiers([]Tier{{Dir: embyDir, UpdateAfter: time.Hour, Backend: newSFTPBackend(t, opts)}}))
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	first := env.migrate(t, proc, source)
+	past := time.Now().Add(-2 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted', processed_time = ? WHERE id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+
+	if summary, err := proc.AdvanceTiers(context.Background()); err != nil || summary.Migrated != 1 {
+		t.Fatalf("AdvanceTiers = %+v, %v", summary, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(opts.RemoteDir, "show", "e01.mkv")); err != nil || string(got) != "episode" {
+		t.Errorf("remote file = %q, %v", got, err)
+	}
+	if path := env.embyPath(t, 1); path != filepath.Join(embyDir, "show", "e01.mkv") {
+		t.Errorf("emby path = %s", path)
+	}
+}
@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// spaceTracker 跟踪目标文件系统的剩余空间和正在复制中已占用的额度
+type spaceTracker struct {
+	mu sync.Mutex
+	// 各文件系统上正在复制的文件还需写入的字节数，以该文件系统上的第一个层级目录为键
+	committed      map[string]int64
+	reserveBytes   int64
+	reservePercent float64
+	statFS         func(path string) (free, total uint64, err error)
+}
+
+func newSpaceTracker(statFS func(path string) (free, total uint64, err error)) *spaceTracker {
+	return &spaceTracker{committed: make(map[string]int64), statFS: statFS}
+}
+
+// WithSpaceReserve 设置目标目录需保留的空间：reserveBytes 字节或总容量的 reservePercent%，取较大值
+func WithSpaceReserve(reserveBytes int64, reservePercent float64) Option {
+	return func(p *Processor) {
+		p.space.reserveBytes = reserveBytes
+		p.space.reservePercent = reservePercent
+	}
+}
+
+// spaceKey 返回层级目录 root 所在文件系统的额度键：与 root 位于同一文件系统的第一个层级目录
+func (p *Processor) spaceKey(root string) string {
+	for _, r := range p.tierRoots()[1:] {
+		if r == root || p.sameDevice(r, root) {
+			return r
+		}
+	}
+	return root
+}
+
+// reserveSpace 检查层级目录 dir 所在文件系统是否有足够空间再写入 size 字节，足够时占用相应额度。
+// 同一文件系统上正在复制的文件共用额度，不同文件系统互不影响
+func (p *Processor) reserveSpace(dir string, size int64) (bool, error) {
+	key := p.spaceKey(dir)
+	t := p.space
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	free, total, err := t.statFS(dir)
+	if err != nil {
+		return false, err
+	}
+	reserve := t.reserveBytes
+	if pct := int64(float64(total) * t.reservePercent / 100); pct > reserve {
+		reserve = pct
+	}
+
+	available := int64(free) - t.committed[key] - reserve
+	if size > available {
+		p.logger.Debug("not enough target space",
+			zap.String("dir", dir),
+			zap.Int64("size", size),
+			zap.Uint64("free", free),
+			zap.Int64("committed", t.committed[key]),
+			zap.Int64("reserve", reserve))
+		return false, nil
+	}
+	t.committed[key] += size
+	return true, nil
+}
+
+// releaseSpace 释放 reserveSpace 为层级目录 dir 占用的额度
+func (p *Processor) releaseSpace(dir string, size int64) {
+	key := p.spaceKey(dir)
+	t := p.space
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.committed[key] -= size; t.committed[key] == 0 {
+		delete(t.committed, key)
+	}
+}
+
+// spaceNeeded 返回复制 record 还需写入目标文件系统的字节数，续传时扣除临时文件中已复制的部分
+func (p *Processor) spaceNeeded(record *model.FileRecord) int64 {
+	if !p.resumable(record.FileSize) {
+		return record.FileSize
+	}
+	info, err := os.Stat(record.TargetPath + partialSuffix)
+	if err != nil || info.Size() > record.FileSize {
+		return record.FileSize
+	}
+	return record.FileSize - info.Size()
+}
+
+// waitForSpace 将记录标记为等待空间，由 ResumeWaiting 在空间释放后继续迁移
+func (p *Processor) waitForSpace(record *model.FileRecord) error {
+	// 重试时仍然空间不足不重复通知
+	retry := record.Status == "waiting_for_space"
+	if !retry {
+		p.notifier.Notify(notify.Event{
+			Type:     notify.EventDiskLow,
+			RecordID: record.ID,
+			Path:     p.rootOf(record.TargetPath),
+			Target:   record.TargetPath,
+			Size:     record.FileSize,
+			Reason:   "not enough free space, waiting",
+		})
+	}
+	record.Status = "waiting_for_space"
+	record.UpdatedAt = time.Now()
+	if err := p.saveRecord(record); err != nil {
+		return err
+	}
+	if !retry {
+		p.recordEvent(record.ID, record.SourcePath, EventWaitingForSpace, "target "+record.TargetPath, nil)
+	}
+
+	p.logger.Info("waiting for target space",
+		zap.String("path", record.SourcePath),
+		zap.Int64("size", record.FileSize))
+	return nil
+}
+
+// ResumeWaiting 重新尝试迁移等待空间的文件，按进入等待的先后顺序处理
+func (p *Processor) ResumeWaiting(ctx context.Context) error {
+	waiting, err := p.appDB.WaitingRecords()
+	if err != nil {
+		return fmt.Errorf("query waiting files: %w", err)
+	}
+
+	for i := range waiting {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.resumeWaitingFile(ctx, &waiting[i])
+	}
+
+	return nil
+}
+
+// resumeWaitingFile 重试迁移一个等待空间的文件，其他实例正在处理或已处理时跳过
+func (p *Processor) resumeWaitingFile(ctx context.Context, record *model.FileRecord) {
+	ctx, release, ok, err := p.holdLease(ctx, fileLease(record.SourcePath))
+	if err != nil {
+		p.logger.Error("resume waiting file", zap.Error(err), zap.String("path", record.SourcePath))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer release()
+
+	// 加锁前其他实例可能已经处理过
+	if current, err := p.appDB.Record(record.ID); err != nil || current == nil || current.Status != "waiting_for_space" {
+		return
+	}
+
+	info, err := os.Stat(record.SourcePath)
+	if os.IsNotExist(err) {
+		// 源文件在等待期间被移走，放弃迁移。记录标记为 failed，文件再次出现时沿用该记录重新迁移
+		p.logger.Info("waiting file disappeared", zap.String("path", record.SourcePath))
+		record.Status = "failed"
+		record.FailureReason = "source disappeared while waiting for space"
+		record.UpdatedAt = time.Now()
+		if err := p.saveRecord(record); err != nil {
+			p.logger.Error("update record status", zap.Error(err), zap.Int64("id", record.ID))
+		}
+		p.recordEvent(record.ID, record.SourcePath, EventFailed, "waiting for space", errors.New(record.FailureReason))
+		return
+	}
+	if err != nil {
+		p.logger.Error("stat waiting file", zap.Error(err), zap.String("path", record.SourcePath))
+		return
+	}
+	record.FileSize = info.Size()
+
+	if err := p.migrate(ctx, record); err != nil {
+		p.logger.Error("resume waiting file", zap.Error(err), zap.String("path", record.SourcePath))
+		p.migrationFailed(record, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package processor
+
+import "errors"
+
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("free space check is not supported on this platform")
+}
+
+func sameDevice(a, b string) bool {
+	return false
+}
@@ -0,0 +1,216 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDisk 模拟目标文件系统的剩余空间
+type fakeDisk struct {
+	free, total uint64
+}
+
+func (d *fakeDisk) statFS(path string) (uint64, uint64, error) {
+	return d.free, d.total, nil
+}
+
+// crossDevice 让处理器按跨文件系统复制处理，并使用模拟的剩余空间
+func crossDevice(proc *Processor, disk *fakeDisk) {
+	proc.sameDevice = func(a, b string) bool { return false }
+	proc.space.statFS = disk.statFS
+}
+
+func TestProcessor_ProcessFileCopiesAcrossDevices(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(env.targetDir, "show", "e01.mkv")
+	if content, err := os.ReadFile(target); err != nil || string(content) != "episode" {
+		t.Errorf("target was not copied: %v", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Error("source should be kept until cleanup")
+	}
+	if _, err := os.Stat(target + partialSuffix); !os.IsNotExist(err) {
+		t.Error("partial file was left behind")
+	}
+	if len(proc.space.committed) != 0 {
+		t.Errorf("committed space was not released: %v", proc.space.committed)
+	}
+}
+
+func TestProcessor_WaitingForSpace(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithSpaceReserve(100, 0))
+	disk := &fakeDisk{free: 105, total: 1000}
+	crossDevice(proc, disk)
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Path) VALUES (?)", source); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var id int64
+	var status string
+	if err := env.appDB.QueryRow("SELECT id, status FROM file_records WHERE source_path = ?", source).Scan(&id, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "waiting_for_space" {
+		t.Fatalf("record status = %s, want waiting_for_space", status)
+	}
+	target := filepath.Join(env.targetDir, "movie.mkv")
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("file was copied without enough space")
+	}
+	var embyPath string
+	if err := env.embyDB.QueryRow("SELECT Path FROM MediaItems").Scan(&embyPath); err != nil || embyPath != source {
+		t.Errorf("emby path changed while waiting: %s", embyPath)
+	}
+
+	// 仍然空间不足时保持等待
+	if err := proc.ResumeWaiting(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "waiting_for_space" {
+		t.Fatalf("record status = %s, want waiting_for_space", status)
+	}
+
+	// 空间释放后自动继续
+	disk.free = 200
+	if err := proc.ResumeWaiting(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "processed" {
+		t.Fatalf("record status = %s, want processed", status)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("target was not copied after space freed: %v", err)
+	}
+	if err := env.embyDB.QueryRow("SELECT Path FROM MediaItems").Scan(&embyPath); err != nil || embyPath != target {
+		t.Errorf("emby path = %s, want %s", embyPath, target)
+	}
+}
+
+func TestProcessor_WaitingSourceDisappeared(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithSpaceReserve(100, 0))
+	crossDevice(proc, &fakeDisk{free: 105, total: 1000})
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "0123456789")
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.ResumeWaiting(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录保留为 failed，审计事件仍然关联到该记录
+	var id int64
+	var status string
+	if err := env.appDB.QueryRow("SELECT id, status FROM file_records WHERE source_path = ?", source).Scan(&id, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" {
+		t.Errorf("record status = %s, want failed", status)
+	}
+	history, err := proc.Events(EventFilter{RecordID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := history[len(history)-1]; last.Event != EventFailed {
+		t.Errorf("last event = %s, want %s", last.Event, EventFailed)
+	}
+}
+
+func TestProcessor_ReserveSpace(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithSpaceReserve(10, 20))
+	crossDevice(proc, &fakeDisk{free: 500, total: 1000})
+
+	// 预留取 max(10, 20% * 1000) = 200，可用 300
+	if ok, err := proc.reserveSpace(proc.targetDir, 200); err != nil || !ok {
+		t.Fatalf("reserveSpace(200) = %v, %v", ok, err)
+	}
+	// 已占用 200 后只剩 100
+	if ok, _ := proc.reserveSpace(proc.targetDir, 150); ok {
+		t.Error("reservation should account for committed bytes")
+	}
+	proc.releaseSpace(proc.targetDir, 200)
+	if ok, _ := proc.reserveSpace(proc.targetDir, 150); !ok {
+		t.Error("reservation should succeed after release")
+	}
+}
+
+func TestProcessor_ReserveSpacePerFilesystem(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "cold")
+	proc := env.newProcessor(t, WithTiers([]Tier{{Dir: coldDir}}))
+	crossDevice(proc, &fakeDisk{free: 300, total: 1000})
+
+	// 复制到冷存储占用的额度不影响目标目录所在的文件系统
+	if ok, err := proc.reserveSpace(coldDir, 250); err != nil || !ok {
+		t.Fatalf("reserveSpace(cold, 250) = %v, %v", ok, err)
+	}
+	if ok, err := proc.reserveSpace(proc.targetDir, 250); err != nil || !ok {
+		t.Errorf("reserveSpace(target, 250) = %v, %v; want reservation on a separate filesystem", ok, err)
+	}
+	if ok, _ := proc.reserveSpace(coldDir, 100); ok {
+		t.Error("reservation should account for bytes committed on the same filesystem")
+	}
+
+	// 同一文件系统上的层级目录共用额度
+	proc.sameDevice = func(a, b string) bool { return true }
+	if ok, _ := proc.reserveSpace(coldDir, 100); ok {
+		t.Error("reservation should account for bytes committed on the shared filesystem")
+	}
+}
+
+func TestProcessor_SpaceNeededResume(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithResumableCopy(0, 4, 1))
+	record := &model.FileRecord{TargetPath: filepath.Join(env.targetDir, "movie.mkv"), FileSize: 10}
+	if got := proc.spaceNeeded(record); got != 10 {
+		t.Errorf("spaceNeeded() = %d, want 10", got)
+	}
+	// 续传时只需写入临时文件之后的部分
+	env.writeFile(t, env.targetDir, "movie.mkv"+partialSuffix, "0123")
+	if got := proc.spaceNeeded(record); got != 6 {
+		t.Errorf("spaceNeeded() = %d, want 6", got)
+	}
+}
+
+func TestProcessor_ProcessFileRollsBackOnEmbyFailure(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	if _, err := env.embyDB.Exec("DROP TABLE MediaItems"); err != nil {
+		t.Fatal(err)
+	}
+
+	source := env.writeFile(t, env.sourceDir, "a.mkv", "content")
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err == nil {
+		t.Fatal("expected emby update to fail")
+	}
+
+	if _, err := os.Stat(source); err != nil {
+		t.Error("source was not moved back after emby failure")
+	}
+	if _, err := os.Stat(filepath.Join(env.targetDir, "a.mkv")); !os.IsNotExist(err) {
+		t.Error("target was left behind after emby failure")
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd
+
+package processor
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// diskSpace 返回 path 所在文件系统对非特权用户可用的空间和总容量
+func diskSpace(path string) (free, total uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+// sameDevice 判断两个路径是否位于同一文件系统，无法判断时返回 false
+func sameDevice(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+	return okA && okB && statA.Dev == statB.Dev
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package processor
+
+import (
+	"golang.org/x/sys/windows"
+	"path/filepath"
+	"strings"
+)
+
+// diskSpace 返回 path 所在卷对当前用户可用的空间和总容量
+func diskSpace(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return 0, 0, err
+	}
+	return free, total, nil
+}
+
+// sameDevice 判断两个路径是否位于同一卷
+func sameDevice(a, b string) bool {
+	volA := filepath.VolumeName(a)
+	return volA != "" && strings.EqualFold(volA, filepath.VolumeName(b))
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"go.uber.org/zap"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Strm 为通过HTTP提供的文件生成 .strm 文件，Emby条目改为指向 .strm，播放时读取其中的URL
+type Strm struct {
+	// .strm 文件的存放目录（需在Emby媒体库中），按文件相对层级目录的路径存放
+	Dir string
+	// 生成URL的模板，见 ParseStrmURL
+	URL *template.Template
+}
+
+// strmURLData URL模板可用的字段
+type strmURLData struct {
+	Path    string // 相对层级目录的路径，以 / 分隔并按URL路径转义
+	RawPath string // 未转义的相对路径
+	Name    string // 文件名（未转义）
+}
+
+// ParseStrmURL 解析 .strm 的URL模板，如 "https://media.example.com/{{.Path}}"。
+// 可用字段：Path（URL转义的相对路径）、RawPath（未转义的相对路径）、Name（文件名）
+func ParseStrmURL(text string) (*template.Template, error) {
+	tmpl, err := template.New("strm").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse strm url template: %w", err)
+	}
+	if _, err := renderStrmURL(tmpl, "example/file.mkv"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func renderStrmURL(tmpl *template.Template, rel string) (string, error) {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, strmURLData{
+		Path:    strings.Join(parts, "/"),
+		RawPath: rel,
+		Name:    path.Base(rel),
+	}); err != nil {
+		return "", fmt.Errorf("render strm url: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// strmFor 返回 target 所在层级的 .strm 配置及 target 相对该层级的路径（以 / 分隔），未配置时返回 nil
+func (p *Processor) strmFor(target string) (*Strm, string) {
+	for _, tier := range p.tiers {
+		if tier.Strm == nil {
+			continue
+		}
+		if rel, ok := relWithin(tier.Dir, target); ok && rel != "." {
+			return tier.Strm, filepath.ToSlash(rel)
+		}
+	}
+	return nil, ""
+}
+
+// embyPath 返回Emby中指向 target 的路径：使用 .strm 的层级为对应的 .strm 文件，否则为 target 本身
+func (p *Processor) embyPath(target string) string {
+	strm, rel := p.strmFor(target)
+	if strm == nil {
+		return target
+	}
+	return strmPath(strm, rel)
+}
+
+func strmPath(strm *Strm, rel string) string {
+	name := filepath.FromSlash(rel)
+	return filepath.Join(strm.Dir, strings.TrimSuffix(name, filepath.Ext(name))+".strm")
+}
+
+// writeStrm 为 target 写入 .strm 文件，target 所在层级未配置 .strm 时不做任何事
+func (p *Processor) writeStrm(target string) error {
+	strm, rel := p.strmFor(target)
+	if strm == nil {
+		return nil
+	}
+	link, err := renderStrmURL(strm.URL, rel)
+	if err != nil {
+		return err
+	}
+
+	file := strmPath(strm, rel)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("create strm directory: %w", err)
+	}
+	tmp := file + partialSuffix
+	if err := os.WriteFile(tmp, []byte(link+"\n"), 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write strm file: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write strm file: %w", err)
+	}
+	if err := p.applyOwnership(file, target, false); err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
+
+// removeStrm 删除 target 对应的 .strm 文件并清理变空的目录
+func (p *Processor) removeStrm(target string) {
+	strm, rel := p.strmFor(target)
+	if strm == nil {
+		return
+	}
+	file := strmPath(strm, rel)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		p.logger.Warn("remove strm file", zap.Error(err), zap.String("path", file))
+		return
+	}
+	removeEmptyParents(filepath.Dir(file), strm.Dir)
+}
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderStrmURL(t *testing.T) {
+	tmpl, err := ParseStrmURL("https://media.example.com/cold/{{.Path}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := renderStrmURL(tmpl, "Show Name/Season 1/e01 #1.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://media.example.com/cold/Show%20Name/Season%201/e01%20%231.mkv"; got != want {
+		t.Errorf("url = %s, want %s", got, want)
+	}
+
+	if _, err := ParseStrmURL("https://media.example.com/{{.Missing}}"); err == nil {
+		t.Error("expected error for unknown template field")
+	}
+}
+
+func TestProcessor_MigrateToStrmTier(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "http")
+	strmDir := filepath.Join(env.tmpDir, "library")
+	if err := os.Mkdir(coldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseStrmURL("http://cold.lan/{{.Path}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := env.newProcessor(t, WithTiers([]Tier{{
+		Dir:         coldDir,
+		UpdateAfter: time.Hour,
+		Strm:        &Strm{Dir: strmDir, URL: tmpl},
+	}}))
+
+	if _, err := env.embyDB.Exec(`
+		CREATE TABLE UserDatas (ItemId INTEGER, UserId INTEGER, PlayCount INTEGER, LastPlayedDate DATETIME);
+		INSERT INTO UserDatas (ItemId, UserId, PlayCount) VALUES (1, 1, 3);`); err != nil {
+		t.Fatal(err)
+	}
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	first := env.migrate(t, proc, source)
+	past := time.Now().Add(-2 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted', processed_time = ? WHERE id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := proc.AdvanceTiers(context.Background()); err != nil || summary.Migrated != 1 {
+		t.Fatalf("AdvanceTiers = %+v, %v", summary, err)
+	}
+
+	strm := filepath.Join(strmDir, "show", "e01.strm")
+	if content, err := os.ReadFile(strm); err != nil || string(content) != "http://cold.lan/show/e01.mkv\n" {
+		t.Errorf("strm file = %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, "show", "e01.mkv")); err != nil {
+		t.Errorf("file was not moved to the http tier: %v", err)
+	}
+	if path := env.embyPath(t, 1); path != strm {
+		t.Errorf("emby path = %s, want %s", path, strm)
+	}
+	// 条目ID不变，用户数据仍然关联在原条目上
+	var plays int
+	if err := env.embyDB.QueryRow("SELECT PlayCount FROM UserDatas WHERE ItemId = 1").Scan(&plays); err != nil || plays != 3 {
+		t.Errorf("user data = %d, %v", plays, err)
+	}
+
+	// 撤销时删除 .strm 并恢复原路径
+	loc, err := proc.Locate(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := proc.Undo(UndoSelector{ID: loc.History[1].ID}); err != nil || summary.Reverted != 1 {
+		t.Fatalf("undo: %+v, %v", summary, err)
+	}
+	warm := filepath.Join(env.targetDir, "show", "e01.mkv")
+	if path := env.embyPath(t, 1); path != warm {
+		t.Errorf("emby path after undo = %s, want %s", path, warm)
+	}
+	if _, err := os.Stat(strm); !os.IsNotExist(err) {
+		t.Errorf("strm file was not removed: %v", err)
+	}
+	if _, err := os.Stat(strmDir); err != nil {
+		t.Errorf("strm root was removed: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TieringPolicy 分层迁移策略，设置的条件需全部满足才迁移
+type TieringPolicy struct {
+	Name string
+	// 最近一次播放或访问距今超过该时长，0表示不限
+	NotPlayedFor time.Duration
+	// 加入媒体库（Emby的添加时间，没有时取文件修改时间）距今超过该时长，0表示不限
+	AddedBefore time.Duration
+	// 文件不小于该字节数，0表示不限
+	MinSize int64
+	// 播放次数不超过该值，小于0表示不限
+	MaxPlayCount int
+}
+
+// tieringFacts 评估策略所需的文件信息
+type tieringFacts struct {
+	size       int64
+	lastAccess time.Time // 文件访问时间与Emby最近播放时间中较晚的一个
+	added      time.Time
+	playCount  int
+}
+
+// WithTieringPolicies 设置源目录 → 目标目录这一跳的分层迁移策略，后续各级的策略见 Tier.Policies。
+// 由 EvaluatePolicies 定期评估
+func WithTieringPolicies(policies []TieringPolicy) Option {
+	return func(p *Processor) {
+		p.policies = policies
+	}
+}
+
+// TieringSummary 汇总一次策略评估的结果
+type TieringSummary struct {
+	Scanned  int // 评估的文件数
+	Matched  int // 命中策略的文件数
+	Migrated int // 成功送入迁移流程的文件数
+	Failed   int
+}
+
+// EvaluatePolicies 遍历源目录，并检查已在各级停留的文件，将命中下一级策略的文件送入迁移流程
+func (p *Processor) EvaluatePolicies(ctx context.Context) (*TieringSummary, error) {
+	summary := &TieringSummary{}
+	now := time.Now()
+	var matched []*model.FileRecord
+	if len(p.policies) > 0 {
+		records, err := p.sourcePolicyMatches(ctx, summary, now)
+		if err != nil {
+			return summary, fmt.Errorf("evaluate tiering policies: %w", err)
+		}
+		matched = append(matched, records...)
+	}
+	for i, tier := range p.tiers {
+		if len(tier.Policies) == 0 {
+			continue
+		}
+		records, err := p.tierPolicyMatches(ctx, i, summary, now)
+		if err != nil {
+			return summary, fmt.Errorf("evaluate tiering policies for tier %s: %w", tier.Dir, err)
+		}
+		matched = append(matched, records...)
+	}
+	summary.Matched = len(matched)
+
+	// 评估结束后再迁移，避免移动文件和清理空目录影响遍历
+	for _, record := range matched {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		if err := p.ProcessFile(ctx, record); err != nil {
+			p.logger.Error("migrate tiered file", zap.Error(err), zap.String("path", record.SourcePath))
+			summary.Failed++
+			continue
+		}
+		// 其他实例或任务已在处理时 ProcessFile 不创建记录
+		if record.ID != 0 {
+			summary.Migrated++
+		}
+	}
+
+	p.logger.Info("tiering policies evaluated",
+		zap.Int("scanned", summary.Scanned),
+		zap.Int("matched", summary.Matched),
+		zap.Int("migrated", summary.Migrated),
+		zap.Int("failed", summary.Failed))
+	return summary, nil
+}
+
+// sourcePolicyMatches 遍历源目录，返回命中 WithTieringPolicies 策略的文件
+func (p *Processor) sourcePolicyMatches(ctx context.Context, summary *TieringSummary, now time.Time) ([]*model.FileRecord, error) {
+	var matched []*model.FileRecord
+	err := filepath.WalkDir(p.sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			p.logger.Warn("walk source directory", zap.Error(err), zap.String("path", path))
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p.trash != nil && filepath.Clean(path) == filepath.Clean(p.trash.dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, partialSuffix) || p.isJunk(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		// 已迁移（等待删除源文件）、被撤销或恢复的文件不再评估
+		if exists, err := p.hasActiveRecord(path); err != nil || exists {
+			return err
+		}
+		summary.Scanned++
+
+		facts, err := p.tieringFacts(path, info)
+		if err != nil {
+			p.logger.Error("collect tiering facts", zap.Error(err), zap.String("path", path))
+			summary.Failed++
+			return nil
+		}
+		if record := p.policyMatch(p.policies, path, info, facts, now); record != nil {
+			matched = append(matched, record)
+		}
+		return nil
+	})
+	return matched, err
+}
+
+// tierPolicyMatches 检查可以迁移到第 i 级的文件（与 AdvanceTiers 的条件相同但不要求停留时长），
+// 返回命中该级策略的文件
+func (p *Processor) tierPolicyMatches(ctx context.Context, i int, summary *TieringSummary, now time.Time) ([]*model.FileRecord, error) {
+	candidates, err := p.appDB.TierCandidates(i, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*model.FileRecord
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(c.TargetPath)
+		if err != nil {
+			// 文件已被提升、撤销或手动移走
+			continue
+		}
+		if exists, err := p.hasActiveRecord(c.TargetPath); err != nil {
+			return nil, err
+		} else if exists {
+			continue
+		}
+		summary.Scanned++
+
+		facts, err := p.tieringFacts(c.TargetPath, info)
+		if err != nil {
+			p.logger.Error("collect tiering facts", zap.Error(err), zap.String("path", c.TargetPath))
+			summary.Failed++
+			continue
+		}
+		if record := p.policyMatch(p.tiers[i].Policies, c.TargetPath, info, facts, now); record != nil {
+			matched = append(matched, record)
+		}
+	}
+	return matched, nil
+}
+
+// policyMatch 在 path 命中 policies 中的策略时记录事件并返回待迁移的记录
+func (p *Processor) policyMatch(policies []TieringPolicy, path string, info os.FileInfo, facts tieringFacts, now time.Time) *model.FileRecord {
+	policy := matchPolicy(policies, facts, now)
+	if policy == nil {
+		return nil
+	}
+	p.logger.Info("tiering policy matched",
+		zap.String("policy", policy.Name),
+		zap.String("path", path))
+	p.recordEvent(0, path, EventScheduled, "tiering policy "+policy.Name, nil)
+	return &model.FileRecord{
+		SourcePath:   path,
+		ModifiedTime: info.ModTime(),
+		Status:       "pending",
+	}
+}
+
+// matchPolicy 返回 facts 命中的第一个策略
+func matchPolicy(policies []TieringPolicy, facts tieringFacts, now time.Time) *TieringPolicy {
+	for i := range policies {
+		policy := &policies[i]
+		if policy.NotPlayedFor > 0 && now.Sub(facts.lastAccess) < policy.NotPlayedFor {
+			continue
+		}
+		if policy.AddedBefore > 0 && now.Sub(facts.added) < policy.AddedBefore {
+			continue
+		}
+		if policy.MinSize > 0 && facts.size < policy.MinSize {
+			continue
+		}
+		if policy.MaxPlayCount >= 0 && facts.playCount > policy.MaxPlayCount {
+			continue
+		}
+		return policy
+	}
+	return nil
+}
+
+// tieringFacts 收集文件大小、访问时间，以及Emby中的添加时间和播放记录
+func (p *Processor) tieringFacts(path string, info os.FileInfo) (tieringFacts, error) {
+	facts := tieringFacts{
+		size:       info.Size(),
+		lastAccess: fileAtime(info),
+		added:      info.ModTime(),
+	}
+
+	var lastPlayed, dateCreated interface{}
+	err := p.embyDB.QueryRow(`
+		SELECT MAX(u.LastPlayedDate), COALESCE(SUM(u.PlayCount), 0), MIN(m.DateCreated)
+		FROM MediaItems m
+		LEFT JOIN UserDatas u ON u.ItemId = m.Id
+		WHERE m.Path = ?`, path).Scan(&lastPlayed, &facts.playCount, &dateCreated)
+	if err != nil {
+		return facts, fmt.Errorf("query emby play data: %w", err)
+	}
+
+	if t := embyTime(lastPlayed); t.After(facts.lastAccess) {
+		facts.lastAccess = t
+	}
+	if t := embyTime(dateCreated); !t.IsZero() {
+		facts.added = t
+	}
+	return facts, nil
+}
+
+// embyTime 解析Emby数据库中的时间，支持时间类型、文本和Unix秒
+func embyTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case int64:
+		return time.Unix(v, 0)
+	case []byte:
+		return embyTime(string(v))
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", time.DateOnly} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0)
+		}
+	}
+	return time.Time{}
+}
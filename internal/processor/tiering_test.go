@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addPlayData 为测试的Emby数据库添加播放记录表和添加时间列
+func (env *testEnv) addPlayData(t *testing.T) {
+	t.Helper()
+	if _, err := env.embyDB.Exec(`
+		ALTER TABLE MediaItems ADD COLUMN DateCreated DATETIME;
+		CREATE TABLE UserDatas (ItemId INTEGER, UserId INTEGER, PlayCount INTEGER, LastPlayedDate DATETIME);`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessor_EvaluatePolicies(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithTieringPolicies([]TieringPolicy{{
+		Name:         "cold",
+		NotPlayedFor: 90 * 24 * time.Hour,
+		AddedBefore:  180 * 24 * time.Hour,
+		MaxPlayCount: -1,
+	}}))
+	env.addPlayData(t)
+
+	longAgo := time.Now().Add(-200 * 24 * time.Hour)
+	files := map[string]struct {
+		added      time.Time
+		lastPlayed time.Time
+		recorded   bool // 已迁移、等待删除源文件
+		migrate    bool
+	}{
+		"movies/cold.mkv":   {added: longAgo, migrate: true},
+		"movies/played.mkv": {added: longAgo, lastPlayed: time.Now().Add(-24 * time.Hour)},
+		"movies/copied.mkv": {added: longAgo, recorded: true},
+		"shows/new.mkv":     {added: time.Now().Add(-24 * time.Hour)},
+	}
+	var id int64
+	for name, f := range files {
+		path := env.writeFile(t, env.sourceDir, name, name)
+		if err := os.Chtimes(path, longAgo, longAgo); err != nil {
+			t.Fatal(err)
+		}
+		id++
+		if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path, DateCreated) VALUES (?, ?, ?)", id, path, f.added); err != nil {
+			t.Fatal(err)
+		}
+		if !f.lastPlayed.IsZero() {
+			if _, err := env.embyDB.Exec("INSERT INTO UserDatas (ItemId, UserId, PlayCount, LastPlayedDate) VALUES (?, 1, 1, ?)", id, f.lastPlayed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if f.recorded {
+			env.insertRecord(t, path, filepath.Join(env.tmpDir, "elsewhere", name), time.Now().Add(time.Hour))
+		}
+	}
+
+	summary, err := proc.EvaluatePolicies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Scanned != 3 || summary.Matched != 1 || summary.Migrated != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	for name, f := range files {
+		_, err := os.Stat(filepath.Join(env.targetDir, name))
+		if migrated := err == nil; migrated != f.migrate {
+			t.Errorf("%s migrated = %v, want %v", name, migrated, f.migrate)
+		}
+	}
+
+	// 已有记录的文件不再评估，也不记录调度事件
+	summary, err = proc.EvaluatePolicies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Scanned != 2 || summary.Matched != 0 || summary.Migrated != 0 {
+		t.Errorf("unexpected summary on second run: %+v", summary)
+	}
+	var scheduled int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM record_events WHERE event = ?", EventScheduled).Scan(&scheduled); err != nil || scheduled != 1 {
+		t.Errorf("scheduled events = %d, %v; want 1", scheduled, err)
+	}
+}
+
+func TestProcessor_EvaluateTierPolicies(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "cold")
+	if err := os.Mkdir(coldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	proc := env.newProcessor(t, WithTiers([]Tier{{
+		Dir:         coldDir,
+		UpdateAfter: 365 * 24 * time.Hour,
+		Policies:    []TieringPolicy{{Name: "large", MinSize: 6, MaxPlayCount: -1}},
+	}}))
+	env.addPlayData(t)
+
+	var warm []string
+	for i, name := range []string{"large.mkv", "small.mkv"} {
+		content := name
+		if name == "small.mkv" {
+			content = "small"
+		}
+		source := env.writeFile(t, env.sourceDir, name, content)
+		if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (?, ?)", i+1, source); err != nil {
+			t.Fatal(err)
+		}
+		id := env.migrate(t, proc, source)
+		// 模拟已清理源文件，停留时间远未达到 UpdateAfter
+		if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted' WHERE id = ?", id); err != nil {
+			t.Fatal(err)
+		}
+		warm = append(warm, filepath.Join(env.targetDir, name))
+	}
+
+	// 源目录没有策略，只评估目标目录中可以迁移到下一级的文件
+	summary, err := proc.EvaluatePolicies(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Scanned != 2 || summary.Matched != 1 || summary.Migrated != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	cold := filepath.Join(coldDir, "large.mkv")
+	if _, err := os.Stat(cold); err != nil {
+		t.Errorf("large file was not moved to the cold tier: %v", err)
+	}
+	if path := env.embyPath(t, 1); path != cold {
+		t.Errorf("emby path = %s, want %s", path, cold)
+	}
+	if _, err := os.Stat(warm[1]); err != nil {
+		t.Errorf("small file should stay in the target directory: %v", err)
+	}
+}
+
+func TestMatchPolicy(t *testing.T) {
+	now := time.Now()
+	policies := []TieringPolicy{
+		{Name: "large", MinSize: 100, MaxPlayCount: -1},
+		{Name: "unwatched", AddedBefore: time.Hour, MaxPlayCount: 0},
+	}
+
+	tests := []struct {
+		name  string
+		facts tieringFacts
+		want  string
+	}{
+		{"large file", tieringFacts{size: 200, added: now}, "large"},
+		{"old unwatched", tieringFacts{size: 10, added: now.Add(-2 * time.Hour)}, "unwatched"},
+		{"old but watched", tieringFacts{size: 10, added: now.Add(-2 * time.Hour), playCount: 3}, ""},
+		{"small and new", tieringFacts{size: 10, added: now}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if policy := matchPolicy(policies, tt.facts, now); policy != nil {
+				got = policy.Name
+			}
+			if got != tt.want {
+				t.Errorf("matchPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbyTime(t *testing.T) {
+	want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, v := range []interface{}{want, want.Format(time.RFC3339), "2024-05-06 07:08:09", want.Unix(), []byte("1714979289")} {
+		if got := embyTime(v); !got.Equal(want) {
+			t.Errorf("embyTime(%v) = %v, want %v", v, got, want)
+		}
+	}
+	if got := embyTime(nil); !got.IsZero() {
+		t.Errorf("embyTime(nil) = %v, want zero", got)
+	}
+}
@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Tier 目标目录之后的一级存储，文件在上一级停留 UpdateAfter 后继续迁移到 Dir
+type Tier struct {
+	Dir string
+	// 在上一级停留超过该时长后迁移，从上一跳完成时算起
+	UpdateAfter time.Duration
+	// 迁移到本级后多久删除上一级的文件，0表示不删除
+	DeleteAfter time.Duration
+	// 不为空时文件写入该存储后端，Dir 为Emby访问后端内容的路径（挂载点或服务地址），只能用于最后一级
+	Backend Backend
+	// 不为空时Emby条目改为指向生成的 .strm 文件，只能用于最后一级
+	Strm *Strm
+	// 不为空时本级文件和新建目录的属主及权限，代替 WithOwnership 的设置
+	Ownership *Ownership
+	// 上一级文件命中任一策略时不等 UpdateAfter 即迁移到本级，由 EvaluatePolicies 评估
+	Policies []TieringPolicy
+}
+
+// WithTiers 在目标目录之后追加多级存储，组成 源目录 → 目标目录 → tiers[0] → tiers[1] … 的迁移链。
+// 后续各跳由 AdvanceTiers 定期执行，每一跳是一条 parent_id 指向上一跳的记录
+func WithTiers(tiers []Tier) Option {
+	return func(p *Processor) {
+		p.tiers = tiers
+	}
+}
+
+// tierRoots 返回迁移链上的各级目录，第 i 跳从 roots[i] 迁移到 roots[i+1]
+func (p *Processor) tierRoots() []string {
+	roots := []string{p.sourceDir, p.targetDir}
+	for _, tier := range p.tiers {
+		roots = append(roots, tier.Dir)
+	}
+	return roots
+}
+
+// route 返回迁移 path 的跳数及该跳的源、目标目录。不在后续层级中的路径按第一跳处理
+func (p *Processor) route(path string) (hop int, from, to string) {
+	roots := p.tierRoots()
+	if _, ok := relWithin(p.sourceDir, path); !ok {
+		for i := 1; i < len(roots)-1; i++ {
+			if _, ok := relWithin(roots[i], path); ok {
+				return i, roots[i], roots[i+1]
+			}
+		}
+	}
+	return 0, p.sourceDir, p.targetDir
+}
+
+// rootOf 返回 path 所在的层级目录（不含源目录），都不在时返回目标目录
+func (p *Processor) rootOf(path string) string {
+	roots := p.tierRoots()
+	for i := len(roots) - 1; i >= 1; i-- {
+		if _, ok := relWithin(roots[i], path); ok {
+			return roots[i]
+		}
+	}
+	return p.targetDir
+}
+
+// deleteAfter 返回第 hop 跳完成后删除上一级文件的延迟
+func (p *Processor) deleteAfter(hop int) time.Duration {
+	if hop == 0 || hop > len(p.tiers) {
+		return p.deleteTime
+	}
+	return p.tiers[hop-1].DeleteAfter
+}
+
+// TierSummary 汇总一次多级迁移的结果
+type TierSummary struct {
+	Due      int // 到期可迁移到下一级的文件数
+	Migrated int
+	Failed   int
+}
+
+// AdvanceTiers 将在某一级停留超过该级 UpdateAfter 的文件迁移到下一级。
+// 只迁移上一级文件已删除（或不删除）的记录，避免与清理冲突
+func (p *Processor) AdvanceTiers(ctx context.Context) (*TierSummary, error) {
+	summary := &TierSummary{}
+	for i, tier := range p.tiers {
+		due, err := p.appDB.TierCandidates(i, time.Now().Add(-tier.UpdateAfter))
+		if err != nil {
+			return summary, fmt.Errorf("query files for tier %s: %w", tier.Dir, err)
+		}
+
+		for _, d := range due {
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			info, err := os.Stat(d.TargetPath)
+			if err != nil {
+				// 文件已被提升、撤销或手动移走
+				continue
+			}
+			summary.Due++
+			record := &model.FileRecord{
+				SourcePath:   d.TargetPath,
+				ModifiedTime: info.ModTime(),
+				Status:       "pending",
+			}
+			p.recordEvent(0, record.SourcePath, EventScheduled, "due for tier "+tier.Dir, nil)
+			if err := p.ProcessFile(ctx, record); err != nil {
+				p.logger.Error("migrate file to next tier", zap.Error(err), zap.String("path", d.TargetPath))
+				summary.Failed++
+				continue
+			}
+			summary.Migrated++
+		}
+	}
+
+	if summary.Due > 0 {
+		p.logger.Info("tier migration finished",
+			zap.Int("due", summary.Due),
+			zap.Int("migrated", summary.Migrated),
+			zap.Int("failed", summary.Failed))
+	}
+	return summary, nil
+}
+
+// Location 文件当前的位置及迁移历史
+type Location struct {
+	Current string
+	History []model.FileRecord // 从第一跳到最近一跳
+}
+
+// Locate 按原始路径或任一跳的路径查找文件当前的位置和完整迁移历史
+func (p *Processor) Locate(path string) (*Location, error) {
+	path = filepath.Clean(path)
+	record, err := p.appDB.LatestRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("no migration record for %s", path)
+	}
+
+	// 回溯到第一跳
+	for record.ParentID != 0 {
+		parent, err := p.appDB.Record(record.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			break
+		}
+		record = parent
+	}
+
+	loc := &Location{}
+	for record != nil {
+		loc.History = append(loc.History, *record)
+		if record, err = p.appDB.LatestChild(record.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	last := loc.History[len(loc.History)-1]
+	switch last.Status {
+	case "pending", "waiting_for_space", "reverted", "promoted", "failed":
+		loc.Current = last.SourcePath
+	default:
+		loc.Current = last.TargetPath
+	}
+	return loc, nil
+}
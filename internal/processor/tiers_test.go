@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_AdvanceTiers(t *testing.T) {
+	env := newTestEnv(t)
+	coldDir := filepath.Join(env.tmpDir, "cold")
+	if err := os.Mkdir(coldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	proc := env.newProcessor(t, WithTiers([]Tier{{Dir: coldDir, UpdateAfter: 30 * 24 * time.Hour}}))
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	first := env.migrate(t, proc, source)
+	warm := filepath.Join(env.targetDir, "show", "e01.mkv")
+
+	// 源文件尚未删除时不继续迁移
+	summary, err := proc.AdvanceTiers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Due != 0 {
+		t.Errorf("file moved on before its source was cleaned up: %+v", summary)
+	}
+
+	// 模拟已清理源文件、在目标目录停留超过 UpdateAfter
+	past := time.Now().Add(-40 * 24 * time.Hour)
+	if _, err := env.appDB.Exec("UPDATE file_records SET status = 'deleted', processed_time = ? WHERE id = ?", past, first); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = proc.AdvanceTiers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Due != 1 || summary.Migrated != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	cold := filepath.Join(coldDir, "show", "e01.mkv")
+	if _, err := os.Stat(cold); err != nil {
+		t.Fatalf("file was not moved to the cold tier: %v", err)
+	}
+	if _, err := os.Stat(warm); !os.IsNotExist(err) {
+		t.Errorf("warm copy still exists: %v", err)
+	}
+	if path := env.embyPath(t, 1); path != cold {
+		t.Errorf("emby path = %s, want %s", path, cold)
+	}
+
+	// 按原始路径查找当前位置和完整历史
+	loc, err := proc.Locate(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Current != cold {
+		t.Errorf("current location = %s, want %s", loc.Current, cold)
+	}
+	if len(loc.History) != 2 {
+		t.Fatalf("history has %d hops, want 2", len(loc.History))
+	}
+	second := loc.History[1]
+	if loc.History[0].ID != first || second.ParentID != first || second.Hop != 1 || second.SourcePath != warm {
+		t.Errorf("unexpected history: %+v", loc.History)
+	}
+
+	// 已继续迁移的记录需先撤销下一跳
+	if summary, err := proc.Undo(UndoSelector{ID: first}); err != nil || summary.Failed != 1 {
+		t.Errorf("undo of an earlier hop should fail: %+v, %v", summary, err)
+	}
+	if summary, err := proc.Undo(UndoSelector{ID: second.ID}); err != nil || summary.Reverted != 1 {
+		t.Fatalf("undo last hop: %+v, %v", summary, err)
+	}
+	if path := env.embyPath(t, 1); path != warm {
+		t.Errorf("emby path after undo = %s, want %s", path, warm)
+	}
+	if loc, err := proc.Locate(source); err != nil || loc.Current != warm {
+		t.Errorf("location after undo = %+v, %v", loc, err)
+	}
+}
@@ -0,0 +1,232 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trash 回收站配置，回收站目录需与源目录在同一文件系统上
+type trash struct {
+	dir       string
+	retention time.Duration
+	maxSize   int64
+}
+
+// WithTrash 启用回收站：清理时源文件移入 dir/<记录ID>/<相对路径>，
+// 超过 retention 或总大小超过 maxSize 字节时再真正删除
+func WithTrash(dir string, retention time.Duration, maxSize int64) Option {
+	return func(p *Processor) {
+		p.trash = &trash{
+			dir:       dir,
+			retention: retention,
+			maxSize:   maxSize,
+		}
+	}
+}
+
+func (p *Processor) moveToTrash(recordID int64, sourcePath string) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("stat source file: %w", err)
+	}
+
+	relPath, err := filepath.Rel(p.sourceDir, sourcePath)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return fmt.Errorf("source file %s is outside source directory", sourcePath)
+	}
+	trashPath := filepath.Join(p.trash.dir, strconv.FormatInt(recordID, 10), relPath)
+
+	if err := os.MkdirAll(filepath.Dir(trashPath), 0755); err != nil {
+		return fmt.Errorf("create trash directory: %w", err)
+	}
+
+	// 先记录再移动，文件进入回收站后总能找到对应的条目，移动失败时删除条目
+	now := time.Now()
+	entry := &model.TrashEntry{
+		RecordID:   recordID,
+		SourcePath: sourcePath,
+		TrashPath:  trashPath,
+		Size:       info.Size(),
+		Status:     "trashed",
+		TrashedAt:  now,
+		UpdatedAt:  now,
+	}
+	if p.trash.retention > 0 {
+		entry.ExpiresAt = now.Add(p.trash.retention)
+	}
+	if err := p.appDB.InsertTrashEntry(entry); err != nil {
+		return err
+	}
+	if err := os.Rename(sourcePath, trashPath); err != nil {
+		if delErr := p.appDB.DeleteTrashEntry(entry.ID); delErr != nil {
+			p.logger.Error("delete trash entry", zap.Error(delErr), zap.Int64("id", entry.ID))
+		}
+		return fmt.Errorf("move file to trash: %w", err)
+	}
+
+	p.logger.Info("moved file to trash",
+		zap.Int64("record_id", recordID),
+		zap.String("path", sourcePath),
+		zap.String("trash_path", trashPath))
+	return nil
+}
+
+// purgeTrash 删除过期的回收站文件，并在超出容量上限时从最早的开始清理
+func (p *Processor) purgeTrash() error {
+	expired, err := p.appDB.ExpiredTrash(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, entry := range expired {
+		if err := p.purgeTrashEntry(entry); err != nil {
+			p.logger.Error("purge trash entry", zap.Error(err), zap.Int64("id", entry.ID))
+		}
+	}
+
+	if p.trash.maxSize <= 0 {
+		return nil
+	}
+
+	total, err := p.appDB.TrashSize()
+	if err != nil {
+		return err
+	}
+	if total <= p.trash.maxSize {
+		return nil
+	}
+
+	entries, err := p.appDB.TrashedEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if total <= p.trash.maxSize {
+			break
+		}
+		if err := p.purgeTrashEntry(entry); err != nil {
+			p.logger.Error("purge trash entry", zap.Error(err), zap.Int64("id", entry.ID))
+			continue
+		}
+		total -= entry.Size
+	}
+
+	return nil
+}
+
+func (p *Processor) purgeTrashEntry(entry model.TrashEntry) error {
+	if err := os.Remove(entry.TrashPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove trash file: %w", err)
+	}
+	removeEmptyParents(filepath.Dir(entry.TrashPath), p.trash.dir)
+
+	if err := p.appDB.SetTrashStatus(entry.ID, "purged", time.Now()); err != nil {
+		return err
+	}
+
+	p.logger.Info("purged trash file",
+		zap.Int64("record_id", entry.RecordID),
+		zap.String("trash_path", entry.TrashPath))
+	return nil
+}
+
+// ListTrash 返回回收站中尚未清理的文件
+func (p *Processor) ListTrash() ([]model.TrashEntry, error) {
+	return p.appDB.TrashedEntries()
+}
+
+// RestoreTrash 将记录对应的回收站文件移回源路径。
+// 目标文件已不存在或 pointEmby 为 true 时，同时把Emby中的路径改回源路径
+func (p *Processor) RestoreTrash(recordID int64, pointEmby bool) error {
+	record, err := p.appDB.Record(recordID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("record %d not found", recordID)
+	}
+	_, release, err := p.claimRecord(context.Background(), recordID, record.SourcePath, record.Status)
+	if err != nil {
+		return err
+	}
+	defer release()
+	targetPath := record.TargetPath
+
+	entry, err := p.appDB.LatestTrashEntry(recordID)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("no trashed file for record %d", recordID)
+	}
+
+	if _, err := os.Lstat(entry.SourcePath); err == nil {
+		return fmt.Errorf("source path %s already exists", entry.SourcePath)
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.SourcePath), 0755); err != nil {
+		return fmt.Errorf("create source directory: %w", err)
+	}
+	if err := os.Rename(entry.TrashPath, entry.SourcePath); err != nil {
+		return fmt.Errorf("restore file from trash: %w", err)
+	}
+	removeEmptyParents(filepath.Dir(entry.TrashPath), p.trash.dir)
+
+	if !pointEmby {
+		if _, err := os.Stat(targetPath); errors.Is(err, os.ErrNotExist) {
+			pointEmby = true
+		}
+	}
+	if pointEmby {
+		if _, err := p.embyDB.Exec("UPDATE MediaItems SET Path = ? WHERE Path = ?",
+			entry.SourcePath, p.embyPath(targetPath)); err != nil {
+			return fmt.Errorf("update media items: %w", err)
+		}
+	}
+
+	now := time.Now()
+	err = p.appDB.InTx(func(q database.Queries) error {
+		if err := q.SetTrashStatus(entry.ID, "restored", now); err != nil {
+			return err
+		}
+		return q.SetRecordStatus(recordID, "restored", now)
+	})
+	if err != nil {
+		return err
+	}
+	details := "from " + entry.TrashPath
+	if pointEmby {
+		details += ", emby points to source"
+	}
+	p.recordEvent(recordID, entry.SourcePath, EventRestored, details, nil)
+
+	p.logger.Info("restored file from trash",
+		zap.Int64("record_id", recordID),
+		zap.String("path", entry.SourcePath),
+		zap.Bool("emby_updated", pointEmby))
+	return nil
+}
+
+// removeEmptyParents 从 dir 开始逐级向上删除空目录，直到 root（不含）
+func removeEmptyParents(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
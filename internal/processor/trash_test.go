@@ -0,0 +1,185 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessor_CleanupFilesTrash(t *testing.T) {
+	env := newTestEnv(t)
+	trashDir := filepath.Join(env.tmpDir, "trash")
+	proc := env.newProcessor(t, WithTrash(trashDir, time.Hour, 0))
+
+	source := env.writeFile(t, env.sourceDir, "show/s01e01.mkv", "episode")
+	target := env.writeFile(t, env.targetDir, "show/s01e01.mkv", "episode")
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Error("source was not removed")
+	}
+	trashPath := filepath.Join(trashDir, strconv.FormatInt(id, 10), "show", "s01e01.mkv")
+	if _, err := os.Stat(trashPath); err != nil {
+		t.Errorf("source was not moved to trash: %v", err)
+	}
+	if status := env.recordStatus(t, id); status != "deleted" {
+		t.Errorf("record status = %s, want deleted", status)
+	}
+
+	entries, err := proc.ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].RecordID != id || entries[0].Size != int64(len("episode")) {
+		t.Fatalf("unexpected trash entries: %+v", entries)
+	}
+	if entries[0].ExpiresAt.IsZero() {
+		t.Error("trash entry has no expiry")
+	}
+}
+
+func TestProcessor_PurgeTrash(t *testing.T) {
+	t.Run("expired", func(t *testing.T) {
+		env := newTestEnv(t)
+		trashDir := filepath.Join(env.tmpDir, "trash")
+		proc := env.newProcessor(t, WithTrash(trashDir, time.Hour, 0))
+
+		source := env.writeFile(t, env.sourceDir, "a.mkv", "a")
+		target := env.writeFile(t, env.targetDir, "a.mkv", "a")
+		id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+		if err := proc.CleanupFiles(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		// 让条目过期后再清理一次
+		if _, err := env.appDB.Exec("UPDATE trash_entries SET expires_at = ?", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := proc.CleanupFiles(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(trashDir, strconv.FormatInt(id, 10))); !os.IsNotExist(err) {
+			t.Error("expired trash file was not purged")
+		}
+		entries, err := proc.ListTrash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected empty trash, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("size cap", func(t *testing.T) {
+		env := newTestEnv(t)
+		trashDir := filepath.Join(env.tmpDir, "trash")
+		proc := env.newProcessor(t, WithTrash(trashDir, 0, 10))
+
+		past := time.Now().Add(-time.Hour)
+		first := env.writeFile(t, env.sourceDir, "first.mkv", "123456")
+		env.insertRecord(t, first, env.writeFile(t, env.targetDir, "first.mkv", "123456"), past)
+		if err := proc.CleanupFiles(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		second := env.writeFile(t, env.sourceDir, "second.mkv", "123456")
+		secondID := env.insertRecord(t, second, env.writeFile(t, env.targetDir, "second.mkv", "123456"), past)
+		if err := proc.CleanupFiles(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := proc.ListTrash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].RecordID != secondID {
+			t.Fatalf("expected only the newest entry to remain, got %+v", entries)
+		}
+	})
+}
+
+func TestProcessor_RestoreTrash(t *testing.T) {
+	env := newTestEnv(t)
+	trashDir := filepath.Join(env.tmpDir, "trash")
+	proc := env.newProcessor(t, WithTrash(trashDir, 0, 0))
+
+	source := env.writeFile(t, env.sourceDir, "movie/movie.mkv", "movie")
+	target := env.writeFile(t, env.targetDir, "movie/movie.mkv", "movie")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Path) VALUES (?)", target); err != nil {
+		t.Fatal(err)
+	}
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 目标文件丢失后，恢复时应同时把Emby指回源路径
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.RestoreTrash(id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(source); err != nil || string(content) != "movie" {
+		t.Errorf("source was not restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, strconv.FormatInt(id, 10))); !os.IsNotExist(err) {
+		t.Error("empty trash directory was not removed")
+	}
+	var count int
+	if err := env.embyDB.QueryRow("SELECT COUNT(*) FROM MediaItems WHERE Path = ?", source).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("emby path was not pointed back at the source")
+	}
+	if status := env.recordStatus(t, id); status != "restored" {
+		t.Errorf("record status = %s, want restored", status)
+	}
+
+	if err := proc.RestoreTrash(id, false); err == nil {
+		t.Error("expected error when restoring twice")
+	}
+
+	// 恢复的文件留在源路径，不再自动迁移
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("restored file was migrated again: %v", err)
+	}
+	var n int
+	if err := env.appDB.QueryRow("SELECT COUNT(*) FROM file_records WHERE source_path = ?", source).Scan(&n); err != nil || n != 1 {
+		t.Errorf("records for restored file = %d, %v", n, err)
+	}
+}
+
+func TestProcessor_MoveToTrashFailure(t *testing.T) {
+	env := newTestEnv(t)
+	trashDir := filepath.Join(env.tmpDir, "trash")
+	proc := env.newProcessor(t, WithTrash(trashDir, 0, 0))
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	// 回收站路径上已有非空目录，移动失败
+	env.writeFile(t, trashDir, "1/movie.mkv/keep", "keep")
+	if err := proc.moveToTrash(1, source); err == nil || !strings.Contains(err.Error(), "move file to trash") {
+		t.Fatalf("moveToTrash() = %v, want move error", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source file was moved: %v", err)
+	}
+	entries, err := proc.ListTrash()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("trash entries = %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/database"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UndoSelector 选择要撤销的迁移：按记录ID、路径或迁移时间范围，三者选其一
+type UndoSelector struct {
+	ID   int64
+	Path string    // 源路径或目标路径，撤销该路径最近一次迁移
+	From time.Time // 迁移时间范围 [From, To)，To 为零值时不设上限
+	To   time.Time
+}
+
+func (s UndoSelector) String() string {
+	switch {
+	case s.ID != 0:
+		return fmt.Sprintf("id=%d", s.ID)
+	case s.Path != "":
+		return "path=" + s.Path
+	case s.To.IsZero():
+		return "since=" + s.From.Format(time.RFC3339)
+	default:
+		return "since=" + s.From.Format(time.RFC3339) + " until=" + s.To.Format(time.RFC3339)
+	}
+}
+
+// UndoSummary 汇总一次撤销操作的结果
+type UndoSummary struct {
+	ActionID int64 // undo_actions 中的操作ID
+	Reverted int
+	Failed   int
+}
+
+// undoTarget 待撤销的迁移记录
+type undoTarget struct {
+	id         int64
+	sourcePath string
+	targetPath string
+	status     string
+}
+
+// Undo 撤销选中的迁移：把文件移回源路径（源文件尚未删除或仍在回收站时直接使用它），
+// 恢复该记录修改过的所有Emby条目，并将记录标记为 reverted。撤销后的文件不会再被自动迁移
+func (p *Processor) Undo(sel UndoSelector) (*UndoSummary, error) {
+	filter := database.UndoSelector(sel)
+	if filter.Path != "" {
+		filter.Path = filepath.Clean(filter.Path)
+	}
+	records, err := p.appDB.UndoCandidates(filter)
+	if err != nil {
+		return nil, fmt.Errorf("query records to undo: %w", err)
+	}
+	targets := make([]undoTarget, len(records))
+	for i, r := range records {
+		targets[i] = undoTarget{id: r.ID, sourcePath: r.SourcePath, targetPath: r.TargetPath, status: r.Status}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no migration matches %s", sel)
+	}
+
+	summary := &UndoSummary{}
+	if summary.ActionID, err = p.appDB.InsertUndoAction(sel.String(), time.Now()); err != nil {
+		return nil, err
+	}
+
+	for _, t := range targets {
+		if err := p.undoRecord(t, summary.ActionID); err != nil {
+			p.logger.Error("undo migration", zap.Error(err), zap.Int64("id", t.id), zap.String("path", t.sourcePath))
+			summary.Failed++
+			continue
+		}
+		summary.Reverted++
+	}
+
+	if err := p.appDB.FinishUndoAction(summary.ActionID, summary.Reverted, summary.Failed); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// sourcePlacement 记录源文件是如何放回源路径的
+type sourcePlacement struct {
+	from    string // 文件来源（目标文件或回收站文件），源文件本就存在时为空
+	copied  bool   // 跨文件系统复制，from 仍保留
+	trashID int64  // 来自回收站时的条目ID
+}
+
+func (p *Processor) undoRecord(t undoTarget, actionID int64) error {
+	_, release, err := p.claimRecord(context.Background(), t.id, t.sourcePath, t.status)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// 文件已继续迁移到下一级时需先撤销下一跳
+	if child, err := p.appDB.ActiveChildID(t.id); err != nil {
+		return err
+	} else if child != 0 {
+		return fmt.Errorf("file was migrated again by record %d, undo that first", child)
+	}
+
+	// 等待空间的记录还没有移动文件，也没有修改Emby
+	if t.status != "waiting_for_space" {
+		placed, err := p.placeSource(t)
+		if err != nil {
+			return err
+		}
+
+		if err := p.revertEmby(t); err != nil {
+			if placed.from != "" {
+				if rbErr := undoMove(placed.from, t.sourcePath, placed.copied); rbErr != nil {
+					p.logger.Error("roll back undo", zap.Error(rbErr), zap.String("path", t.sourcePath))
+				}
+			}
+			return err
+		}
+
+		if placed.trashID != 0 {
+			if placed.copied {
+				if err := os.Remove(placed.from); err != nil && !os.IsNotExist(err) {
+					p.logger.Warn("remove trash file", zap.Error(err), zap.String("path", placed.from))
+				}
+			}
+			if p.trash != nil {
+				removeEmptyParents(filepath.Dir(placed.from), p.trash.dir)
+			}
+			if err := p.appDB.SetTrashStatus(placed.trashID, "restored", time.Now()); err != nil {
+				p.logger.Error("update trash entry", zap.Error(err), zap.Int64("id", placed.trashID))
+			}
+		}
+
+		// 源文件已放回，删除目标路径上的副本
+		if err := p.removeTarget(context.Background(), t.targetPath); err != nil {
+			return fmt.Errorf("remove target file: %w", err)
+		}
+		removeEmptyParents(filepath.Dir(t.targetPath), p.rootOf(t.targetPath))
+		p.removeStrm(t.targetPath)
+	}
+
+	if err := p.appDB.MarkReverted(t.id, actionID, time.Now()); err != nil {
+		return err
+	}
+
+	p.recordEvent(t.id, t.sourcePath, EventReverted, fmt.Sprintf("undo %d", actionID), nil)
+
+	p.logger.Info("undid migration",
+		zap.Int64("id", t.id),
+		zap.Int64("undo_id", actionID),
+		zap.String("path", t.sourcePath))
+	return nil
+}
+
+// placeSource 确保源路径上有文件：源文件仍在时直接使用，否则从回收站或目标路径移回
+func (p *Processor) placeSource(t undoTarget) (sourcePlacement, error) {
+	if _, err := os.Lstat(t.sourcePath); err == nil {
+		return sourcePlacement{}, nil
+	} else if !os.IsNotExist(err) {
+		return sourcePlacement{}, fmt.Errorf("stat source file: %w", err)
+	}
+
+	placed := sourcePlacement{from: t.targetPath}
+	entry, err := p.appDB.LatestTrashEntry(t.id)
+	if err != nil {
+		return sourcePlacement{}, err
+	}
+	if entry != nil {
+		placed.from = entry.TrashPath
+		placed.trashID = entry.ID
+	} else if backend, _ := p.backendFor(t.targetPath); backend != nil {
+		// 从存储后端下载，对象在Emby恢复后删除
+		if err := os.MkdirAll(filepath.Dir(t.sourcePath), 0755); err != nil {
+			return sourcePlacement{}, fmt.Errorf("create source directory: %w", err)
+		}
+		if err := p.fetchTarget(context.Background(), t.id, t.targetPath, t.sourcePath); err != nil {
+			return sourcePlacement{}, fmt.Errorf("download file: %w", err)
+		}
+		placed.copied = true
+		return placed, nil
+	}
+	if _, err := os.Stat(placed.from); err != nil {
+		return sourcePlacement{}, fmt.Errorf("no copy of %s left to restore: %w", t.sourcePath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.sourcePath), 0755); err != nil {
+		return sourcePlacement{}, fmt.Errorf("create source directory: %w", err)
+	}
+	placed.copied = !p.sameDevice(placed.from, filepath.Dir(t.sourcePath))
+	if err := p.transferFile(context.Background(), placed.from, t.sourcePath, placed.copied); err != nil {
+		return sourcePlacement{}, fmt.Errorf("move file back: %w", err)
+	}
+	return placed, nil
+}
+
+// revertEmby 将记录修改过的Emby条目改回原路径，已被其他操作修改的条目保持不变。
+// 没有保存修改明细的旧记录按路径恢复
+func (p *Processor) revertEmby(t undoTarget) error {
+	changes, err := p.appDB.EmbyChanges(t.id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.embyDB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if len(changes) == 0 {
+		if _, err := tx.Exec("UPDATE MediaItems SET Path = ? WHERE Path = ?", t.sourcePath, p.embyPath(t.targetPath)); err != nil {
+			return fmt.Errorf("update media items: %w", err)
+		}
+	}
+	for _, c := range changes {
+		if _, err := tx.Exec("UPDATE MediaItems SET Path = ? WHERE Id = ? AND Path = ?",
+			c.OldPath, c.ItemID, c.NewPath); err != nil {
+			return fmt.Errorf("update media item %d: %w", c.ItemID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	if err := p.appDB.RevertEmbyChanges(t.id, time.Now()); err != nil {
+		p.logger.Error("update emby changes", zap.Error(err), zap.Int64("id", t.id))
+	}
+	return nil
+}
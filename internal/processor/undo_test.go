@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// embyPath 返回Emby条目当前的路径
+func (env *testEnv) embyPath(t *testing.T, itemID int64) string {
+	t.Helper()
+	var path string
+	if err := env.embyDB.QueryRow("SELECT Path FROM MediaItems WHERE Id = ?", itemID).Scan(&path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// migrate 处理 source 并返回新记录的ID
+func (env *testEnv) migrate(t *testing.T, proc *Processor, source string) int64 {
+	t.Helper()
+	record := &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}
+	if err := proc.ProcessFile(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	return record.ID
+}
+
+func TestProcessor_UndoMovedFile(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "show/e01.mkv", "episode")
+	for _, id := range []int64{1, 2} {
+		if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (?, ?)", id, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := env.migrate(t, proc, source)
+	target := filepath.Join(env.targetDir, "show", "e01.mkv")
+
+	// 迁移后被其他操作修改过的条目不应被撤销覆盖
+	if _, err := env.embyDB.Exec("UPDATE MediaItems SET Path = '/elsewhere.mkv' WHERE Id = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := proc.Undo(UndoSelector{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reverted != 1 || summary.Failed != 0 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	if content, err := os.ReadFile(source); err != nil || string(content) != "episode" {
+		t.Errorf("source was not moved back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(target)); !os.IsNotExist(err) {
+		t.Error("empty target directory was left behind")
+	}
+	if path := env.embyPath(t, 1); path != source {
+		t.Errorf("emby path = %s, want %s", path, source)
+	}
+	if path := env.embyPath(t, 2); path != "/elsewhere.mkv" {
+		t.Errorf("unrelated emby change was overwritten: %s", path)
+	}
+
+	var status string
+	var undoID int64
+	if err := env.appDB.QueryRow("SELECT status, undo_id FROM file_records WHERE id = ?", id).Scan(&status, &undoID); err != nil {
+		t.Fatal(err)
+	}
+	if status != "reverted" || undoID != summary.ActionID {
+		t.Errorf("record = %s/%d, want reverted/%d", status, undoID, summary.ActionID)
+	}
+
+	// 撤销后的文件不会被再次迁移
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Error("reverted file was migrated again")
+	}
+}
+
+func TestProcessor_UndoCopiedFileByPath(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	crossDevice(proc, &fakeDisk{free: 1 << 30, total: 1 << 31})
+
+	source := env.writeFile(t, env.sourceDir, "movie.mkv", "movie")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", source); err != nil {
+		t.Fatal(err)
+	}
+	id := env.migrate(t, proc, source)
+	target := filepath.Join(env.targetDir, "movie.mkv")
+
+	// 按目标路径撤销，源文件尚未删除，直接使用
+	if _, err := proc.Undo(UndoSelector{Path: target}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source is missing: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("target copy was not removed")
+	}
+	if path := env.embyPath(t, 1); path != source {
+		t.Errorf("emby path = %s, want %s", path, source)
+	}
+	if status := env.recordStatus(t, id); status != "reverted" {
+		t.Errorf("record status = %s, want reverted", status)
+	}
+}
+
+func TestProcessor_UndoFromTrashByTimeRange(t *testing.T) {
+	env := newTestEnv(t)
+	trashDir := filepath.Join(env.tmpDir, "trash")
+	proc := env.newProcessor(t, WithTrash(trashDir, time.Hour, 0))
+
+	source := env.writeFile(t, env.sourceDir, "a.mkv", "a")
+	target := env.writeFile(t, env.targetDir, "a.mkv", "a")
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Id, Path) VALUES (1, ?)", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	old := env.insertRecord(t, "/old/b.mkv", "/new/b.mkv", time.Now())
+	if _, err := env.appDB.Exec("UPDATE file_records SET processed_time = ? WHERE id = ?", time.Now().Add(-48*time.Hour), old); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := proc.Undo(UndoSelector{From: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reverted != 1 {
+		t.Fatalf("reverted %d records, want 1", summary.Reverted)
+	}
+
+	if content, err := os.ReadFile(source); err != nil || string(content) != "a" {
+		t.Errorf("source was not restored from trash: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("target was not removed")
+	}
+	if path := env.embyPath(t, 1); path != source {
+		t.Errorf("emby path = %s, want %s", path, source)
+	}
+	if entries, err := proc.ListTrash(); err != nil || len(entries) != 0 {
+		t.Errorf("trash entry was not marked restored: %+v, %v", entries, err)
+	}
+	if status := env.recordStatus(t, id); status != "reverted" {
+		t.Errorf("record status = %s, want reverted", status)
+	}
+	if status := env.recordStatus(t, old); status != "processed" {
+		t.Errorf("record outside the range was undone: %s", status)
+	}
+}
+
+func TestProcessor_UndoNoMatch(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+	if _, err := proc.Undo(UndoSelector{ID: 42}); err == nil {
+		t.Error("expected error for unknown record")
+	}
+}
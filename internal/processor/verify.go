@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"github.com/sleepstars/embypathrefresh/internal/notify"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// verifyOptions 删除源文件前的校验配置
+type verifyOptions struct {
+	checksum    bool
+	mountMarker string
+}
+
+// WithVerification 配置删除前校验：checksum 为 true 时迁移时记录目标文件的sha256并在删除前重新校验；
+// mountMarker 为目标目录下必须存在的标记文件，用于确认目标挂载点正常
+func WithVerification(checksum bool, mountMarker string) Option {
+	return func(p *Processor) {
+		p.verify = verifyOptions{
+			checksum:    checksum,
+			mountMarker: mountMarker,
+		}
+	}
+}
+
+// dueFile 到期待删除源文件的记录
+type dueFile struct {
+	id         int64
+	sourcePath string
+	targetPath string
+	size       int64
+	checksum   string
+	embyItems  int64
+}
+
+// record 返回传给钩子的记录
+func (f dueFile) record() *model.FileRecord {
+	return &model.FileRecord{
+		ID:         f.id,
+		SourcePath: f.sourcePath,
+		TargetPath: f.targetPath,
+		Status:     "processed",
+		FileSize:   f.size,
+		Checksum:   f.checksum,
+		EmbyItems:  f.embyItems,
+	}
+}
+
+// checkTargetMount 检查目标目录及后续各级目录的挂载点是否正常
+func (p *Processor) checkTargetMount() error {
+	dirs := []string{p.targetDir}
+	for _, tier := range p.tiers {
+		// 存储后端在上传和校验时检查
+		if tier.Backend == nil {
+			dirs = append(dirs, tier.Dir)
+		}
+	}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("target mount unhealthy: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("target mount unhealthy: %s is not a directory", dir)
+		}
+		if p.verify.mountMarker != "" {
+			if _, err := os.Stat(filepath.Join(dir, p.verify.mountMarker)); err != nil {
+				return fmt.Errorf("target mount unhealthy: marker: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyBeforeDelete 校验目标文件和Emby路径，返回阻止删除的原因，通过时返回空字符串
+func (p *Processor) verifyBeforeDelete(ctx context.Context, f dueFile) string {
+	size, err := p.statTarget(ctx, f.targetPath)
+	if err != nil {
+		return fmt.Sprintf("stat target: %v", err)
+	}
+	if f.size > 0 && size != f.size {
+		return fmt.Sprintf("target size %d does not match recorded size %d", size, f.size)
+	}
+	if p.verify.checksum && f.checksum != "" {
+		sum, err := p.targetChecksum(ctx, f.targetPath)
+		if err != nil {
+			return fmt.Sprintf("checksum target: %v", err)
+		}
+		if sum != f.checksum {
+			return fmt.Sprintf("target checksum %s does not match recorded checksum %s", sum, f.checksum)
+		}
+	}
+
+	var sourceRefs, targetRefs int
+	if err := p.embyDB.QueryRow("SELECT COUNT(*) FROM MediaItems WHERE Path = ?", f.sourcePath).Scan(&sourceRefs); err != nil {
+		return fmt.Sprintf("query emby source path: %v", err)
+	}
+	if sourceRefs > 0 {
+		return fmt.Sprintf("emby still references source path in %d items", sourceRefs)
+	}
+	if f.embyItems > 0 {
+		if err := p.embyDB.QueryRow("SELECT COUNT(*) FROM MediaItems WHERE Path = ?", p.embyPath(f.targetPath)).Scan(&targetRefs); err != nil {
+			return fmt.Sprintf("query emby target path: %v", err)
+		}
+		if targetRefs == 0 {
+			return "emby no longer references target path"
+		}
+	}
+
+	return ""
+}
+
+func (p *Processor) blockDelete(f dueFile, reason string) {
+	p.logger.Warn("source deletion blocked",
+		zap.Int64("id", f.id),
+		zap.String("path", f.sourcePath),
+		zap.String("reason", reason))
+
+	if err := p.appDB.BlockRecord(f.id, reason, time.Now()); err != nil {
+		p.logger.Error("update record status", zap.Error(err), zap.Int64("id", f.id))
+	}
+	p.recordEvent(f.id, f.sourcePath, EventDeleteBlocked, reason, nil)
+	p.notifyRecord(notify.EventDeleteBlocked, f.record(), reason)
+}
+
+// BlockedRecords 返回删除被阻止的记录
+func (p *Processor) BlockedRecords() ([]model.FileRecord, error) {
+	return p.appDB.BlockedRecords()
+}
+
+// RetryBlocked 将删除被阻止的记录重新放回待删除队列，id 为0时处理全部，返回受影响的记录数
+func (p *Processor) RetryBlocked(id int64) (int64, error) {
+	retried, err := p.appDB.RetryBlocked(id, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	// 更新提交后再写审计事件
+	for _, r := range retried {
+		p.recordEvent(r.ID, r.SourcePath, EventDeletionScheduled, "retry after block", nil)
+	}
+	return int64(len(retried)), nil
+}
+
+// fileChecksum 计算文件的sha256
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,162 @@
+package processor
+
+import (
+	"context"
+	"github.com/sleepstars/embypathrefresh/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_ProcessFileRecordsChecksum(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t, WithVerification(true, ""))
+
+	source := env.writeFile(t, env.sourceDir, "a.mkv", "content")
+	if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Path) VALUES (?)", source); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.ProcessFile(context.Background(), &model.FileRecord{SourcePath: source, ModifiedTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var size, embyItems int64
+	var checksum string
+	err := env.appDB.QueryRow("SELECT file_size, checksum, emby_items FROM file_records WHERE source_path = ?", source).
+		Scan(&size, &checksum, &embyItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := fileChecksum(filepath.Join(env.targetDir, "a.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("content")) || checksum != want || embyItems != 1 {
+		t.Errorf("got size=%d checksum=%s emby_items=%d", size, checksum, embyItems)
+	}
+}
+
+func TestProcessor_CleanupFilesVerification(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, env *testEnv, id int64, source, target string)
+		blocked bool
+	}{
+		{
+			name:  "valid target",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {},
+		},
+		{
+			name: "target missing",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {
+				os.Remove(target)
+			},
+			blocked: true,
+		},
+		{
+			name: "size mismatch",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {
+				env.appDB.Exec("UPDATE file_records SET file_size = 999 WHERE id = ?", id)
+			},
+			blocked: true,
+		},
+		{
+			name: "checksum mismatch",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {
+				os.WriteFile(target, []byte("corrupt"), 0644)
+			},
+			blocked: true,
+		},
+		{
+			name: "emby still references source",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {
+				env.embyDB.Exec("UPDATE MediaItems SET Path = ?", source)
+			},
+			blocked: true,
+		},
+		{
+			name: "emby lost target",
+			setup: func(t *testing.T, env *testEnv, id int64, source, target string) {
+				env.embyDB.Exec("DELETE FROM MediaItems")
+			},
+			blocked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			proc := env.newProcessor(t, WithVerification(true, ""))
+
+			source := env.writeFile(t, env.sourceDir, "a.mkv", "content")
+			target := env.writeFile(t, env.targetDir, "a.mkv", "content")
+			checksum, err := fileChecksum(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := env.embyDB.Exec("INSERT INTO MediaItems (Path) VALUES (?)", target); err != nil {
+				t.Fatal(err)
+			}
+			id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+			if _, err := env.appDB.Exec("UPDATE file_records SET file_size = ?, checksum = ?, emby_items = 1 WHERE id = ?",
+				len("content"), checksum, id); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, env, id, source, target)
+
+			summary, err := proc.cleanup(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, statErr := os.Stat(source)
+			status := env.recordStatus(t, id)
+			if tt.blocked {
+				if status != "delete_blocked" || summary.Blocked != 1 || os.IsNotExist(statErr) {
+					t.Errorf("expected deletion to be blocked, status=%s summary=%+v", status, summary)
+				}
+				var reason string
+				env.appDB.QueryRow("SELECT block_reason FROM file_records WHERE id = ?", id).Scan(&reason)
+				if reason == "" {
+					t.Error("block reason was not recorded")
+				}
+			} else if status != "deleted" || summary.Deleted != 1 || !os.IsNotExist(statErr) {
+				t.Errorf("expected source to be deleted, status=%s summary=%+v", status, summary)
+			}
+		})
+	}
+}
+
+func TestProcessor_RetryBlocked(t *testing.T) {
+	env := newTestEnv(t)
+	proc := env.newProcessor(t)
+
+	source := env.writeFile(t, env.sourceDir, "a.mkv", "content")
+	target := filepath.Join(env.targetDir, "a.mkv")
+	id := env.insertRecord(t, source, target, time.Now().Add(-time.Hour))
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := proc.BlockedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != id || records[0].BlockReason == "" {
+		t.Fatalf("unexpected blocked records: %+v", records)
+	}
+
+	// 补上目标文件后重试，应当正常删除
+	env.writeFile(t, env.targetDir, "a.mkv", "content")
+	n, err := proc.RetryBlocked(id)
+	if err != nil || n != 1 {
+		t.Fatalf("RetryBlocked() = %d, %v", n, err)
+	}
+	if err := proc.CleanupFiles(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.recordStatus(t, id); status != "deleted" {
+		t.Errorf("record status = %s, want deleted", status)
+	}
+}
@@ -1,17 +1,20 @@
 package watcher
 
 import (
+	"context"
+	"errors"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sleepstars/embypathrefresh/internal/model"
 	"go.uber.org/zap"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 type FileProcessor interface {
-	ProcessFile(record *model.FileRecord) error
+	ProcessFile(ctx context.Context, record *model.FileRecord) error
 }
 
 type Watcher struct {
@@ -23,6 +26,10 @@ type Watcher struct {
 	mu         sync.Mutex
 	// 添加一个map来跟踪最后修改时间
 	lastModified map[string]time.Time
+	// 已添加监控的目录
+	watched map[string]bool
+	// 等待事件循环退出
+	wg sync.WaitGroup
 }
 
 func New(sourceDir string, updateTime time.Duration, processor FileProcessor, logger *zap.Logger) (*Watcher, error) {
@@ -38,48 +45,62 @@ func New(sourceDir string, updateTime time.Duration, processor FileProcessor, lo
 		logger:       logger,
 		updateTime:   updateTime,
 		lastModified: make(map[string]time.Time),
+		watched:      make(map[string]bool),
 	}
 
 	return w, nil
 }
 
-func (w *Watcher) Start() error {
+// Start 开始监控，ctx 传给 ProcessFile，取消后事件循环退出
+func (w *Watcher) Start(ctx context.Context) error {
 	// 递归添加所有子目录
 	if err := filepath.Walk(w.sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
-			return w.watcher.Add(path)
+			return w.addWatch(path)
 		}
 		return nil
 	}); err != nil {
 		return err
 	}
 
-	go w.watchLoop()
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.watchLoop(ctx)
+	}()
 	return nil
 }
 
-func (w *Watcher) watchLoop() {
+func (w *Watcher) watchLoop(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
+
 		case event, ok := <-w.watcher.Events:
 			if !ok {
 				return
 			}
 			
 			if event.Op&fsnotify.Write == fsnotify.Write {
-				w.handleFileModification(event.Name)
+				w.handleFileModification(ctx, event.Name)
 			}
 			
 			// 如果有新目录创建，添加到监控列表
 			if event.Op&fsnotify.Create == fsnotify.Create {
 				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
-					w.watcher.Add(event.Name)
+					w.addWatch(event.Name)
 				}
 			}
 
+			// 目录被删除或移走时移除监控
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				w.removeWatch(event.Name)
+			}
+
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
 				return
@@ -89,7 +110,7 @@ func (w *Watcher) watchLoop() {
 	}
 }
 
-func (w *Watcher) handleFileModification(path string) {
+func (w *Watcher) handleFileModification(ctx context.Context, path string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -112,11 +133,52 @@ func (w *Watcher) handleFileModification(path string) {
 		Status:      "pending",
 	}
 
-	if err := w.processor.ProcessFile(record); err != nil {
+	if err := w.processor.ProcessFile(ctx, record); err != nil {
 		w.logger.Error("process file error", zap.Error(err), zap.String("path", path))
 	}
 }
 
+func (w *Watcher) addWatch(path string) error {
+	if err := w.watcher.Add(path); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.watched[path] = true
+	w.mu.Unlock()
+	return nil
+}
+
+// removeWatch 移除目录及其子目录的监控，path 不是已监控的目录时忽略
+func (w *Watcher) removeWatch(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.watched[path] {
+		return
+	}
+	prefix := path + string(filepath.Separator)
+	for dir := range w.watched {
+		if dir != path && !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+		// 目录已删除时底层可能已经自动移除了监控
+		if err := w.watcher.Remove(dir); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+			w.logger.Debug("remove watch", zap.Error(err), zap.String("path", dir))
+		}
+		delete(w.watched, dir)
+	}
+}
+
+// WatchedDirs 返回当前监控的目录数量
+func (w *Watcher) WatchedDirs() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.watched)
+}
+
+// Close 停止监控，并等待正在处理的文件完成
 func (w *Watcher) Close() error {
-	return w.watcher.Close()
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
 }
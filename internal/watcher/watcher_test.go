@@ -1,6 +1,7 @@
 package watcher
 
 import (
+	"context"
 	"github.com/sleepstars/embypathrefresh/internal/model"
 	"go.uber.org/zap"
 	"os"
@@ -23,7 +24,7 @@ func newMockProcessor(t *testing.T) *mockProcessor {
 	}
 }
 
-func (m *mockProcessor) ProcessFile(record *model.FileRecord) error {
+func (m *mockProcessor) ProcessFile(ctx context.Context, record *model.FileRecord) error {
 	m.mu.Lock()
 	m.processedFiles[record.SourcePath] = true
 	m.mu.Unlock()
@@ -58,7 +59,7 @@ func TestWatcher(t *testing.T) {
 	defer w.Close()
 
 	// 启动观察器
-	if err := w.Start(); err != nil {
+	if err := w.Start(context.Background()); err != nil {
 		t.Fatalf("Start() error = %v", err)
 	}
 
@@ -106,3 +107,99 @@ func TestWatcher(t *testing.T) {
 		t.Error("subdirectory file was not processed")
 	}
 }
+
+func TestWatcher_RemovedDirectories(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"show/season1", "movie"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := New(tmpDir, 500*time.Millisecond, newMockProcessor(t), zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer w.Close()
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if n := w.WatchedDirs(); n != 4 {
+		t.Fatalf("WatchedDirs() = %d, want 4", n)
+	}
+
+	// 删除整个剧集目录后，其本身和子目录的监控都应被移除
+	if err := os.RemoveAll(filepath.Join(tmpDir, "show")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for w.WatchedDirs() != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := w.WatchedDirs(); n != 2 {
+		t.Errorf("WatchedDirs() = %d after removing directory, want 2", n)
+	}
+}
+
+// blockingProcessor 在 release 关闭前阻塞 ProcessFile，用于模拟正在进行的迁移
+type blockingProcessor struct {
+	started chan struct{}
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (b *blockingProcessor) ProcessFile(ctx context.Context, record *model.FileRecord) error {
+	close(b.started)
+	<-b.release
+	close(b.done)
+	return nil
+}
+
+func TestWatcher_CloseWaitsForInFlight(t *testing.T) {
+	tmpDir := t.TempDir()
+	processor := &blockingProcessor{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	w, err := New(tmpDir, time.Hour, processor, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-processor.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("file was not processed")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a file was still being processed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(processor.release)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() did not return after processing finished")
+	}
+	select {
+	case <-processor.done:
+	default:
+		t.Error("in-flight processing did not finish")
+	}
+}